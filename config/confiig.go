@@ -26,6 +26,32 @@ type RedisConfig struct {
 	DB       int    `json:"db"`
 }
 
+// BlobStoreConfig selects where attachments and message archives are kept:
+// the local filesystem or an S3-compatible bucket
+type BlobStoreConfig struct {
+	Driver      string `json:"driver"` // local, s3
+	Path        string `json:"path"`
+	S3Endpoint  string `json:"s3_endpoint"`
+	S3Region    string `json:"s3_region"`
+	S3Bucket    string `json:"s3_bucket"`
+	S3AccessKey string `json:"-"`
+	S3SecretKey string `json:"-"`
+	S3PathStyle bool   `json:"s3_path_style"`
+}
+
+// ReputationConfig holds the limits of the sender and campaign circuit
+// breakers. Rates are percentages over the rolling window and only checked
+// once MinSends emails were attempted in it.
+type ReputationConfig struct {
+	WindowHours      int     `json:"window_hours"`
+	MinSends         int     `json:"min_sends"`
+	MaxBounceRate    float64 `json:"max_bounce_rate"`
+	MaxComplaintRate float64 `json:"max_complaint_rate"`
+	MaxDeferralRate  float64 `json:"max_deferral_rate"`
+	MaxSenderErrors  int     `json:"max_sender_errors"` // failed sends in a row, such as refused logins, that pause a sender; 0 disables
+	CoolDownHours    int     `json:"cool_down_hours"`   // 0 keeps a paused sender paused until acknowledged
+}
+
 type OAuthConfig struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
@@ -33,31 +59,41 @@ type OAuthConfig struct {
 }
 
 type Config struct {
-	Environment          string      `json:"environment"`
-	Google               OAuthConfig `json:"google"`
-	Microsoft            OAuthConfig `json:"microsoft"`
-	Yahoo                OAuthConfig `json:"yahoo"`
-	EncryptionKey        string      `json:"-"`
-	ServerPort           string      `json:"server_port"`
-	DBHost               string      `json:"db_host"`
-	DBPort               string      `json:"db_port"`
-	DBUser               string      `json:"db_user"`
-	DBPassword           string      `json:"-"`
-	DBName               string      `json:"db_name"`
-	DBSSLMode            string      `json:"db_ssl_mode"`
-	DBMaxIdleConns       int         `json:"db_max_idle_conns"`
-	DBMaxOpenConns       int         `json:"db_max_open_conns"`
-	StripeSecretKey      string      `json:"stripe_secret_key"`
-	StripePublishableKey string      `json:"stripe_publishable_key"`
-	StripeWebhookSecret  string      `json:"stripe_webhook_secret"`
-	WarmupEmail          string      `json:"warmup_email"`
-	RateLimitTestSender  int         `json:"rate_limit_test_sender"`
-	Redis                RedisConfig `json:"redis"`
-	SMTPHost             string      `json:"smtp_host"`
-	SMTPPort             string      `json:"smtp_port"`
-	SMTPUsername         string      `json:"smtp_username"`
-	SMTPPassword         string      `json:"smtp_password"`
-	FromEmail            string      `json:"from_email"`
+	Environment          string           `json:"environment"`
+	Google               OAuthConfig      `json:"google"`
+	Microsoft            OAuthConfig      `json:"microsoft"`
+	Yahoo                OAuthConfig      `json:"yahoo"`
+	EncryptionKey        string           `json:"-"`
+	TrackingSecret       string           `json:"-"`
+	ServerPort           string           `json:"server_port"`
+	BaseURL              string           `json:"base_url"`
+	DBHost               string           `json:"db_host"`
+	DBPort               string           `json:"db_port"`
+	DBUser               string           `json:"db_user"`
+	DBPassword           string           `json:"-"`
+	DBName               string           `json:"db_name"`
+	DBSSLMode            string           `json:"db_ssl_mode"`
+	DBMaxIdleConns       int              `json:"db_max_idle_conns"`
+	DBMaxOpenConns       int              `json:"db_max_open_conns"`
+	StripeSecretKey      string           `json:"stripe_secret_key"`
+	StripePublishableKey string           `json:"stripe_publishable_key"`
+	StripeWebhookSecret  string           `json:"stripe_webhook_secret"`
+	WarmupEmail          string           `json:"warmup_email"`
+	RateLimitTestSender  int              `json:"rate_limit_test_sender"`
+	Redis                RedisConfig      `json:"redis"`
+	SMTPHost             string           `json:"smtp_host"`
+	SMTPPort             string           `json:"smtp_port"`
+	SMTPUsername         string           `json:"smtp_username"`
+	SMTPPassword         string           `json:"smtp_password"`
+	FromEmail            string           `json:"from_email"`
+	GeoIPDBPath          string           `json:"geoip_db_path"`
+	BlobStore            BlobStoreConfig  `json:"blob_store"`
+	ArchiveRetentionDays int              `json:"archive_retention_days"` // 0 keeps sent message archives forever
+	VERPDomain           string           `json:"verp_domain"`            // bounce domain for the VERP return paths of senders with UseVERP
+	BounceSMTPAddr       string           `json:"bounce_smtp_addr"`       // listen address of the inbound bounce receiver; empty disables it
+	Reputation           ReputationConfig `json:"reputation"`
+	IMAPIdleConnections  int              `json:"imap_idle_connections"`   // most IMAP IDLE sessions kept open; 0 leaves every mailbox to polling
+	UniboxStorageQuotaMB int              `json:"unibox_storage_quota_mb"` // attachment storage per user unless the user has a quota of their own
 }
 
 func init() {
@@ -85,7 +121,9 @@ func LoadConfig() error {
 			RedirectURI:  getEnv("YAHOO_REDIRECT_URI", ""),
 		},
 		EncryptionKey:  getEnv("ENCRYPTION_KEY", ""),
+		TrackingSecret: getEnv("TRACKING_SECRET", ""),
 		ServerPort:     getEnv("SERVER_PORT", "5000"),
+		BaseURL:        getEnv("BASE_URL", "http://localhost:5000"),
 		DBHost:         getEnv("DB_HOST", "localhost"),
 		DBPort:         getEnv("DB_PORT", "5432"),
 		DBUser:         getEnv("DB_USER", "postgres"),
@@ -99,6 +137,40 @@ func LoadConfig() error {
 		StripePublishableKey: getEnv("STRIPE_PUBLISHABLE_KEY", ""),
 		StripeWebhookSecret:  getEnv("STRIPE_WEBHOOK_SECRET", ""),
 		WarmupEmail:          getEnv("WARMUP_EMAIL_RECIPIENT", "default_warmup_target@example.com"), // <--- POPULATE IT
+		GeoIPDBPath:          getEnv("GEOIP_DB_PATH", ""),
+		ArchiveRetentionDays: getEnvAsInt("ARCHIVE_RETENTION_DAYS", 365),
+		VERPDomain:           getEnv("VERP_DOMAIN", ""),
+		BounceSMTPAddr:       getEnv("BOUNCE_SMTP_ADDR", ""),
+		IMAPIdleConnections:  getEnvAsInt("IMAP_IDLE_CONNECTIONS", 200),
+		UniboxStorageQuotaMB: getEnvAsInt("UNIBOX_STORAGE_QUOTA_MB", 1024),
+
+		Redis: RedisConfig{
+			Enabled:  getEnv("REDIS_ENABLED", "false") == "true",
+			Address:  getEnv("REDIS_ADDRESS", "localhost:6379"),
+			Password: getEnv("REDIS_PASSWORD", ""),
+			DB:       getEnvAsInt("REDIS_DB", 0),
+		},
+
+		Reputation: ReputationConfig{
+			WindowHours:      getEnvAsInt("REPUTATION_WINDOW_HOURS", 24),
+			MinSends:         getEnvAsInt("REPUTATION_MIN_SENDS", 50),
+			MaxBounceRate:    getEnvAsFloat("REPUTATION_MAX_BOUNCE_RATE", 5),
+			MaxComplaintRate: getEnvAsFloat("REPUTATION_MAX_COMPLAINT_RATE", 0.3),
+			MaxDeferralRate:  getEnvAsFloat("REPUTATION_MAX_DEFERRAL_RATE", 20),
+			MaxSenderErrors:  getEnvAsInt("REPUTATION_MAX_SENDER_ERRORS", 10),
+			CoolDownHours:    getEnvAsInt("REPUTATION_COOL_DOWN_HOURS", 24),
+		},
+
+		BlobStore: BlobStoreConfig{
+			Driver:      getEnv("BLOB_STORE_DRIVER", "local"),
+			Path:        getEnv("BLOB_STORE_PATH", "data/blobs"),
+			S3Endpoint:  getEnv("S3_ENDPOINT", ""),
+			S3Region:    getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:    getEnv("S3_BUCKET", ""),
+			S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+			S3PathStyle: getEnv("S3_PATH_STYLE", "false") == "true",
+		},
 	}
 
 	// Validate required configurations
@@ -190,6 +262,19 @@ func getEnvAsInt(key string, fallback int) int {
 	return value
 }
 
+func getEnvAsFloat(key string, fallback float64) float64 {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return fallback
+	}
+	var value float64
+	_, err := fmt.Sscanf(valueStr, "%g", &value)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 func maskPassword(dsn string) string {
 	const passwordMarker = "password="
 	startIdx := strings.Index(dsn, passwordMarker)
@@ -275,6 +360,9 @@ func migrateDB(db *gorm.DB) error {
 		&models.APIKey{},
 		&models.Unsubscribe{},
 		&models.Bounce{},
+		&models.Notification{},
+		&models.Suppression{},
+		&models.CampaignAttachment{},
 		&models.Template{},
 		&models.Sequence{},
 		&models.SequenceStep{},
@@ -285,5 +373,7 @@ func migrateDB(db *gorm.DB) error {
 		&models.UniboxEmail{},
 		&models.UniboxFolder{},
 		&models.UniboxEmailFolder{},
+		&models.UniboxAttachment{},
+		&models.MailboxSyncState{},
 	)
 }
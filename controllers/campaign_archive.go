@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSentMessage returns the archived MIME of a sent campaign email as a
+// .eml download
+func (cc *CampaignController) GetSentMessage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var activity models.CampaignActivity
+	if err := cc.DB.Where("id = ? AND campaign_id = ? AND user_id = ?", c.Params("activityId"), c.Params("id"), user.ID).
+		First(&activity).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Activity not found",
+		})
+	}
+
+	if activity.ArchiveKey == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No archived copy of this message is available",
+		})
+	}
+
+	raw, err := utils.LoadArchivedMessage(activity.ArchiveKey)
+	if errors.Is(err, utils.ErrBlobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Archived message has expired",
+		})
+	}
+	if err != nil {
+		cc.Logger.Printf("Failed to load archived message for activity %d: %v", activity.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load archived message",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "message/rfc822")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="message-%d.eml"`, activity.ID))
+	return c.Send(raw)
+}
@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"strings"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	maxAttachmentSize      = 10 << 20 // per file
+	maxCampaignAttachments = 20 << 20 // per campaign, most providers reject larger messages
+)
+
+// UploadCampaignAttachment stores a file that email nodes can attach.
+// Send inline=true to embed an image; the HTML references it as cid:<content_id>.
+func (cc *CampaignController) UploadCampaignAttachment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var campaign models.Campaign
+	if err := cc.DB.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&campaign).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign not found",
+		})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File upload error",
+		})
+	}
+	if file.Size > maxAttachmentSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File too large (max 10MB)",
+		})
+	}
+
+	var used int64
+	cc.DB.Model(&models.CampaignAttachment{}).
+		Where("campaign_id = ?", campaign.ID).
+		Select("COALESCE(SUM(size), 0)").
+		Scan(&used)
+	if used+file.Size > maxCampaignAttachments {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Campaign attachments exceed 20MB in total",
+		})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to open file",
+		})
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	filename := filepath.Base(file.Filename)
+	attachment := models.CampaignAttachment{
+		UserID:      user.ID,
+		CampaignID:  campaign.ID,
+		Filename:    filename,
+		ContentType: utils.DetectContentType(filename, http.DetectContentType(data)),
+		Size:        int64(len(data)),
+		StorageKey:  fmt.Sprintf("attachments/%d/%d/%s", user.ID, campaign.ID, uuid.New().String()),
+		Inline:      c.FormValue("inline") == "true",
+	}
+
+	if attachment.Inline {
+		if !strings.HasPrefix(attachment.ContentType, "image/") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Only images can be embedded inline",
+			})
+		}
+		attachment.ContentID = strings.Trim(c.FormValue("content_id"), "<> ")
+		if attachment.ContentID == "" {
+			attachment.ContentID = uuid.New().String()
+		}
+	}
+
+	if err := utils.GetBlobStore().Put(attachment.StorageKey, data, attachment.ContentType); err != nil {
+		cc.Logger.Printf("Failed to store attachment: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store attachment",
+		})
+	}
+
+	if err := cc.DB.Create(&attachment).Error; err != nil {
+		utils.GetBlobStore().Delete(attachment.StorageKey)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save attachment",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// GetCampaignAttachments lists the files uploaded for a campaign
+func (cc *CampaignController) GetCampaignAttachments(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var attachments []models.CampaignAttachment
+	if err := cc.DB.Where("campaign_id = ? AND user_id = ?", c.Params("id"), user.ID).
+		Order("created_at ASC").
+		Find(&attachments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch attachments",
+		})
+	}
+
+	return c.JSON(attachments)
+}
+
+// DeleteCampaignAttachment removes an attachment and its stored content.
+// Attachments an email node still lists, and those of a campaign that is
+// sending, are kept.
+func (cc *CampaignController) DeleteCampaignAttachment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var attachment models.CampaignAttachment
+	if err := cc.DB.Where("id = ? AND campaign_id = ? AND user_id = ?", c.Params("attachmentId"), c.Params("id"), user.ID).
+		First(&attachment).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment not found",
+		})
+	}
+
+	var campaign models.Campaign
+	if err := cc.DB.Select("id", "status").First(&campaign, attachment.CampaignID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign not found",
+		})
+	}
+	if campaign.Status == "sending" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Attachments can not be deleted while the campaign is sending; pause it first",
+		})
+	}
+
+	var flows []models.CampaignFlow
+	if err := cc.DB.Where("campaign_id = ?", campaign.ID).Find(&flows).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check where the attachment is used",
+		})
+	}
+	for _, flow := range flows {
+		for _, node := range flow.Nodes {
+			for _, id := range node.Data.AttachmentIDs {
+				if id != attachment.ID {
+					continue
+				}
+				label := node.Data.Label
+				if label == "" {
+					label = node.ID
+				}
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error":   fmt.Sprintf("Attachment is used by email step %q; remove it from the step first", label),
+					"node_id": node.ID,
+				})
+			}
+		}
+	}
+
+	if err := cc.DB.Unscoped().Delete(&attachment).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete attachment",
+		})
+	}
+	if err := utils.GetBlobStore().Delete(attachment.StorageKey); err != nil {
+		cc.Logger.Printf("Failed to delete attachment content %s: %v", attachment.StorageKey, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Attachment deleted successfully",
+	})
+}
+
+// loadNodeAttachments reads the attachments an email node refers to; an
+// attachment listed twice is attached once
+func (cc *CampaignController) loadNodeAttachments(campaignID uint, ids []uint) ([]utils.Attachment, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	ids = unique
+
+	var records []models.CampaignAttachment
+	if err := cc.DB.Where("campaign_id = ? AND id IN ?", campaignID, ids).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	if len(records) != len(ids) {
+		return nil, fmt.Errorf("email node references %d attachments, found %d", len(ids), len(records))
+	}
+
+	attachments := make([]utils.Attachment, 0, len(records))
+	for _, record := range records {
+		data, err := utils.GetBlobStore().Get(record.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load attachment %s: %v", record.Filename, err)
+		}
+		attachment := utils.Attachment{
+			Filename:    record.Filename,
+			ContentType: record.ContentType,
+			Data:        data,
+		}
+		if record.Inline {
+			attachment.ContentID = record.ContentID
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// validateEmailNodes checks the envelope settings of a flow's email nodes
+func validateEmailNodes(nodes []models.CampaignNode) error {
+	for _, node := range nodes {
+		if node.Type != "email" {
+			continue
+		}
+		data := node.Data
+
+		addresses := append([]string{}, data.CC...)
+		addresses = append(addresses, data.BCC...)
+		if data.ReplyTo != "" {
+			addresses = append(addresses, data.ReplyTo)
+		}
+		for _, addr := range addresses {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return fmt.Errorf("invalid email address %q in node %s", addr, node.ID)
+			}
+		}
+
+		for key, value := range data.Headers {
+			if err := utils.ValidateCustomHeader(key, value); err != nil {
+				return fmt.Errorf("node %s: %v", node.ID, err)
+			}
+		}
+	}
+	return nil
+}
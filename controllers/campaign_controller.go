@@ -16,7 +16,8 @@ type CampaignController struct {
 
 func NewCampaignController(db *gorm.DB, logger *log.Logger) *CampaignController {
 	return &CampaignController{
-		DB:     db,
-		Logger: logger,
+		DB:          db,
+		Logger:      logger,
+		MailService: utils.SenderMailer(),
 	}
 }
@@ -58,6 +58,12 @@ func (cc *CampaignController) CreateCampaign(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := validateEmailNodes(input.Flow.Nodes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Start transaction
 	tx := cc.DB.Begin()
 
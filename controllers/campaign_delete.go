@@ -69,10 +69,3 @@ func (cc *CampaignController) DeleteCampaign(c *fiber.Ctx) error {
 		"message": "Campaign deleted successfully",
 	})
 }
-
-// Helper functions
-func isValidToken(messageID, token string) bool {
-	// Implement your token validation logic
-	// Compare with generated token using same algorithm
-	return true
-}
@@ -1,15 +1,46 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"mailnexy/config"
 	"mailnexy/models"
 	"mailnexy/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+const (
+	// maxSendRetries is how often a send deferred with a 4xx reply is tried again
+	maxSendRetries = 3
+	// sendRetryBackoff is the wait before the first retry; it doubles for each one after
+	sendRetryBackoff = 30 * time.Minute
+	// sendFailureBackoff is how long the worker pauses after a failed send; it
+	// doubles with each failure in a row up to maxSendFailureBackoff
+	sendFailureBackoff    = 30 * time.Second
+	maxSendFailureBackoff = 15 * time.Minute
+)
+
+// composeError is a failure to build a campaign email, such as a template
+// that does not render or an attachment that can not be loaded. It fails
+// for every lead alike, so the campaign is paused instead of retried.
+type composeError struct {
+	err error
+}
+
+func (e *composeError) Error() string {
+	return "failed to build email: " + e.err.Error()
+}
+
+func (e *composeError) Unwrap() error {
+	return e.err
+}
+
 // StartCampaign begins executing a campaign
 func (cc *CampaignController) StartCampaign(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
@@ -36,6 +67,46 @@ func (cc *CampaignController) StartCampaign(c *fiber.Ctx) error {
 		})
 	}
 
+	// Template errors always block the launch. Leads with missing variables
+	// and content that scores as likely spam block it until the user
+	// overrides each of them: ?force_personalization=true and
+	// ?force_spam=true. Both checks are reported together, so one override
+	// is never given without seeing what the other one would let through.
+	report, err := cc.checkPersonalization(&campaign, flow)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check personalization",
+		})
+	}
+	if len(report.TemplateErrors) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":           "Campaign templates contain errors",
+			"personalization": report,
+		})
+	}
+
+	spammy := []NodeLintResult{}
+	for _, result := range cc.lintFlow(&campaign, flow) {
+		if result.Score >= utils.SpamScoreThreshold {
+			spammy = append(spammy, result)
+		}
+	}
+
+	var problems []string
+	if report.Blocking() && c.Query("force_personalization") != "true" {
+		problems = append(problems, "some leads are missing personalization variables (start with ?force_personalization=true to send anyway)")
+	}
+	if len(spammy) > 0 && c.Query("force_spam") != "true" {
+		problems = append(problems, "email content is likely to be flagged as spam (start with ?force_spam=true to send anyway)")
+	}
+	if len(problems) > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":           "Campaign not started: " + strings.Join(problems, "; "),
+			"personalization": report,
+			"lint":            spammy,
+		})
+	}
+
 	// Create execution record
 	execution := models.CampaignExecution{
 		CampaignID:    campaign.ID,
@@ -53,6 +124,7 @@ func (cc *CampaignController) StartCampaign(c *fiber.Ctx) error {
 	// Update campaign status
 	campaign.Status = "sending"
 	campaign.StartedAt = utils.Pointer(time.Now())
+	campaign.PauseReason = ""
 	if err := cc.DB.Save(&campaign).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update campaign status",
@@ -107,6 +179,7 @@ func (cc *CampaignController) StopCampaign(c *fiber.Ctx) error {
 // Enhanced runCampaignWorker with lead processing
 func (cc *CampaignController) runCampaignWorker(campaignID, flowID, executionID uint) {
 	campaignSender := utils.NewCampaignSender(cc.DB, cc.Logger)
+	failures := 0 // failed sends in a row
 
 	for {
 		// Check if campaign is still active
@@ -178,16 +251,40 @@ func (cc *CampaignController) runCampaignWorker(campaignID, flowID, executionID
 			}
 
 			// Send email to lead
-			err = cc.sendEmailToLead(sender, lead, currentNode.Data, &campaign)
+			err = cc.sendEmailToLead(sender, lead, *currentNode, &campaign)
+			var compose *composeError
+			if errors.As(err, &compose) {
+				step := currentNode.Data.Label
+				if step == "" {
+					step = currentNode.ID
+				}
+				reason := fmt.Sprintf("The email of step %q could not be built: %v", step, compose.err)
+				pauseErr := utils.PauseCampaign(cc.DB, &campaign, reason, "Fix the email, then start the campaign again.")
+				if pauseErr == nil {
+					cc.Logger.Printf("Paused campaign %d: %s", campaignID, reason)
+					return
+				}
+				cc.Logger.Printf("Failed to pause campaign %d: %v", campaignID, pauseErr)
+			}
 			if err != nil {
-				cc.Logger.Printf("Failed to send email to lead %d: %v", lead.ID, err)
-				// Mark lead as failed (you might want to retry later)
+				// Send failures are recorded against the lead or the sender, so
+				// the next pass moves on; other errors, such as a database
+				// outage, retry the same lead. Backing off keeps a broken
+				// sender or database from being hammered.
+				failures++
+				backoff := sendFailureBackoff << min(failures-1, 5)
+				if backoff > maxSendFailureBackoff {
+					backoff = maxSendFailureBackoff
+				}
+				cc.Logger.Printf("Failed to send email to lead %d: %v, pausing for %v", lead.ID, err, backoff)
+				time.Sleep(backoff)
 				continue
 			}
+			failures = 0
 
-			// Record activity
-			if err := cc.recordCampaignActivity(campaign.ID, lead.ID, sender.ID); err != nil {
-				cc.Logger.Printf("Failed to record campaign activity: %v", err)
+			// Count the send against the sender's daily limit
+			if err := campaignSender.UpdateSenderUsage(sender.ID); err != nil {
+				cc.Logger.Printf("Failed to update sender usage: %v", err)
 			}
 
 			// Update execution stats
@@ -269,12 +366,23 @@ func (cc *CampaignController) getNextLead(campaignID uint) (*models.Lead, error)
         JOIN campaign_lead_lists cll ON llm.lead_list_id = cll.lead_list_id
         LEFT JOIN campaign_activities ca ON l.id = ca.lead_id AND ca.campaign_id = ?
         WHERE cll.campaign_id = ?
-        AND (ca.id IS NULL OR ca.replied_at IS NOT NULL)
+        AND (ca.id IS NULL OR (ca.sent_at IS NULL AND ca.next_retry_at <= NOW()))
+        AND NOT EXISTS (
+            SELECT 1 FROM campaign_activities r
+            WHERE r.lead_id = l.id AND r.campaign_id = ? AND r.replied_at IS NOT NULL
+        )
         AND l.is_bounced = false
         AND l.is_unsubscribed = false
         AND l.is_do_not_contact = false
+        AND (l.snoozed_until IS NULL OR l.snoozed_until <= NOW())
+        AND NOT EXISTS (
+            SELECT 1 FROM suppressions s
+            WHERE s.user_id = l.user_id
+            AND s.deleted_at IS NULL
+            AND s.value IN (LOWER(l.email), SPLIT_PART(LOWER(l.email), '@', 2))
+        )
         LIMIT 1
-    `, campaignID, campaignID).Scan(&lead).Error
+    `, campaignID, campaignID, campaignID).Scan(&lead).Error
 
 	if err != nil {
 		return nil, err
@@ -288,59 +396,239 @@ func (cc *CampaignController) getNextLead(campaignID uint) (*models.Lead, error)
 }
 
 // sendEmailToLead sends an email to a lead
-func (cc *CampaignController) sendEmailToLead(sender *models.Sender, lead *models.Lead, nodeData models.NodeData, campaign *models.Campaign) error {
+func (cc *CampaignController) sendEmailToLead(sender *models.Sender, lead *models.Lead, node models.CampaignNode, campaign *models.Campaign) error {
+	// Re-check the suppression list right before sending; entries may have
+	// been added after the lead was picked
+	suppressed, err := utils.IsSuppressed(cc.DB, campaign.UserID, lead.Email)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return utils.ErrSuppressed
+	}
+
+	// A lead whose last attempt was deferred keeps its activity and message ID
+	var activity models.CampaignActivity
+	if err := cc.DB.Where("campaign_id = ? AND lead_id = ? AND sent_at IS NULL AND next_retry_at IS NOT NULL", campaign.ID, lead.ID).
+		First(&activity).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
 	// Implement your email sending logic here
 	// Use the MailService to send the email
-	messageID := uuid.New().String()
-	baseURL := "https://yourdomain.com" // Change to your actual domain
-	trackedBody := utils.InjectTracking(nodeData.Body, baseURL, messageID)
-	email := utils.Email{
-		From:    sender.FromEmail,
-		To:      lead.Email,
-		Subject: nodeData.Subject,
-		Body:    trackedBody,
+	messageID := activity.MessageID
+	if messageID == "" {
+		messageID = uuid.New().String()
+	}
+	email, err := cc.composeCampaignEmail(sender, lead, node, campaign, messageID)
+	if err != nil {
+		return &composeError{err: err}
 	}
-	// if err := cc.MailService.Send(email); err != nil {
-	// 	return err
-	// }
 
-	// In sendEmailToLead method
-	returnedMsgID, err := cc.MailService.Send(email)
+	// Senders that opted in send over SMTP with a VERP return path on the
+	// bounce domain, so the inbound receiver can tie each bounce to this
+	// exact email. The provider APIs always use the mailbox address.
+	var verpToken string
+	if domain := config.AppConfig.VERPDomain; domain != "" && sender.UseVERP && utils.OAuthMailProvider(sender) == "" {
+		if verpToken, err = utils.NewVERPToken(); err != nil {
+			return err
+		}
+		email.ReturnPath = utils.VERPAddress(verpToken, domain)
+	}
+
+	// Record the activity. MessageID keys tracking and unsubscribe links,
+	// InternetMessageID is the header replies and bounces refer to
+	activity.CampaignID = campaign.ID
+	activity.LeadID = lead.ID
+	activity.UserID = campaign.UserID
+	activity.SenderID = sender.ID
+	activity.MessageID = messageID
+	activity.InternetMessageID = email.MessageID
+	activity.VERPToken = verpToken
+
+	// The message leaves through the sender's own mailbox
+	sent, err := cc.MailService.Send(sender, email)
 	if err != nil {
+		var rejection *utils.SMTPRejectionError
+		if !errors.As(err, &rejection) || rejection.Stage == "MAIL" ||
+			(rejection.Recipient != "" && !strings.EqualFold(rejection.Recipient, lead.Email)) {
+			// Connection, authentication and TLS failures, a refused MAIL
+			// FROM and refused recipients other than the lead are not the
+			// lead's
+			if deferErr := cc.deferSend(&activity, sender, err); deferErr != nil {
+				cc.Logger.Printf("Failed to record failed send for lead %d: %v", lead.ID, deferErr)
+			}
+		} else {
+			cc.senderReachedServer(sender)
+			if recordErr := cc.recordRejection(&activity, lead, rejection); recordErr != nil {
+				cc.Logger.Printf("Failed to record rejection for lead %d: %v", lead.ID, recordErr)
+			}
+		}
 		return err
 	}
+	cc.senderReachedServer(sender)
+
+	// A server that refused the VERP return path got the sender's own
+	// address instead, so bounces arrive in the mailbox
+	if email.ReturnPath != "" && sent.ReturnPath != email.ReturnPath {
+		cc.Logger.Printf("Sender %d refused the VERP return path, sent with its own address", sender.ID)
+		activity.VERPToken = ""
+	}
 
-	// Use either generated or returned message ID
-	if returnedMsgID != "" {
-		messageID = returnedMsgID
+	// A Cc or Bcc copy the server refused does not undo the send to the lead
+	for _, rejection := range sent.Refused {
+		cc.Logger.Printf("Copy of email to lead %d not delivered: %v", lead.ID, rejection)
 	}
 
-	// Record the activity with the messageID
-	activity := models.CampaignActivity{
-		CampaignID: campaign.ID, // Set CampaignID
-		LeadID:     lead.ID,
-		UserID:     campaign.UserID,
-		SenderID:   sender.ID,
-		SentAt:     utils.Pointer(time.Now()),
-		MessageID:  messageID, // Store the message ID for tracking
+	// Keep what was actually sent; a failed archive does not undo the send
+	archiveKey, err := utils.ArchiveMessage(campaign.UserID, campaign.ID, messageID, sent.Raw)
+	if err != nil {
+		cc.Logger.Printf("Failed to archive message %s: %v", messageID, err)
 	}
 
-	if err := cc.DB.Create(&activity).Error; err != nil {
+	activity.SentAt = utils.Pointer(time.Now())
+	activity.InternetMessageID = sent.MessageID
+	activity.NextRetryAt = nil
+	activity.ArchiveKey = archiveKey
+	activity.MessageSize = len(sent.Raw)
+
+	return cc.DB.Save(&activity).Error
+}
+
+// deferSend records a send that failed for a reason of the sender's, such as
+// an unreachable server or rejected credentials, and schedules the lead
+// again later. The attempt does not count against the lead's retries; the
+// error is kept on the sender for the user to see, and a sender that keeps
+// failing is paused.
+func (cc *CampaignController) deferSend(activity *models.CampaignActivity, sender *models.Sender, sendErr error) error {
+	activity.LastError = sendErr.Error()
+	activity.NextRetryAt = utils.Pointer(time.Now().Add(sendRetryBackoff))
+	if err := cc.DB.Save(activity).Error; err != nil {
 		return err
 	}
 
-	// You might want to store the messageID for tracking
+	if err := cc.DB.Model(&models.Sender{}).Where("id = ?", sender.ID).Updates(map[string]interface{}{
+		"last_error":           sendErr.Error(),
+		"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+	}).Error; err != nil {
+		return err
+	}
+	if err := cc.DB.First(sender, sender.ID).Error; err != nil {
+		return err
+	}
+	if sender.PausedAt == nil {
+		if reason := utils.SenderErrorBreach(sender, config.AppConfig.Reputation); reason != "" {
+			cc.Logger.Printf("Pausing sender %d: %s", sender.ID, reason)
+			return utils.PauseSender(cc.DB, sender, reason, config.AppConfig.Reputation)
+		}
+	}
 	return nil
 }
 
-// recordCampaignActivity records a campaign activity
-func (cc *CampaignController) recordCampaignActivity(campaignID, leadID, senderID uint) error {
-	activity := models.CampaignActivity{
-		CampaignID: campaignID,
-		LeadID:     leadID,
-		SenderID:   senderID,
-		SentAt:     utils.Pointer(time.Now()),
+// senderReachedServer clears a sender's run of failed sends once the
+// recipient's server answered, whether it accepted the email or not
+func (cc *CampaignController) senderReachedServer(sender *models.Sender) {
+	if sender.ConsecutiveFailures == 0 {
+		return
+	}
+	if err := cc.DB.Model(&models.Sender{}).Where("id = ?", sender.ID).
+		Update("consecutive_failures", 0).Error; err != nil {
+		cc.Logger.Printf("Failed to reset failures of sender %d: %v", sender.ID, err)
+	}
+	sender.ConsecutiveFailures = 0
+}
+
+// recordRejection handles a send the server refused during the SMTP
+// transaction. A 4xx reply schedules another attempt with backoff until the
+// retries run out; a 5xx reply, or the last failed retry, is recorded as a
+// bounce exactly like one reported by a DSN.
+func (cc *CampaignController) recordRejection(activity *models.CampaignActivity, lead *models.Lead, rejection *utils.SMTPRejectionError) error {
+	activity.LastError = rejection.Error()
+
+	if !rejection.Permanent() && activity.RetryCount < maxSendRetries {
+		activity.RetryCount++
+		backoff := sendRetryBackoff << (activity.RetryCount - 1)
+		activity.NextRetryAt = utils.Pointer(time.Now().Add(backoff))
+		cc.Logger.Printf("Send to lead %d deferred (%s), retry %d in %v", lead.ID, rejection.Status, activity.RetryCount, backoff)
+		return cc.DB.Save(activity).Error
+	}
+
+	activity.NextRetryAt = nil
+	if err := cc.DB.Save(activity).Error; err != nil {
+		return err
+	}
+
+	// Only the lead's own RCPT and DATA rejections get here, so the bounce
+	// is the lead's
+	bounceType := utils.ClassifyBounce(rejection.Status, rejection.Message)
+	if !rejection.Permanent() {
+		bounceType = utils.BounceSoft
+	}
+
+	return utils.RecordBounce(cc.DB, activity.SenderID, activity, utils.BounceEvent{
+		Email:          lead.Email,
+		Type:           bounceType,
+		Code:           rejection.Status,
+		Message:        utils.DescribeBounceStatus(rejection.Status),
+		DiagnosticCode: fmt.Sprintf("smtp; %d %s", rejection.Code, rejection.Message),
+	})
+}
+
+// composeCampaignEmail renders an email node for a lead: spintax,
+// personalization, tracking, the unsubscribe link and headers, a plain-text alternative, envelope
+// recipients, custom headers and attachments
+func (cc *CampaignController) composeCampaignEmail(sender *models.Sender, lead *models.Lead, node models.CampaignNode, campaign *models.Campaign, messageID string) (utils.Email, error) {
+	nodeData := node.Data
+	baseURL := utils.TrackingBaseURL(sender.CustomTrackingDomain)
+	tracking := utils.TrackingOptions{
+		BaseURL:     baseURL,
+		MessageID:   messageID,
+		TrackOpens:  campaign.TrackOpens && sender.TrackOpens,
+		TrackClicks: campaign.TrackClicks && sender.TrackClicks,
+	}
+
+	// Every campaign message carries one-click unsubscribe headers; the
+	// visible footer is optional per campaign
+	unsubscribeURL := utils.GenerateUnsubscribeURL(baseURL, messageID)
+	headers := utils.UnsubscribeHeaders(unsubscribeURL)
+	for key, value := range nodeData.Headers {
+		if utils.ValidateCustomHeader(key, value) == nil {
+			headers[key] = value
+		}
+	}
+
+	subject, body, err := cc.personalizeNode(sender, lead, campaign, node)
+	if err != nil {
+		return utils.Email{}, err
+	}
+
+	email := utils.Email{
+		From:      sender.FromEmail,
+		To:        lead.Email,
+		ReplyTo:   nodeData.ReplyTo,
+		CC:        nodeData.CC,
+		BCC:       nodeData.BCC,
+		Subject:   subject,
+		Headers:   headers,
+		MessageID: utils.GenerateMessageID(messageID, sender.FromEmail),
+	}
+
+	// The text alternative is generated from the HTML before the open
+	// pixel is added, and only gets its links rewritten
+	if utils.IsHTML(body) {
+		body = utils.AddUnsubscribeLink(body, unsubscribeURL, true, campaign.UnsubscribeLink)
+		email.Body = utils.InjectTracking(body, tracking)
+		email.Text = utils.InjectTextTracking(utils.HTMLToText(body), tracking)
+	} else {
+		body = utils.AddUnsubscribeLink(body, unsubscribeURL, false, campaign.UnsubscribeLink)
+		email.Text = utils.InjectTextTracking(body, tracking)
+	}
+
+	attachments, err := cc.loadNodeAttachments(campaign.ID, nodeData.AttachmentIDs)
+	if err != nil {
+		return email, err
 	}
+	email.Attachments = attachments
 
-	return cc.DB.Create(&activity).Error
+	return email, nil
 }
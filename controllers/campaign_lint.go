@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NodeLintResult is the spam lint result of one email node
+type NodeLintResult struct {
+	NodeID string `json:"node_id"`
+	Label  string `json:"label"`
+	utils.SpamLintResult
+}
+
+// LintContent scores ad-hoc content, or a saved template when template_id is given
+func (cc *CampaignController) LintContent(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var input struct {
+		utils.SpamLintInput
+		TemplateID uint `json:"template_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.TemplateID != 0 {
+		var template models.Template
+		if err := cc.DB.Where("id = ? AND (user_id = ? OR is_public = ?)", input.TemplateID, user.ID, true).
+			First(&template).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Template not found",
+			})
+		}
+		input.Subject = template.Subject
+		input.Body = template.HTMLContent
+		if input.Body == "" {
+			input.Body = template.TextContent
+		}
+	}
+
+	// Lint one concrete variant of spintax copy
+	subject, err := utils.Spin(input.Subject, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	body, err := utils.Spin(input.Body, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	input.Subject, input.Body = subject, body
+
+	return c.JSON(utils.LintEmailContent(input.SpamLintInput))
+}
+
+// LintCampaign scores every email node of a campaign's flow
+func (cc *CampaignController) LintCampaign(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var campaign models.Campaign
+	if err := cc.DB.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&campaign).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign not found",
+		})
+	}
+
+	var flow models.CampaignFlow
+	if err := cc.DB.Where("campaign_id = ?", campaign.ID).First(&flow).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign flow not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"threshold": utils.SpamScoreThreshold,
+		"nodes":     cc.lintFlow(&campaign, flow),
+	})
+}
+
+// lintFlow lints the first spintax variant of each email node together
+// with the names of the files it attaches
+func (cc *CampaignController) lintFlow(campaign *models.Campaign, flow models.CampaignFlow) []NodeLintResult {
+	results := []NodeLintResult{}
+	for _, node := range flow.Nodes {
+		if node.Type != "email" {
+			continue
+		}
+
+		input := utils.SpamLintInput{
+			Subject:           node.Data.Subject,
+			Body:              node.Data.Body,
+			UnsubscribeFooter: campaign.UnsubscribeLink,
+		}
+		if subject, err := utils.Spin(node.Data.Subject, 0); err == nil {
+			input.Subject = subject
+		}
+		if body, err := utils.Spin(node.Data.Body, 0); err == nil {
+			input.Body = body
+		}
+		if len(node.Data.AttachmentIDs) > 0 {
+			cc.DB.Model(&models.CampaignAttachment{}).
+				Where("campaign_id = ? AND id IN ?", campaign.ID, node.Data.AttachmentIDs).
+				Pluck("filename", &input.Attachments)
+		}
+
+		results = append(results, NodeLintResult{
+			NodeID:         node.ID,
+			Label:          node.Data.Label,
+			SpamLintResult: utils.LintEmailContent(input),
+		})
+	}
+	return results
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxReportedLeads caps the per-lead detail in a personalization report;
+// the counts always cover every lead
+const maxReportedLeads = 100
+
+// PersonalizationReport lists template errors and the leads an email node
+// would be sent to with required variables missing
+type PersonalizationReport struct {
+	TotalLeads       int                   `json:"total_leads"`
+	LeadsWithMissing int                   `json:"leads_with_missing"`
+	TemplateErrors   []NodeTemplateError   `json:"template_errors"`
+	MissingByField   map[string]int        `json:"missing_by_field"`
+	Leads            []LeadMissingVariable `json:"leads"`
+}
+
+type NodeTemplateError struct {
+	NodeID string `json:"node_id"`
+	Field  string `json:"field"` // subject or body
+	Error  string `json:"error"`
+}
+
+type LeadMissingVariable struct {
+	LeadID  uint     `json:"lead_id"`
+	Email   string   `json:"email"`
+	NodeID  string   `json:"node_id"`
+	Missing []string `json:"missing"`
+}
+
+// Blocking reports whether the campaign should not start without a force override
+func (r *PersonalizationReport) Blocking() bool {
+	return len(r.TemplateErrors) > 0 || r.LeadsWithMissing > 0
+}
+
+// personalizeNode renders an email node's subject and body for a lead.
+// Spintax is expanded first with a seed fixed per campaign, node and lead,
+// so the variants may contain variables and a lead always gets the same copy.
+func (cc *CampaignController) personalizeNode(sender *models.Sender, lead *models.Lead, campaign *models.Campaign, node models.CampaignNode) (string, string, error) {
+	// Ad-hoc preview leads are not stored and carry their fields inline
+	customFields := lead.CustomFields
+	if lead.ID != 0 {
+		if err := cc.DB.Where("lead_id = ?", lead.ID).Find(&customFields).Error; err != nil {
+			return "", "", err
+		}
+	}
+	data := utils.BuildTemplateData(lead, customFields, sender, time.Now())
+
+	subject, body, err := spinNode(campaign, node, lead)
+	if err != nil {
+		return "", "", err
+	}
+
+	subjectResult, err := utils.Personalize(subject, data, false)
+	if err != nil {
+		return "", "", fmt.Errorf("subject template: %v", err)
+	}
+	bodyResult, err := utils.Personalize(body, data, utils.IsHTML(body))
+	if err != nil {
+		return "", "", fmt.Errorf("body template: %v", err)
+	}
+	return subjectResult.Output, bodyResult.Output, nil
+}
+
+// spinNode expands the spintax of an email node for one lead
+func spinNode(campaign *models.Campaign, node models.CampaignNode, lead *models.Lead) (string, string, error) {
+	subject, err := utils.Spin(node.Data.Subject, utils.SpinSeed(campaign.ID, node.ID, lead.ID, "subject"))
+	if err != nil {
+		return "", "", fmt.Errorf("subject spintax: %v", err)
+	}
+	body, err := utils.Spin(node.Data.Body, utils.SpinSeed(campaign.ID, node.ID, lead.ID, "body"))
+	if err != nil {
+		return "", "", fmt.Errorf("body spintax: %v", err)
+	}
+	return subject, body, nil
+}
+
+// CheckCampaignPersonalization renders every email node for every lead of
+// the campaign and lists leads that lack required variables
+func (cc *CampaignController) CheckCampaignPersonalization(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var campaign models.Campaign
+	if err := cc.DB.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&campaign).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign not found",
+		})
+	}
+
+	var flow models.CampaignFlow
+	if err := cc.DB.Where("campaign_id = ?", campaign.ID).First(&flow).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Campaign flow not found",
+		})
+	}
+
+	report, err := cc.checkPersonalization(&campaign, flow)
+	if err != nil {
+		cc.Logger.Printf("Personalization check failed for campaign %d: %v", campaign.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check personalization",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// checkPersonalization renders each email node against every lead of the
+// campaign. Sender variables are rendered with the user's first active
+// sender since the sender is only picked at send time.
+func (cc *CampaignController) checkPersonalization(campaign *models.Campaign, flow models.CampaignFlow) (*PersonalizationReport, error) {
+	report := &PersonalizationReport{
+		TemplateErrors: []NodeTemplateError{},
+		MissingByField: map[string]int{},
+		Leads:          []LeadMissingVariable{},
+	}
+
+	var emailNodes []models.CampaignNode
+	for _, node := range flow.Nodes {
+		if node.Type != "email" {
+			continue
+		}
+		for _, field := range []struct{ name, template string }{
+			{"subject", node.Data.Subject},
+			{"body", node.Data.Body},
+		} {
+			if _, err := utils.SpinPermutations(field.template); err != nil {
+				report.TemplateErrors = append(report.TemplateErrors, NodeTemplateError{NodeID: node.ID, Field: field.name, Error: err.Error()})
+			} else if _, err := utils.Personalize(field.template, utils.TemplateData{}, false); err != nil {
+				report.TemplateErrors = append(report.TemplateErrors, NodeTemplateError{NodeID: node.ID, Field: field.name, Error: err.Error()})
+			}
+		}
+		emailNodes = append(emailNodes, node)
+	}
+	if len(report.TemplateErrors) > 0 || len(emailNodes) == 0 {
+		return report, nil
+	}
+
+	var sender models.Sender
+	cc.DB.Where("user_id = ? AND is_active = ?", campaign.UserID, true).Order("id ASC").First(&sender)
+
+	var leads []models.Lead
+	if err := cc.DB.
+		Where("id IN (?)", cc.DB.Table("lead_list_memberships llm").
+			Select("llm.lead_id").
+			Joins("JOIN campaign_lead_lists cll ON cll.lead_list_id = llm.lead_list_id").
+			Where("cll.campaign_id = ? AND llm.deleted_at IS NULL AND cll.deleted_at IS NULL", campaign.ID)).
+		Where("is_bounced = ? AND is_unsubscribed = ? AND is_do_not_contact = ?", false, false, false).
+		Preload("CustomFields").
+		Find(&leads).Error; err != nil {
+		return nil, err
+	}
+	report.TotalLeads = len(leads)
+
+	now := time.Now()
+	for i := range leads {
+		lead := &leads[i]
+		data := utils.BuildTemplateData(lead, lead.CustomFields, &sender, now)
+
+		leadMissing := false
+		for _, node := range emailNodes {
+			missing := map[string]bool{}
+			spunSubject, spunBody, _ := spinNode(campaign, node, lead)
+			subject, _ := utils.Personalize(spunSubject, data, false)
+			body, _ := utils.Personalize(spunBody, data, utils.IsHTML(spunBody))
+			for _, name := range append(subject.Missing, body.Missing...) {
+				missing[name] = true
+			}
+			if len(missing) == 0 {
+				continue
+			}
+
+			leadMissing = true
+			entry := LeadMissingVariable{LeadID: lead.ID, Email: lead.Email, NodeID: node.ID}
+			for name := range missing {
+				entry.Missing = append(entry.Missing, name)
+				report.MissingByField[name]++
+			}
+			sort.Strings(entry.Missing)
+			if len(report.Leads) < maxReportedLeads {
+				report.Leads = append(report.Leads, entry)
+			}
+		}
+		if leadMissing {
+			report.LeadsWithMissing++
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"strings"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// PreviewRequest selects what to render: an email node of the campaign for
+// either a stored lead or ad-hoc sample data, through a given sender
+type PreviewRequest struct {
+	NodeID     string            `json:"node_id"`
+	LeadID     uint              `json:"lead_id"`
+	SampleData map[string]string `json:"sample_data"`
+	SenderID   uint              `json:"sender_id"`
+	To         string            `json:"to"` // test sends only; the user's email or one of their senders, by default the user's email
+}
+
+// PreviewCampaignEmail returns an email node exactly as a lead would get it
+func (cc *CampaignController) PreviewCampaignEmail(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var req PreviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	email, sender, status, err := cc.renderPreview(c.Params("id"), user, req)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	raw, err := utils.BuildMIMEMessage(sender, email, email.MessageID, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build message",
+		})
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read rendered message",
+		})
+	}
+
+	headers := make(map[string]string, len(msg.Header))
+	for key, values := range msg.Header {
+		headers[key] = strings.Join(values, ", ")
+	}
+	// Boundaries are random per build and not useful in a preview
+	delete(headers, "Content-Type")
+
+	attachments := make([]fiber.Map, 0, len(email.Attachments))
+	for _, attachment := range email.Attachments {
+		attachments = append(attachments, fiber.Map{
+			"filename":     attachment.Filename,
+			"content_type": attachment.ContentType,
+			"size":         len(attachment.Data),
+			"inline":       attachment.Inline(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"subject":     email.Subject,
+		"html":        email.Body,
+		"text":        email.Text,
+		"headers":     headers,
+		"attachments": attachments,
+		"size":        len(raw),
+	})
+}
+
+// SendTestEmail renders an email node like PreviewCampaignEmail and sends
+// it through the chosen sender, by default to the requesting user
+func (cc *CampaignController) SendTestEmail(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var req PreviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	to := strings.TrimSpace(req.To)
+	if to == "" {
+		to = user.Email
+	}
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid recipient address",
+		})
+	}
+
+	// Tests only go to the user themselves, so a rendered campaign email
+	// cannot be sent to a third party around the suppression list
+	if !strings.EqualFold(addr.Address, user.Email) {
+		var owned int64
+		cc.DB.Model(&models.Sender{}).
+			Where("user_id = ? AND LOWER(from_email) = ?", user.ID, strings.ToLower(addr.Address)).
+			Count(&owned)
+		if owned == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Test emails can only be sent to your own address or one of your senders",
+			})
+		}
+	}
+
+	email, sender, status, err := cc.renderPreview(c.Params("id"), user, req)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// A test only goes to the requested address, never to the node's Cc/Bcc
+	email.To = to
+	email.CC = nil
+	email.BCC = nil
+	email.Subject = "[Test] " + email.Subject
+
+	sent, err := cc.MailService.Send(sender, email)
+	if err != nil {
+		cc.Logger.Printf("Test send through sender %d failed: %v", sender.ID, err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to send test email: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":    "Test email sent",
+		"to":         to,
+		"message_id": sent.MessageID,
+	})
+}
+
+// renderPreview loads the campaign, node, sender and lead of a preview
+// request and composes the email. On failure it also returns the HTTP status.
+func (cc *CampaignController) renderPreview(campaignID string, user *models.User, req PreviewRequest) (utils.Email, *models.Sender, int, error) {
+	var campaign models.Campaign
+	if err := cc.DB.Where("id = ? AND user_id = ?", campaignID, user.ID).First(&campaign).Error; err != nil {
+		return utils.Email{}, nil, fiber.StatusNotFound, errors.New("Campaign not found")
+	}
+
+	var flow models.CampaignFlow
+	if err := cc.DB.Where("campaign_id = ?", campaign.ID).First(&flow).Error; err != nil {
+		return utils.Email{}, nil, fiber.StatusNotFound, errors.New("Campaign flow not found")
+	}
+
+	var node *models.CampaignNode
+	for i := range flow.Nodes {
+		if flow.Nodes[i].Type == "email" && (req.NodeID == "" || flow.Nodes[i].ID == req.NodeID) {
+			node = &flow.Nodes[i]
+			break
+		}
+	}
+	if node == nil {
+		return utils.Email{}, nil, fiber.StatusNotFound, errors.New("Email node not found")
+	}
+
+	var sender models.Sender
+	query := cc.DB.Where("user_id = ?", user.ID)
+	if req.SenderID != 0 {
+		query = query.Where("id = ?", req.SenderID)
+	} else {
+		query = query.Where("is_active = ?", true).Order("id ASC")
+	}
+	if err := query.First(&sender).Error; err != nil {
+		return utils.Email{}, nil, fiber.StatusNotFound, errors.New("Sender not found")
+	}
+
+	var lead models.Lead
+	if req.LeadID != 0 {
+		if err := cc.DB.Where("id = ? AND user_id = ?", req.LeadID, user.ID).First(&lead).Error; err != nil {
+			return utils.Email{}, nil, fiber.StatusNotFound, errors.New("Lead not found")
+		}
+	} else {
+		lead = sampleLead(req.SampleData, user.Email)
+	}
+
+	email, err := cc.composeCampaignEmail(&sender, &lead, *node, &campaign, uuid.New().String())
+	if err != nil {
+		return utils.Email{}, nil, fiber.StatusBadRequest, err
+	}
+	return email, &sender, fiber.StatusOK, nil
+}
+
+// sampleLead builds an unsaved lead from ad-hoc preview data. Keys that are
+// not standard lead fields become custom fields.
+func sampleLead(data map[string]string, fallbackEmail string) models.Lead {
+	lead := models.Lead{Email: fallbackEmail}
+	for key, value := range data {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "email":
+			lead.Email = value
+		case "first_name":
+			lead.FirstName = value
+		case "last_name":
+			lead.LastName = value
+		case "company":
+			lead.Company = value
+		case "position":
+			lead.Position = value
+		case "phone":
+			lead.Phone = value
+		case "website":
+			lead.Website = value
+		default:
+			lead.CustomFields = append(lead.CustomFields, models.LeadCustomField{Name: key, Value: value})
+		}
+	}
+	return lead
+}
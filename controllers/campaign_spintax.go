@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultSpintaxSamples = 5
+	maxSpintaxSamples     = 50
+)
+
+// PreviewSpintax expands a subject or body a number of times and reports
+// how many distinct versions it can produce
+func (cc *CampaignController) PreviewSpintax(c *fiber.Ctx) error {
+	var input struct {
+		Text    string `json:"text"`
+		Samples int    `json:"samples"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.Samples <= 0 {
+		input.Samples = defaultSpintaxSamples
+	}
+	if input.Samples > maxSpintaxSamples {
+		input.Samples = maxSpintaxSamples
+	}
+
+	permutations, err := utils.SpinPermutations(input.Text)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	samples := make([]string, 0, input.Samples)
+	for i := 0; i < input.Samples; i++ {
+		sample, err := utils.Spin(input.Text, utils.SpinSeed("preview", i))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		samples = append(samples, sample)
+	}
+
+	// The count can exceed int64 for long copy, so it is returned as a string
+	return c.JSON(fiber.Map{
+		"samples":      samples,
+		"permutations": permutations.String(),
+	})
+}
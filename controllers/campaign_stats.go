@@ -43,9 +43,52 @@ func (cc *CampaignController) GetCampaignStats(c *fiber.Ctx) error {
 		"next_run_at":  execution.NextRunAt,
 	}
 
+	breakdowns := fiber.Map{}
+	for key, column := range map[string]string{
+		"countries":     "country",
+		"email_clients": "email_client",
+		"devices":       "device_type",
+	} {
+		rows, err := cc.engagementBreakdown(campaign.ID, column)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to calculate engagement breakdowns",
+			})
+		}
+		breakdowns[key] = rows
+	}
+	stats["breakdowns"] = breakdowns
+
 	return c.JSON(stats)
 }
 
+type engagementBreakdownRow struct {
+	Value   string `json:"value"`
+	Engaged int    `json:"engaged"`
+	Opens   int    `json:"opens"`
+	Clicks  int    `json:"clicks"`
+}
+
+// engagementBreakdown groups opened or clicked activities by an enrichment
+// column. column must be one of the fixed names used by GetCampaignStats.
+func (cc *CampaignController) engagementBreakdown(campaignID uint, column string) ([]engagementBreakdownRow, error) {
+	var rows []engagementBreakdownRow
+	err := cc.DB.Raw(`
+        SELECT
+            COALESCE(NULLIF(`+column+`, ''), 'unknown') AS value,
+            COUNT(*) AS engaged,
+            SUM(CASE WHEN opened_at IS NOT NULL THEN 1 ELSE 0 END) AS opens,
+            SUM(CASE WHEN clicked_at IS NOT NULL THEN 1 ELSE 0 END) AS clicks
+        FROM campaign_activities
+        WHERE campaign_id = ?
+        AND deleted_at IS NULL
+        AND (opened_at IS NOT NULL OR clicked_at IS NOT NULL)
+        GROUP BY 1
+        ORDER BY engaged DESC
+    `, campaignID).Scan(&rows).Error
+	return rows, err
+}
+
 // GetCampaignLeadStats returns lead statistics for a campaign
 func (cc *CampaignController) GetCampaignLeadStats(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var unsubscribePageTemplate = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="robots" content="noindex, nofollow">
+    <title>Unsubscribe</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 60px auto; padding: 20px; }
+        .button { display: inline-block; padding: 10px 20px; background-color: #3498db; color: white; border: 0; border-radius: 4px; font-size: 15px; cursor: pointer; }
+        textarea { width: 100%; min-height: 60px; margin: 10px 0; }
+    </style>
+</head>
+<body>
+    {{if .Done}}
+    <h2>You have been unsubscribed</h2>
+    <p>{{.Email}} will not receive any further emails from this sender.</p>
+    {{else}}
+    <h2>Unsubscribe</h2>
+    <p>Click below to stop receiving emails at {{.Email}} from this sender.</p>
+    <form method="POST">
+        <input type="hidden" name="confirm" value="1">
+        <label for="reason">Reason (optional)</label>
+        <textarea id="reason" name="reason"></textarea>
+        <button type="submit" class="button">Unsubscribe</button>
+    </form>
+    {{end}}
+</body>
+</html>`))
+
+// HandleUnsubscribePage renders the hosted opt-out confirmation page. GET never
+// unsubscribes by itself so link scanners cannot opt recipients out.
+func (cc *CampaignController) HandleUnsubscribePage(c *fiber.Ctx) error {
+	messageID := c.Params("messageID")
+	if !utils.VerifyUnsubscribeToken(messageID, c.Params("token")) {
+		return renderLinkInterstitial(c, "")
+	}
+
+	var activity models.CampaignActivity
+	if err := cc.DB.Preload("Lead").Where("message_id = ?", messageID).First(&activity).Error; err != nil {
+		return renderLinkInterstitial(c, "")
+	}
+
+	return renderUnsubscribePage(c, activity.Lead.Email, activity.UnsubscribedAt != nil)
+}
+
+// HandleUnsubscribe processes both the RFC 8058 one-click POST sent by mailbox
+// providers and the confirmation form on the hosted page
+func (cc *CampaignController) HandleUnsubscribe(c *fiber.Ctx) error {
+	messageID := c.Params("messageID")
+	if !utils.VerifyUnsubscribeToken(messageID, c.Params("token")) {
+		return c.Status(fiber.StatusNotFound).SendString("Invalid unsubscribe link")
+	}
+
+	oneClick := c.FormValue("List-Unsubscribe") == "One-Click"
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if oneClick {
+		reason = "one-click"
+	}
+
+	lead, err := cc.unsubscribeMessage(messageID, reason, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		cc.Logger.Printf("Failed to unsubscribe message %s: %v", messageID, err)
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).SendString("Invalid unsubscribe link")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to process unsubscribe")
+	}
+
+	if oneClick {
+		return c.SendString("Unsubscribed")
+	}
+	return renderUnsubscribePage(c, lead.Email, true)
+}
+
+// unsubscribeMessage opts the recipient of a campaign message out. Every lead
+// of the account with the same address is flagged, which takes them out of
+// all active flows since getNextLead skips unsubscribed leads.
+func (cc *CampaignController) unsubscribeMessage(messageID, reason, ip, userAgent string) (*models.Lead, error) {
+	var activity models.CampaignActivity
+	if err := cc.DB.Where("message_id = ?", messageID).First(&activity).Error; err != nil {
+		return nil, err
+	}
+
+	var lead models.Lead
+	if err := cc.DB.First(&lead, activity.LeadID).Error; err != nil {
+		return nil, err
+	}
+
+	// Repeated clicks, provider retries and links in the lead's other emails
+	// must not double count
+	if lead.IsUnsubscribed {
+		return &lead, nil
+	}
+
+	now := time.Now()
+	err := cc.DB.Transaction(func(tx *gorm.DB) error {
+		// Only the request that flips the flag records the unsubscribe; a
+		// concurrent one waits on the row and then matches nothing
+		result := tx.Model(&models.Lead{}).
+			Where("id = ? AND is_unsubscribed = ?", lead.ID, false).
+			Update("is_unsubscribed", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		unsubscribe := models.Unsubscribe{
+			Email:      strings.ToLower(lead.Email),
+			CampaignID: &activity.CampaignID,
+			SenderID:   &activity.SenderID,
+			UserID:     &activity.UserID,
+			Reason:     reason,
+			IPAddress:  ip,
+			UserAgent:  userAgent,
+		}
+		if err := tx.Create(&unsubscribe).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Lead{}).
+			Where("user_id = ? AND LOWER(email) = ?", lead.UserID, strings.ToLower(lead.Email)).
+			Update("is_unsubscribed", true).Error; err != nil {
+			return err
+		}
+
+		if err := utils.SuppressAddress(tx, activity.UserID, lead.Email, utils.SuppressionSourceUnsubscribe, reason, &activity.CampaignID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&activity).Update("unsubscribed_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Campaign{}).
+			Where("id = ?", activity.CampaignID).
+			Update("unsubscribe_count", gorm.Expr("unsubscribe_count + 1")).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.LeadActivity{
+			LeadID:       lead.ID,
+			CampaignID:   &activity.CampaignID,
+			SenderID:     &activity.SenderID,
+			ActivityType: "unsubscribed",
+			ActivityAt:   now,
+			Details:      reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lead.IsUnsubscribed = true
+	return &lead, nil
+}
+
+func renderUnsubscribePage(c *fiber.Ctx, email string, done bool) error {
+	var page bytes.Buffer
+	if err := unsubscribePageTemplate.Execute(&page, fiber.Map{"Email": email, "Done": done}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to render page")
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return c.Type("html").Send(page.Bytes())
+}
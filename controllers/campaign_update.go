@@ -33,6 +33,14 @@ func (cc *CampaignController) UpdateCampaign(c *fiber.Ctx) error {
 		})
 	}
 
+	if input.Flow != nil {
+		if err := validateEmailNodes(input.Flow.Nodes); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
 	// Start database transaction
 	tx := cc.DB.Begin()
 
@@ -133,6 +141,12 @@ func (cc *CampaignController) UpdateCampaignFlow(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := validateEmailNodes(input.Nodes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	var campaign models.Campaign
 	if err := cc.DB.Where("id = ? AND user_id = ?", campaignID, user.ID).First(&campaign).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -246,9 +260,10 @@ func (cc *CampaignController) UpdateCampaignSettings(c *fiber.Ctx) error {
 	}
 
 	var input struct {
-		TrackOpens      bool   `json:"trackOpens"`
-		TrackClicks     bool   `json:"trackClicks"`
-		EmailAccountIDs []uint `json:"emailAccountIds"`
+		TrackOpens            bool   `json:"trackOpens"`
+		TrackClicks           bool   `json:"trackClicks"`
+		RescheduleOutOfOffice *bool  `json:"rescheduleOutOfOffice"`
+		EmailAccountIDs       []uint `json:"emailAccountIds"`
 		// Add other settings fields here
 	}
 
@@ -309,6 +324,9 @@ func (cc *CampaignController) UpdateCampaignSettings(c *fiber.Ctx) error {
 	// Update campaign settings
 	campaign.TrackOpens = input.TrackOpens
 	campaign.TrackClicks = input.TrackClicks
+	if input.RescheduleOutOfOffice != nil {
+		campaign.RescheduleOutOfOffice = *input.RescheduleOutOfOffice
+	}
 	if err := tx.Save(&campaign).Error; err != nil {
 		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
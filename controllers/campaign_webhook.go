@@ -1,6 +1,10 @@
 package controller
 
 import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
 	"time"
 
 	"mailnexy/models"
@@ -10,10 +14,11 @@ import (
 	"gorm.io/gorm"
 )
 
-// HandleCampaignWebhook processes events (opens, clicks, replies) for campaigns
+// HandleCampaignWebhook processes events (opens, clicks, replies, bounces,
+// complaints) for campaigns
 func (cc *CampaignController) HandleCampaignWebhook(c *fiber.Ctx) error {
 	var input struct {
-		EventType string `json:"event_type"` // open, click, reply
+		EventType string `json:"event_type"` // open, click, reply, bounce, complaint
 		MessageID string `json:"message_id"`
 		Email     string `json:"email"`
 		Timestamp int64  `json:"timestamp"`
@@ -49,6 +54,15 @@ func (cc *CampaignController) HandleCampaignWebhook(c *fiber.Ctx) error {
 		if activity.RepliedAt == nil {
 			activity.RepliedAt = utils.Pointer(time.Unix(input.Timestamp, 0))
 		}
+	case "bounce":
+		if activity.BouncedAt == nil {
+			activity.BouncedAt = utils.Pointer(time.Unix(input.Timestamp, 0))
+			activity.BounceType = "hard"
+		}
+	case "complaint":
+		if activity.ComplainedAt == nil {
+			activity.ComplainedAt = utils.Pointer(time.Unix(input.Timestamp, 0))
+		}
 	}
 
 	if err := cc.DB.Save(&activity).Error; err != nil {
@@ -57,6 +71,13 @@ func (cc *CampaignController) HandleCampaignWebhook(c *fiber.Ctx) error {
 		})
 	}
 
+	// Bounces and complaints keep the address out of every future campaign
+	if err := cc.suppressFromEvent(&activity, input.EventType); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update suppression list",
+		})
+	}
+
 	// Update campaign execution if this affects a condition node
 	var execution models.CampaignExecution
 	if err := cc.DB.Where("campaign_id = ?", activity.CampaignID).First(&execution).Error; err == nil {
@@ -111,14 +132,16 @@ func (cc *CampaignController) HandleOpenTracking(c *fiber.Ctx) error {
 	messageID := c.Params("messageID")
 	token := c.Params("token")
 
-	// Validate token
-	if !isValidToken(messageID, token) {
-		return c.Status(fiber.StatusBadRequest).SendString("Invalid token")
+	// Only count opens for pixels we issued, but always serve the image
+	if utils.VerifyTrackingToken(messageID, "", token) {
+		cc.enqueueTrackingEvent(utils.TrackingEvent{
+			Type:      utils.TrackingEventOpen,
+			MessageID: messageID,
+			IPAddress: c.IP(),
+			UserAgent: c.Get("User-Agent"),
+		})
 	}
 
-	// Update open stats in database
-	cc.updateOpenStats(messageID)
-
 	// Return transparent pixel
 	return c.Type("gif").Send(transparentPixel())
 }
@@ -128,34 +151,104 @@ func (cc *CampaignController) HandleClickTracking(c *fiber.Ctx) error {
 	token := c.Params("token")
 	originalURL := c.Query("url")
 
-	// Validate token
-	if !isValidToken(messageID, token) {
-		return c.Status(fiber.StatusBadRequest).SendString("Invalid token")
+	// The token is bound to the destination, so only links that were in the
+	// sent message are ever redirected to
+	if !utils.VerifyTrackingToken(messageID, originalURL, token) || !isRedirectableURL(originalURL) {
+		cc.Logger.Printf("Rejected click redirect for message %s", messageID)
+		return renderLinkInterstitial(c, originalURL)
 	}
 
-	// Update click stats in database
-	cc.updateClickStats(messageID)
+	// Click stats are applied asynchronously by the tracking worker
+	cc.enqueueTrackingEvent(utils.TrackingEvent{
+		Type:      utils.TrackingEventClick,
+		MessageID: messageID,
+		URL:       originalURL,
+		IPAddress: c.IP(),
+		UserAgent: c.Get("User-Agent"),
+	})
 
 	// Redirect to original URL
 	return c.Redirect(originalURL, fiber.StatusFound)
 }
 
-func (cc *CampaignController) updateOpenStats(messageID string) {
-	cc.DB.Model(&models.CampaignActivity{}).
-		Where("message_id = ?", messageID).
-		Updates(map[string]interface{}{
-			"open_count": gorm.Expr("open_count + 1"),
-			"opened_at":  time.Now(),
-		})
+// isRedirectableURL only allows absolute http(s) destinations
+func isRedirectableURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
 }
 
-func (cc *CampaignController) updateClickStats(messageID string) {
-	cc.DB.Model(&models.CampaignActivity{}).
-		Where("message_id = ?", messageID).
-		Updates(map[string]interface{}{
-			"click_count": gorm.Expr("click_count + 1"),
-			"clicked_at":  time.Now(),
-		})
+var linkInterstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="robots" content="noindex, nofollow">
+    <title>Link unavailable</title>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; max-width: 600px; margin: 60px auto; padding: 20px; }
+        .destination { word-break: break-all; background: #f5f5f5; padding: 10px; border-radius: 4px; font-family: monospace; }
+    </style>
+</head>
+<body>
+    <h2>This link could not be verified</h2>
+    <p>The link you followed is not recognised, so we did not redirect you automatically.</p>
+    {{if .Destination}}
+    <p>It claims to lead to:</p>
+    <p class="destination">{{.Destination}}</p>
+    <p>Only visit it if you trust the source of the email.</p>
+    {{end}}
+</body>
+</html>`))
+
+// renderLinkInterstitial shows the destination as plain text instead of
+// redirecting, so the tracker cannot be abused as an open redirector
+func renderLinkInterstitial(c *fiber.Ctx, destination string) error {
+	var page bytes.Buffer
+	if err := linkInterstitialTemplate.Execute(&page, fiber.Map{"Destination": destination}); err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Link not found")
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return c.Status(fiber.StatusNotFound).Type("html").Send(page.Bytes())
+}
+
+// enqueueTrackingEvent buffers a tracking hit for the tracking worker so the
+// response never waits on the database
+func (cc *CampaignController) enqueueTrackingEvent(event utils.TrackingEvent) {
+	if err := utils.EnqueueTrackingEvent(event); err != nil {
+		cc.Logger.Printf("Failed to enqueue %s event for message %s: %v", event.Type, event.MessageID, err)
+	}
+}
+
+// suppressFromEvent flags the recipient's leads and adds the address to the
+// account suppression list for bounce and complaint events
+func (cc *CampaignController) suppressFromEvent(activity *models.CampaignActivity, eventType string) error {
+	var source, leadColumn string
+	switch eventType {
+	case "bounce":
+		source, leadColumn = utils.SuppressionSourceBounce, "is_bounced"
+	case "complaint":
+		source, leadColumn = utils.SuppressionSourceComplaint, "is_do_not_contact"
+	default:
+		return nil
+	}
+
+	var lead models.Lead
+	if err := cc.DB.First(&lead, activity.LeadID).Error; err != nil {
+		return err
+	}
+
+	return cc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Lead{}).
+			Where("user_id = ? AND LOWER(email) = ?", lead.UserID, strings.ToLower(lead.Email)).
+			Update(leadColumn, true).Error; err != nil {
+			return err
+		}
+
+		return utils.SuppressAddress(tx, activity.UserID, lead.Email, source, eventType+" reported by webhook", &activity.CampaignID)
+	})
 }
 
 func transparentPixel() []byte {
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type NotificationController struct {
+	DB     *gorm.DB
+	Logger *log.Logger
+}
+
+func NewNotificationController(db *gorm.DB, logger *log.Logger) *NotificationController {
+	return &NotificationController{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// GetNotifications returns the user's notifications, newest first
+func (nc *NotificationController) GetNotifications(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+
+	query := nc.DB.Model(&models.Notification{}).Where("user_id = ?", user.ID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count notifications", err)
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&notifications).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to fetch notifications", err)
+	}
+
+	return c.JSON(utils.PaginatedResponse{
+		Data:  notifications,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	})
+}
+
+// MarkNotificationRead marks one notification as read
+func (nc *NotificationController) MarkNotificationRead(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	result := nc.DB.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", c.Params("id"), user.ID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update notification", result.Error)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user as read
+func (nc *NotificationController) MarkAllNotificationsRead(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if err := nc.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", user.ID).
+		Update("read_at", time.Now()).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to update notifications", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -25,6 +25,7 @@ type CreateSenderRequest struct {
 	Name              string `json:"name" validate:"required"`
 	FromEmail         string `json:"from_email" validate:"required,email"`
 	FromName          string `json:"from_name" validate:"required"`
+	Signature         string `json:"signature"`
 	ProviderType      string `json:"provider_type" validate:"required,oneof=smtp gmail outlook yahoo custom"`
 	SMTPHost          string `json:"smtp_host" validate:"required_if=ProviderType smtp"`
 	SMTPPort          int    `json:"smtp_port" validate:"required_if=ProviderType smtp"`
@@ -43,12 +44,16 @@ type CreateSenderRequest struct {
 	TrackOpens        bool   `json:"track_opens"`
 	TrackClicks       bool   `json:"track_clicks"`
 	TrackReplies      bool   `json:"track_replies"`
+	UseVERP           bool   `json:"use_verp"`
+	DKIMPrivateKey    string `json:"dkim_private_key"`
+	DKIMSelector      string `json:"dkim_selector" validate:"required_with=DKIMPrivateKey,omitempty,hostname_rfc1123"`
 }
 
 type UpdateSenderRequest struct {
 	Name              *string `json:"name"`
 	FromEmail         *string `json:"from_email" validate:"omitempty,email"`
 	FromName          *string `json:"from_name"`
+	Signature         *string `json:"signature"`
 	SMTPPassword      *string `json:"smtp_password"`
 	IMAPPassword      *string `json:"imap_password"`
 	OAuthToken        *string `json:"oauth_token"`
@@ -56,6 +61,10 @@ type UpdateSenderRequest struct {
 	TrackOpens        *bool   `json:"track_opens"`
 	TrackClicks       *bool   `json:"track_clicks"`
 	TrackReplies      *bool   `json:"track_replies"`
+	IsActive          *bool   `json:"is_active"`
+	UseVERP           *bool   `json:"use_verp"`
+	DKIMPrivateKey    *string `json:"dkim_private_key"`
+	DKIMSelector      *string `json:"dkim_selector" validate:"omitempty,hostname_rfc1123"`
 }
 
 type TestResult struct {
@@ -109,6 +118,13 @@ func CreateSender(c *fiber.Ctx) error {
 		})
 	}
 
+	encryptedDKIMKey, err := encryptDKIMKey(req.DKIMPrivateKey)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Create sender
 	sender := models.Sender{
 		UserID:            user.ID,
@@ -133,6 +149,10 @@ func CreateSender(c *fiber.Ctx) error {
 		TrackOpens:        req.TrackOpens,
 		TrackClicks:       req.TrackClicks,
 		TrackReplies:      req.TrackReplies,
+		UseVERP:           req.UseVERP,
+		DKIMPrivateKey:    encryptedDKIMKey,
+		DKIMSelector:      req.DKIMSelector,
+		Signature:         req.Signature,
 	}
 
 	if err := config.DB.Create(&sender).Error; err != nil {
@@ -272,6 +292,8 @@ func UpdateSender(c *fiber.Ctx) error {
 			})
 		}
 		sender.OAuthRefreshToken = encrypted
+		// A fresh grant replaces a revoked one
+		sender.NeedsReconnect = false
 	}
 	if req.TrackOpens != nil {
 		sender.TrackOpens = *req.TrackOpens
@@ -282,6 +304,27 @@ func UpdateSender(c *fiber.Ctx) error {
 	if req.TrackReplies != nil {
 		sender.TrackReplies = *req.TrackReplies
 	}
+	if req.IsActive != nil {
+		sender.IsActive = *req.IsActive
+	}
+	if req.UseVERP != nil {
+		sender.UseVERP = *req.UseVERP
+	}
+	if req.Signature != nil {
+		sender.Signature = *req.Signature
+	}
+	if req.DKIMPrivateKey != nil {
+		encrypted, err := encryptDKIMKey(*req.DKIMPrivateKey)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		sender.DKIMPrivateKey = encrypted
+	}
+	if req.DKIMSelector != nil {
+		sender.DKIMSelector = *req.DKIMSelector
+	}
 
 	if err := config.DB.Save(&sender).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -325,7 +368,81 @@ func DeleteSender(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// ResumeSender acknowledges an automatic reputation pause and puts the
+// sender back into campaign rotation
+func ResumeSender(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	senderID := c.Params("id")
+
+	if err := validateSenderID(senderID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
+	var sender models.Sender
+	if err := config.DB.Where("id = ? AND user_id = ?", senderID, user.ID).First(&sender).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Sender not found",
+		})
+	}
+
+	if sender.PausedAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Sender is not paused",
+		})
+	}
+
+	if err := utils.ResumeSender(config.DB, &sender); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to resume sender",
+		})
+	}
+
+	sender.Sanitize()
+	return c.JSON(sender)
+}
+
+
+
+// encryptDKIMKey validates a DKIM private key and encrypts it for storage.
+// An empty key clears DKIM signing.
+func encryptDKIMKey(key string) (string, error) {
+	if strings.TrimSpace(key) == "" {
+		return "", nil
+	}
+	if _, err := utils.ParseDKIMPrivateKey(key); err != nil {
+		return "", fmt.Errorf("invalid DKIM private key: %v", err)
+	}
+
+	encrypted, err := utils.Encrypt(strings.TrimSpace(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt DKIM private key")
+	}
+	return encrypted, nil
+}
+
+// CheckSenderDKIM signs a probe message with the sender's key and verifies
+// it against the DKIM record published in DNS
+func CheckSenderDKIM(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	senderID := c.Params("id")
+
+	if err := validateSenderID(senderID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var sender models.Sender
+	if err := config.DB.Where("id = ? AND user_id = ?", senderID, user.ID).First(&sender).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Sender not found",
+		})
+	}
+
+	return c.JSON(utils.CheckDKIM(&sender))
+}
 
 func TestSender(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type SuppressionController struct {
+	DB     *gorm.DB
+	Logger *log.Logger
+}
+
+func NewSuppressionController(db *gorm.DB, logger *log.Logger) *SuppressionController {
+	return &SuppressionController{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+// GetSuppressions returns the paginated suppression list with filters
+func (sc *SuppressionController) GetSuppressions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	// Pagination
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 100
+	}
+	offset := (page - 1) * limit
+
+	query := sc.DB.Model(&models.Suppression{}).Where("user_id = ?", user.ID)
+
+	if search := c.Query("search"); search != "" {
+		query = query.Where("value LIKE ?", "%"+strings.ToLower(search)+"%")
+	}
+	if suppressionType := c.Query("type"); suppressionType != "" {
+		query = query.Where("type = ?", suppressionType)
+	}
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to count suppressions", err)
+	}
+
+	var suppressions []models.Suppression
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&suppressions).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to fetch suppressions", err)
+	}
+
+	return c.JSON(utils.PaginatedResponse{
+		Data:  suppressions,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	})
+}
+
+// CreateSuppressions adds one or more addresses or domains to the suppression list
+func (sc *SuppressionController) CreateSuppressions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var input struct {
+		Value  string   `json:"value"`
+		Values []string `json:"values"`
+		Reason string   `json:"reason" validate:"omitempty,max=500"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "Invalid request body", err)
+	}
+
+	if err := utils.ValidateStruct(input); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "Validation failed", err)
+	}
+
+	values := input.Values
+	if input.Value != "" {
+		values = append(values, input.Value)
+	}
+	if len(values) == 0 {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "At least one email address or domain is required", nil)
+	}
+
+	added, invalid := sc.suppressValues(user.ID, values, utils.SuppressionSourceManual, input.Reason)
+
+	return c.Status(fiber.StatusCreated).JSON(utils.SuccessResponse(fiber.Map{
+		"message": "Suppression list updated",
+		"added":   added,
+		"invalid": invalid,
+	}))
+}
+
+// DeleteSuppression removes an entry so the address can be mailed again
+func (sc *SuppressionController) DeleteSuppression(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	suppressionID := c.Params("id")
+
+	// Hard delete so the value can be suppressed again later without
+	// tripping the unique index
+	result := sc.DB.Unscoped().
+		Where("id = ? AND user_id = ?", suppressionID, user.ID).
+		Delete(&models.Suppression{})
+	if result.Error != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to delete suppression", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrorResponse(c, fiber.StatusNotFound, "Suppression not found", nil)
+	}
+
+	return c.JSON(utils.SuccessResponse(fiber.Map{
+		"message": "Suppression deleted successfully",
+	}))
+}
+
+// ImportSuppressions imports addresses and domains from a CSV file. The first
+// column is used unless the header row contains an "email", "domain" or
+// "value" column; an optional "reason" column is kept with each entry.
+func (sc *SuppressionController) ImportSuppressions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "File upload error", err)
+	}
+
+	// Check file size (max 5MB)
+	if file.Size > 5<<20 {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "File too large (max 5MB)", nil)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to open file", err)
+	}
+	defer src.Close()
+
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+
+	valueCol, reasonCol := 0, -1
+	added, invalid, total := 0, 0, 0
+	firstRow := true
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return utils.ErrorResponse(c, fiber.StatusBadRequest, "Failed to parse CSV file", err)
+		}
+
+		if firstRow {
+			firstRow = false
+			if col, reason, ok := suppressionHeader(row); ok {
+				valueCol, reasonCol = col, reason
+				continue
+			}
+		}
+
+		if valueCol >= len(row) {
+			invalid++
+			continue
+		}
+
+		reason := ""
+		if reasonCol >= 0 && reasonCol < len(row) {
+			reason = strings.TrimSpace(row[reasonCol])
+		}
+
+		total++
+		if err := utils.SuppressAddress(sc.DB, user.ID, row[valueCol], utils.SuppressionSourceImport, reason, nil); err != nil {
+			invalid++
+			continue
+		}
+		added++
+	}
+
+	return c.JSON(utils.SuccessResponse(fiber.Map{
+		"message":    "Suppressions imported successfully",
+		"total_rows": total,
+		"imported":   added,
+		"invalid":    invalid,
+	}))
+}
+
+// ExportSuppressions exports the suppression list to CSV
+func (sc *SuppressionController) ExportSuppressions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var suppressions []models.Suppression
+	if err := sc.DB.Where("user_id = ?", user.ID).Order("value").Find(&suppressions).Error; err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to fetch suppressions", err)
+	}
+
+	c.Set("Content-Type", "text/csv")
+	c.Set("Content-Disposition", "attachment; filename=suppressions_export_"+time.Now().Format("20060102")+".csv")
+
+	writer := csv.NewWriter(c)
+	defer writer.Flush()
+
+	header := []string{"value", "type", "source", "reason", "created_at"}
+	if err := writer.Write(header); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to generate CSV", err)
+	}
+
+	for _, suppression := range suppressions {
+		record := []string{
+			suppression.Value,
+			suppression.Type,
+			suppression.Source,
+			suppression.Reason,
+			suppression.CreatedAt.Format(time.RFC3339),
+		}
+		if err := writer.Write(record); err != nil {
+			return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Failed to generate CSV", err)
+		}
+	}
+
+	return nil
+}
+
+func (sc *SuppressionController) suppressValues(userID uint, values []string, source, reason string) (int, []string) {
+	added := 0
+	invalid := []string{}
+	for _, value := range values {
+		if err := utils.SuppressAddress(sc.DB, userID, value, source, reason, nil); err != nil {
+			invalid = append(invalid, value)
+			continue
+		}
+		added++
+	}
+	return added, invalid
+}
+
+// suppressionHeader detects a header row and returns the value and reason columns
+func suppressionHeader(row []string) (int, int, bool) {
+	valueCol, reasonCol := -1, -1
+	for i, col := range row {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "email", "domain", "value", "address":
+			if valueCol == -1 {
+				valueCol = i
+			}
+		case "reason":
+			reasonCol = i
+		}
+	}
+	if valueCol == -1 {
+		return 0, -1, false
+	}
+	return valueCol, reasonCol, true
+}
@@ -0,0 +1,289 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"mailnexy/config"
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// receivedAttachment is an attachment or inline part read from a message
+type receivedAttachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Data        []byte
+}
+
+// cidRe matches cid: URLs in HTML bodies, which point at inline parts
+var cidRe = regexp.MustCompile(`(?i)cid:([^"'\s)>]+)`)
+
+// Extensions for unnamed parts where the first registered one is unusual
+var preferredExtensions = map[string]string{
+	"text/plain": ".txt",
+	"text/html":  ".html",
+	"image/jpeg": ".jpg",
+}
+
+// attachmentFilename names parts that arrive without a filename
+func attachmentFilename(filename, contentType string, n int) string {
+	if filename != "" {
+		return filename
+	}
+	name := fmt.Sprintf("attachment-%d", n)
+	if ext, ok := preferredExtensions[contentType]; ok {
+		return name + ext
+	}
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+// storeAttachments keeps the content of an email's attachments in the blob
+// store, up to the user's storage quota. Content the user already has is not
+// stored again. Attachments that do not fit are recorded without content.
+func (uc *UniboxController) storeAttachments(email *models.UniboxEmail, files []receivedAttachment) {
+	if len(files) == 0 {
+		return
+	}
+
+	quota := uc.storageQuota(email.UserID)
+	used, err := uc.storageUsed(email.UserID)
+	if err != nil {
+		uc.logger.Printf("Failed to compute storage used by user %d: %v", email.UserID, err)
+		return
+	}
+
+	for _, file := range files {
+		sum := sha256.Sum256(file.Data)
+		attachment := models.UniboxAttachment{
+			UserID:      email.UserID,
+			EmailID:     email.ID,
+			Filename:    file.Filename,
+			ContentType: file.ContentType,
+			Size:        int64(len(file.Data)),
+			Checksum:    hex.EncodeToString(sum[:]),
+			Inline:      file.Inline,
+			ContentID:   file.ContentID,
+		}
+		if attachment.ContentType == "" {
+			attachment.ContentType = "application/octet-stream"
+		}
+		key := fmt.Sprintf("unibox/%d/%s", email.UserID, attachment.Checksum)
+
+		var existing int64
+		uc.db.Model(&models.UniboxAttachment{}).
+			Where("user_id = ? AND storage_key = ?", email.UserID, key).
+			Count(&existing)
+
+		switch {
+		case existing > 0:
+			attachment.StorageKey = key
+		case used+attachment.Size > quota:
+			uc.logger.Printf("Storage quota of user %d reached, not keeping %s of email %d", email.UserID, file.Filename, email.ID)
+		default:
+			if err := utils.GetBlobStore().Put(key, file.Data, attachment.ContentType); err != nil {
+				uc.logger.Printf("Failed to store %s of email %d: %v", file.Filename, email.ID, err)
+				break
+			}
+			attachment.StorageKey = key
+			used += attachment.Size
+		}
+		attachment.Stored = attachment.StorageKey != ""
+
+		if err := uc.db.Create(&attachment).Error; err != nil {
+			uc.logger.Printf("Failed to save attachment %s of email %d: %v", file.Filename, email.ID, err)
+		}
+	}
+}
+
+// storageQuota is how many bytes of attachments a user may keep
+func (uc *UniboxController) storageQuota(userID uint) int64 {
+	quotaMB := config.AppConfig.UniboxStorageQuotaMB
+	var user models.User
+	if err := uc.db.Select("storage_quota_mb").First(&user, userID).Error; err == nil && user.StorageQuotaMB > 0 {
+		quotaMB = user.StorageQuotaMB
+	}
+	return int64(quotaMB) << 20
+}
+
+// storageUsed is how many bytes of attachment content a user has stored,
+// counting content shared by several emails once
+func (uc *UniboxController) storageUsed(userID uint) (int64, error) {
+	var used int64
+	err := uc.db.Raw(`SELECT COALESCE(SUM(size), 0) FROM (
+			SELECT DISTINCT storage_key, size FROM unibox_attachments
+			WHERE user_id = ? AND stored AND deleted_at IS NULL
+		) AS stored_content`, userID).Scan(&used).Error
+	return used, err
+}
+
+// GetEmailAttachments lists the attachments and inline images of an email
+func (uc *UniboxController) GetEmailAttachments(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var email models.UniboxEmail
+	if err := uc.db.Select("id").Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&email).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Email not found",
+		})
+	}
+
+	var attachments []models.UniboxAttachment
+	if err := uc.db.Where("email_id = ? AND user_id = ?", email.ID, user.ID).
+		Order("id").
+		Find(&attachments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch attachments",
+		})
+	}
+
+	return c.JSON(attachments)
+}
+
+// DownloadAttachment returns the content of an attachment. Images are shown
+// in the browser with ?inline=true; everything else is downloaded.
+func (uc *UniboxController) DownloadAttachment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var attachment models.UniboxAttachment
+	if err := uc.db.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&attachment).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment not found",
+		})
+	}
+	if !attachment.Stored {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment content was not stored",
+		})
+	}
+
+	data, err := utils.GetBlobStore().Get(attachment.StorageKey)
+	if errors.Is(err, utils.ErrBlobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment content not found",
+		})
+	}
+	if err != nil {
+		uc.logger.Printf("Failed to load attachment %d: %v", attachment.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load attachment",
+		})
+	}
+
+	// SVG can carry scripts, so it is always downloaded
+	disposition := "attachment"
+	if c.Query("inline") == "true" && strings.HasPrefix(attachment.ContentType, "image/") &&
+		attachment.ContentType != "image/svg+xml" {
+		disposition = "inline"
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	c.Set(fiber.HeaderETag, `"`+attachment.Checksum+`"`)
+	return c.Send(data)
+}
+
+// GetStorageUsage reports how much of their attachment storage quota the
+// user has used
+func (uc *UniboxController) GetStorageUsage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	used, err := uc.storageUsed(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to compute storage usage",
+		})
+	}
+
+	var notStored int64
+	uc.db.Model(&models.UniboxAttachment{}).Where("user_id = ? AND NOT stored", user.ID).Count(&notStored)
+
+	return c.JSON(fiber.Map{
+		"used":       used,
+		"quota":      uc.storageQuota(user.ID),
+		"not_stored": notStored,
+	})
+}
+
+// attachmentURL is where the browser loads an inline image from
+func attachmentURL(attachmentID uint) string {
+	return fmt.Sprintf("%s/api/v1/unibox/attachments/%d?inline=true", strings.TrimRight(config.AppConfig.BaseURL, "/"), attachmentID)
+}
+
+// resolveInlineImages points the cid: references of an email's HTML body at
+// the download URLs of its stored inline parts. Emails need their Files
+// loaded. The stored body keeps its cid: references for forwarding.
+func resolveInlineImages(email *models.UniboxEmail) {
+	if email.BodyHTML == "" || len(email.Files) == 0 {
+		return
+	}
+
+	urls := make(map[string]string)
+	for _, file := range email.Files {
+		if file.ContentID != "" && file.Stored {
+			urls[strings.ToLower(file.ContentID)] = attachmentURL(file.ID)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	email.BodyHTML = cidRe.ReplaceAllStringFunc(email.BodyHTML, func(ref string) string {
+		contentID := ref[len("cid:"):]
+		if unescaped, err := url.PathUnescape(contentID); err == nil {
+			contentID = unescaped
+		}
+		if u, ok := urls[strings.ToLower(contentID)]; ok {
+			return u
+		}
+		return ref
+	})
+}
+
+// originalAttachments loads the stored attachments of an email to send them
+// on. With inlineOnly, only the parts its HTML body references by cid: are
+// loaded, so a quoted body still shows its images.
+func (uc *UniboxController) originalAttachments(original *models.UniboxEmail, inlineOnly bool) ([]utils.Attachment, error) {
+	query := uc.db.Where("email_id = ? AND stored", original.ID)
+	if inlineOnly {
+		query = query.Where("inline AND content_id <> ''")
+	}
+
+	var records []models.UniboxAttachment
+	if err := query.Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	attachments := make([]utils.Attachment, 0, len(records))
+	for _, record := range records {
+		data, err := utils.GetBlobStore().Get(record.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load attachment %s: %v", record.Filename, err)
+		}
+		attachment := utils.Attachment{
+			Filename:    record.Filename,
+			ContentType: record.ContentType,
+			Data:        data,
+		}
+		if record.Inline {
+			attachment.ContentID = record.ContentID
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
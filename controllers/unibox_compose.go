@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+// composeInput is the message a user writes in the Unibox. It is sent as
+// JSON, or as multipart/form-data when files are attached under "attachments".
+type composeInput struct {
+	SenderID uint     `json:"sender_id" form:"sender_id"`
+	To       string   `json:"to" form:"to"` // one address or a comma-separated list
+	CC       []string `json:"cc" form:"cc"`
+	BCC      []string `json:"bcc" form:"bcc"`
+	Subject  string   `json:"subject" form:"subject"`
+	Body     string   `json:"body" form:"body"` // HTML
+	Text     string   `json:"text" form:"text"` // plain text, used when there is no HTML body
+}
+
+// Common names of the sent folder on servers that do not flag it \Sent
+var sentMailboxNames = []string{"Sent", "Sent Items", "Sent Messages", "Sent Mail", "INBOX.Sent", "[Gmail]/Sent Mail"}
+
+// ComposeEmail sends a new email from one of the user's senders
+func (uc *UniboxController) ComposeEmail(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var input composeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if strings.TrimSpace(input.To) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one recipient is required",
+		})
+	}
+
+	email := utils.Email{
+		To:      input.To,
+		CC:      input.CC,
+		BCC:     input.BCC,
+		Subject: input.Subject,
+		Body:    composeHTML(input),
+	}
+	return uc.sendFromUnibox(c, user, input.SenderID, email)
+}
+
+// ReplyEmail answers the sender of an email
+func (uc *UniboxController) ReplyEmail(c *fiber.Ctx) error {
+	return uc.respond(c, "reply")
+}
+
+// ReplyAllEmail answers the sender and every other recipient of an email
+func (uc *UniboxController) ReplyAllEmail(c *fiber.Ctx) error {
+	return uc.respond(c, "reply_all")
+}
+
+// ForwardEmail sends an email on to new recipients
+func (uc *UniboxController) ForwardEmail(c *fiber.Ctx) error {
+	return uc.respond(c, "forward")
+}
+
+func (uc *UniboxController) respond(c *fiber.Ctx, mode string) error {
+	user := c.Locals("user").(*models.User)
+
+	var original models.UniboxEmail
+	if err := uc.db.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&original).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Email not found",
+		})
+	}
+
+	var input composeInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if input.SenderID == 0 {
+		input.SenderID = original.SenderID
+	}
+
+	email := utils.Email{
+		To:      input.To,
+		CC:      input.CC,
+		BCC:     input.BCC,
+		Subject: input.Subject,
+		Headers: map[string]string{},
+	}
+
+	if mode == "forward" {
+		if strings.TrimSpace(email.To) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "At least one recipient is required",
+			})
+		}
+		if email.Subject == "" {
+			email.Subject = prefixSubject("Fwd: ", original.Subject)
+		}
+		email.Body = composeHTML(input) + forwardedHTML(&original)
+		attachments, err := uc.originalAttachments(&original, false)
+		if err != nil {
+			uc.logger.Printf("Failed to load attachments of email %d: %v", original.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to load the attachments of the email",
+			})
+		}
+		email.Attachments = attachments
+		return uc.sendFromUnibox(c, user, input.SenderID, email)
+	}
+
+	// Replies go back to whoever the conversation is with, at the address
+	// they asked replies to go to
+	if email.To == "" {
+		switch {
+		case original.IsSent:
+			email.To = original.To
+		case original.ReplyTo != "":
+			email.To = original.ReplyTo
+		default:
+			email.To = original.From
+		}
+	}
+	if email.Subject == "" {
+		email.Subject = prefixSubject("Re: ", original.Subject)
+	}
+	if !strings.HasSuffix(original.MessageID, "@unibox.local>") {
+		email.Headers["In-Reply-To"] = original.MessageID
+		email.Headers["References"] = strings.TrimSpace(original.References + " " + original.MessageID)
+	}
+	email.Body = composeHTML(input) + quotedHTML(&original)
+	if strings.Contains(strings.ToLower(original.BodyHTML), "cid:") {
+		attachments, err := uc.originalAttachments(&original, true)
+		if err != nil {
+			uc.logger.Printf("Failed to load inline images of email %d: %v", original.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to load the images of the email",
+			})
+		}
+		email.Attachments = attachments
+	}
+
+	if mode == "reply_all" {
+		var sender models.Sender
+		if err := uc.db.Where("id = ? AND user_id = ?", input.SenderID, user.ID).First(&sender).Error; err == nil {
+			email.CC = append(email.CC, replyAllRecipients(&original, email.To, sender.FromEmail)...)
+		}
+	}
+
+	return uc.sendFromUnibox(c, user, input.SenderID, email)
+}
+
+// sendFromUnibox sends the email, with any uploaded files added to its
+// attachments, through the sender's mailbox, stores it in the Sent folder
+// and its conversation and appends it to the sender's IMAP sent folder
+func (uc *UniboxController) sendFromUnibox(c *fiber.Ctx, user *models.User, senderID uint, email utils.Email) error {
+	var sender models.Sender
+	if err := uc.db.Where("id = ? AND user_id = ?", senderID, user.ID).First(&sender).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid sender account",
+		})
+	}
+	if sender.NeedsReconnect {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Sender needs to be reconnected",
+		})
+	}
+
+	attachments, err := formAttachments(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	email.Attachments = append(email.Attachments, attachments...)
+
+	var total int
+	for _, attachment := range email.Attachments {
+		total += len(attachment.Data)
+	}
+	if total > maxCampaignAttachments {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Attachments exceed 20MB in total",
+		})
+	}
+
+	sent, err := uc.mailService.Send(&sender, email)
+	if err != nil {
+		uc.logger.Printf("Unibox send through sender %d failed: %v", sender.ID, err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to send email: " + err.Error(),
+		})
+	}
+
+	for _, rejection := range sent.Refused {
+		uc.logger.Printf("Unibox send through sender %d: copy not delivered: %v", sender.ID, rejection)
+	}
+
+	stored, err := uc.storeSentMessage(&sender, sent)
+	if err != nil {
+		uc.logger.Printf("Failed to store sent message %s: %v", sent.MessageID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Email was sent but could not be saved",
+		})
+	}
+
+	// The Gmail API and Microsoft Graph keep a copy in Sent themselves
+	if sender.IMAPHost != "" && utils.OAuthMailProvider(&sender) == "" {
+		go uc.appendToSent(sender, sent.Raw)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(stored)
+}
+
+// storeSentMessage keeps a copy of a sent message in the Unibox
+func (uc *UniboxController) storeSentMessage(sender *models.Sender, sent *utils.SentMessage) (*models.UniboxEmail, error) {
+	if err := uc.createSystemFolders(sender.UserID); err != nil {
+		uc.logger.Printf("Failed to create system folders: %v", err)
+	}
+
+	email, _, files, err := parseMessage(sent.Raw)
+	if err != nil {
+		return nil, err
+	}
+	email.UserID = sender.UserID
+	email.SenderID = sender.ID
+	email.MessageID = sent.MessageID
+	email.IsSent = true
+	email.IsRead = true
+
+	if err := uc.saveEmail(email, "Sent"); err != nil {
+		return nil, err
+	}
+	uc.storeAttachments(email, files)
+	uc.threadEmail(email)
+	return email, nil
+}
+
+// appendToSent saves a copy of a message sent over SMTP in the sender's IMAP
+// sent folder, where the sender's own mail client shows it
+func (uc *UniboxController) appendToSent(sender models.Sender, raw []byte) {
+	imapClient, err := uc.DialIMAP(&sender)
+	if err != nil {
+		uc.logger.Printf("Failed to append sent message for sender %d: %v", sender.ID, err)
+		return
+	}
+	defer imapClient.Logout()
+
+	mailbox, err := sentMailbox(imapClient)
+	if err != nil {
+		uc.logger.Printf("Failed to find sent folder of sender %d: %v", sender.ID, err)
+		return
+	}
+	if err := imapClient.Append(mailbox, []string{imap.SeenFlag}, time.Now(), bytes.NewBuffer(raw)); err != nil {
+		uc.logger.Printf("Failed to append sent message to %s for sender %d: %v", mailbox, sender.ID, err)
+	}
+}
+
+// sentMailbox finds the mailbox flagged \Sent, or one with a common sent
+// folder name, and creates "Sent" when there is neither
+func sentMailbox(imapClient *client.Client) (string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 20)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.List("", "*", mailboxes)
+	}()
+
+	var flagged string
+	names := make(map[string]string)
+	for info := range mailboxes {
+		for _, attr := range info.Attributes {
+			if attr == imap.SentAttr && flagged == "" {
+				flagged = info.Name
+			}
+		}
+		names[strings.ToLower(info.Name)] = info.Name
+	}
+	if err := <-done; err != nil {
+		return "", err
+	}
+
+	if flagged != "" {
+		return flagged, nil
+	}
+	for _, name := range sentMailboxNames {
+		if existing, ok := names[strings.ToLower(name)]; ok {
+			return existing, nil
+		}
+	}
+	if err := imapClient.Create("Sent"); err != nil {
+		return "", err
+	}
+	return "Sent", nil
+}
+
+// formAttachments reads the files uploaded under "attachments"
+func formAttachments(c *fiber.Ctx) ([]utils.Attachment, error) {
+	if !strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		return nil, nil
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid form data")
+	}
+
+	var attachments []utils.Attachment
+	var total int64
+	for _, file := range form.File["attachments"] {
+		if file.Size > maxAttachmentSize {
+			return nil, fmt.Errorf("File %s is too large (max 10MB)", file.Filename)
+		}
+		total += file.Size
+		if total > maxCampaignAttachments {
+			return nil, fmt.Errorf("Attachments exceed 20MB in total")
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open file %s", file.Filename)
+		}
+		data, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read file %s", file.Filename)
+		}
+
+		filename := filepath.Base(file.Filename)
+		attachments = append(attachments, utils.Attachment{
+			Filename:    filename,
+			ContentType: utils.DetectContentType(filename, http.DetectContentType(data)),
+			Data:        data,
+		})
+	}
+	return attachments, nil
+}
+
+// composeHTML is the HTML the user wrote, or their plain text as HTML
+func composeHTML(input composeInput) string {
+	if input.Body != "" {
+		return input.Body
+	}
+	return strings.ReplaceAll(html.EscapeString(input.Text), "\n", "<br>")
+}
+
+// originalHTML is an email's HTML body, or its text body as HTML
+func originalHTML(email *models.UniboxEmail) string {
+	if email.BodyHTML != "" {
+		return email.BodyHTML
+	}
+	return strings.ReplaceAll(html.EscapeString(email.Body), "\n", "<br>")
+}
+
+func quotedHTML(original *models.UniboxEmail) string {
+	return fmt.Sprintf(`<br><div class="unibox_quote">On %s, %s wrote:<br><blockquote style="margin:0 0 0 .8ex;border-left:1px solid #ccc;padding-left:1ex">%s</blockquote></div>`,
+		original.Date.Format("Mon, Jan 2, 2006 at 3:04 PM"), html.EscapeString(displayAddresses(original.From)), originalHTML(original))
+}
+
+func forwardedHTML(original *models.UniboxEmail) string {
+	var b strings.Builder
+	b.WriteString(`<br><div class="unibox_forward">---------- Forwarded message ---------<br>`)
+	fmt.Fprintf(&b, "From: %s<br>", html.EscapeString(displayAddresses(original.From)))
+	fmt.Fprintf(&b, "Date: %s<br>", original.Date.Format("Mon, Jan 2, 2006 at 3:04 PM"))
+	fmt.Fprintf(&b, "Subject: %s<br>", html.EscapeString(original.Subject))
+	fmt.Fprintf(&b, "To: %s<br>", html.EscapeString(displayAddresses(original.To)))
+	if original.CC != "" {
+		fmt.Fprintf(&b, "Cc: %s<br>", html.EscapeString(displayAddresses(original.CC)))
+	}
+	b.WriteString("<br>")
+	b.WriteString(originalHTML(original))
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// displayAddresses writes a stored address list for people to read, with
+// encoded names decoded
+func displayAddresses(list string) string {
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil {
+		return list
+	}
+	result := make([]string, len(addrs))
+	for i, addr := range addrs {
+		result[i] = addr.Address
+		if addr.Name != "" {
+			result[i] = addr.Name + " <" + addr.Address + ">"
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+// prefixSubject adds Re: or Fwd: unless the subject already has it
+func prefixSubject(prefix, subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		return subject
+	}
+	return prefix + subject
+}
+
+// replyAllRecipients lists the original recipients to copy on a reply to
+// all, leaving out the sender's own address and those already in To
+func replyAllRecipients(original *models.UniboxEmail, to, ownAddress string) []string {
+	skip := map[string]bool{strings.ToLower(ownAddress): true}
+	if list, err := mail.ParseAddressList(to); err == nil {
+		for _, addr := range list {
+			skip[strings.ToLower(addr.Address)] = true
+		}
+	}
+
+	var recipients []string
+	for _, field := range []string{original.To, original.CC} {
+		list, err := mail.ParseAddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			key := strings.ToLower(addr.Address)
+			if skip[key] {
+				continue
+			}
+			skip[key] = true
+			recipients = append(recipients, addr.String())
+		}
+	}
+	return recipients
+}
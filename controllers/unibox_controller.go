@@ -1,12 +1,18 @@
 package controller
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"mailnexy/config"
 	"mailnexy/models"
@@ -20,14 +26,16 @@ import (
 )
 
 type UniboxController struct {
-	db     *gorm.DB
-	logger *log.Logger
+	db          *gorm.DB
+	mailService utils.MailServiceInterface
+	logger      *log.Logger
 }
 
 func NewUniboxController(db *gorm.DB, logger *log.Logger) *UniboxController {
 	return &UniboxController{
-		db:     db,
-		logger: logger,
+		db:          db,
+		mailService: utils.SenderMailer(),
+		logger:      logger,
 	}
 }
 
@@ -43,18 +51,11 @@ func (uc *UniboxController) FetchEmails(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create system folders if they don't exist
-	if err := uc.createSystemFolders(user.ID); err != nil {
-		uc.logger.Printf("Failed to create system folders: %v", err)
-	}
-
 	// Fetch emails from each sender
 	for _, sender := range senders {
-		if sender.IMAPHost != "" {
-			if err := uc.fetchFromIMAP(&sender, user.ID); err != nil {
-				uc.logger.Printf("Failed to fetch emails from sender %d: %v", sender.ID, err)
-				continue
-			}
+		if err := uc.SyncSender(&sender); err != nil {
+			uc.logger.Printf("Failed to fetch emails from sender %d: %v", sender.ID, err)
+			continue
 		}
 	}
 
@@ -64,7 +65,7 @@ func (uc *UniboxController) FetchEmails(c *fiber.Ctx) error {
 }
 
 func (uc *UniboxController) createSystemFolders(userID uint) error {
-	systemFolders := []string{"Inbox", "Sent", "Drafts", "Spam", "Trash", "Archive"}
+	systemFolders := []string{"Inbox", "Sent", "Drafts", "Spam", "Trash", "Archive", "Bounces"}
 
 	for _, folderName := range systemFolders {
 		var existingFolder models.UniboxFolder
@@ -86,10 +87,87 @@ func (uc *UniboxController) createSystemFolders(userID uint) error {
 	return nil
 }
 
-func (uc *UniboxController) fetchFromIMAP(sender *models.Sender, userID uint) error {
+// SyncSender fetches a sender's new mail into its user's Unibox
+func (uc *UniboxController) SyncSender(sender *models.Sender) error {
+	// Create system folders if they don't exist
+	if err := uc.createSystemFolders(sender.UserID); err != nil {
+		uc.logger.Printf("Failed to create system folders: %v", err)
+	}
+	return uc.fetchSenderMail(sender, sender.UserID)
+}
+
+// SyncIMAPMailbox fetches new mail over an already connected IMAP client,
+// leaving the sender's mailbox selected
+func (uc *UniboxController) SyncIMAPMailbox(imapClient *client.Client, sender *models.Sender) error {
+	if err := uc.createSystemFolders(sender.UserID); err != nil {
+		uc.logger.Printf("Failed to create system folders: %v", err)
+	}
+	return uc.syncMailbox(imapClient, sender, sender.UserID, SenderMailbox(sender))
+}
+
+// fetchSenderMail reads new mail through the Gmail API or Microsoft Graph for
+// OAuth-connected senders and through IMAP for everyone else
+func (uc *UniboxController) fetchSenderMail(sender *models.Sender, userID uint) error {
+	if utils.OAuthMailProvider(sender) != "" {
+		return uc.syncAPIMailbox(sender, userID)
+	}
+
+	if sender.IMAPHost != "" {
+		return uc.fetchFromIMAP(sender, userID)
+	}
+	return nil
+}
+
+const (
+	// apiSyncMailbox names the sync state of mail read through a provider API
+	apiSyncMailbox = "api:inbox"
+	// apiSyncLimit caps how many messages one API sync imports; the first
+	// sync takes the newest ones
+	apiSyncLimit = 500
+)
+
+// syncAPIMailbox fetches the inbox messages received since the last sync,
+// read or not, so mail the user already opened in Gmail or Outlook is still
+// imported. The sync resumes below the first message that failed to store.
+func (uc *UniboxController) syncAPIMailbox(sender *models.Sender, userID uint) error {
+	lock, _ := mailboxLocks.LoadOrStore(fmt.Sprintf("%d/%s", sender.ID, apiSyncMailbox), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	state := models.MailboxSyncState{SenderID: sender.ID, Mailbox: apiSyncMailbox}
+	if err := uc.db.Where(&state).FirstOrCreate(&state).Error; err != nil {
+		return fmt.Errorf("failed to load sync state: %v", err)
+	}
+
+	var since time.Time
+	if state.LastReceivedAt != nil {
+		since = *state.LastReceivedAt
+	}
+	received, err := utils.FetchOAuthMessages(sender, since, apiSyncLimit, func(raw []byte) error {
+		err := uc.processRawMessage(bytes.NewReader(raw), userID, sender.ID, "", 0)
+		if err != nil {
+			uc.logger.Printf("Failed to process message for sender %d: %v", sender.ID, err)
+		}
+		return err
+	})
+	if received.After(since) {
+		state.LastReceivedAt = &received
+	}
+	if err == nil {
+		now := time.Now()
+		state.LastSyncedAt = &now
+	}
+	if saveErr := uc.db.Save(&state).Error; saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// DialIMAP connects and logs in to the sender's IMAP server
+func (uc *UniboxController) DialIMAP(sender *models.Sender) (*client.Client, error) {
 	password, err := utils.Decrypt(sender.IMAPPassword)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt IMAP password: %v", err)
+		return nil, fmt.Errorf("failed to decrypt IMAP password: %v", err)
 	}
 
 	var imapClient *client.Client
@@ -114,156 +192,448 @@ func (uc *UniboxController) fetchFromIMAP(sender *models.Sender, userID uint) er
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to connect to IMAP server: %v", err)
+		return nil, fmt.Errorf("failed to connect to IMAP server: %v", err)
 	}
-	defer imapClient.Logout()
 
 	if err := imapClient.Login(sender.IMAPUsername, password); err != nil {
-		return fmt.Errorf("failed to login to IMAP server: %v", err)
+		imapClient.Logout()
+		return nil, fmt.Errorf("failed to login to IMAP server: %v", err)
 	}
+	return imapClient, nil
+}
 
-	mailbox := "INBOX"
+// SenderMailbox is the IMAP mailbox the Unibox reads for a sender
+func SenderMailbox(sender *models.Sender) string {
 	if sender.IMAPMailbox != "" {
-		mailbox = sender.IMAPMailbox
+		return sender.IMAPMailbox
 	}
+	return "INBOX"
+}
 
-	_, err = imapClient.Select(mailbox, false)
+func (uc *UniboxController) fetchFromIMAP(sender *models.Sender, userID uint) error {
+	imapClient, err := uc.DialIMAP(sender)
 	if err != nil {
-		return fmt.Errorf("failed to select mailbox: %v", err)
+		return err
+	}
+	defer imapClient.Logout()
+
+	return uc.syncMailbox(imapClient, sender, userID, SenderMailbox(sender))
+}
+
+// syncMailbox fetches the messages that arrived in a mailbox since the last
+// sync, read or not. Only UIDs above the highest one stored are fetched,
+// unless the server changed the mailbox's UIDVALIDITY, which invalidates
+// every stored UID and starts the sync over.
+func (uc *UniboxController) syncMailbox(imapClient *client.Client, sender *models.Sender, userID uint, mailbox string) error {
+	// The poller, IDLE sessions and manual fetches may sync the same mailbox
+	lock, _ := mailboxLocks.LoadOrStore(fmt.Sprintf("%d/%s", sender.ID, mailbox), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// An IDLE session already has the mailbox selected; selecting it again
+	// would announce the mailbox as changed and trigger another sync
+	status := imapClient.Mailbox()
+	uidNext := uint32(0) // not kept current on a long-lived selection
+	if status == nil || status.Name != mailbox {
+		var err error
+		if status, err = imapClient.Select(mailbox, true); err != nil {
+			return fmt.Errorf("failed to select mailbox: %v", err)
+		}
+		uidNext = status.UidNext
+	}
+
+	state := models.MailboxSyncState{SenderID: sender.ID, Mailbox: mailbox}
+	if err := uc.db.Where(&state).FirstOrCreate(&state).Error; err != nil {
+		return fmt.Errorf("failed to load sync state: %v", err)
+	}
+
+	if state.UIDValidity != status.UidValidity {
+		if state.UIDValidity != 0 {
+			uc.logger.Printf("UIDVALIDITY of %s for sender %d changed from %d to %d, resyncing",
+				mailbox, sender.ID, state.UIDValidity, status.UidValidity)
+		}
+		state.UIDValidity = status.UidValidity
+		state.LastUID = 0
+	}
+
+	// UIDNEXT tells whether anything arrived without searching
+	if uidNext == 0 || uidNext > state.LastUID+1 {
+		uids, err := uc.newUIDs(imapClient, state.LastUID)
+		if err != nil {
+			return err
+		}
+
+		for start := 0; start < len(uids); start += imapFetchBatchSize {
+			end := start + imapFetchBatchSize
+			if end > len(uids) {
+				end = len(uids)
+			}
+			lastUID, err := uc.fetchUIDs(imapClient, uids[start:end], userID, sender.ID, mailbox)
+			if lastUID > state.LastUID {
+				state.LastUID = lastUID
+			}
+			if err != nil {
+				uc.saveSyncState(&state)
+				return err
+			}
+			// Progress is kept per batch so an interrupted sync resumes
+			uc.saveSyncState(&state)
+		}
 	}
 
+	now := time.Now()
+	state.LastSyncedAt = &now
+	return uc.db.Save(&state).Error
+}
+
+// mailboxLocks serializes syncs of one mailbox, keyed by sender ID and mailbox
+var mailboxLocks sync.Map
+
+const (
+	imapFetchBatchSize = 100
+	// imapInitialSyncLimit caps how many of the newest messages the first
+	// sync of a mailbox (or a resync) imports
+	imapInitialSyncLimit = 1000
+)
+
+// newUIDs lists the UIDs above lastUID in ascending order
+func (uc *UniboxController) newUIDs(imapClient *client.Client, lastUID uint32) ([]uint32, error) {
 	criteria := imap.NewSearchCriteria()
-	criteria.WithoutFlags = []string{"\\Seen"}
-	ids, err := imapClient.Search(criteria)
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+	found, err := imapClient.UidSearch(criteria)
 	if err != nil {
-		return fmt.Errorf("failed to search messages: %v", err)
+		return nil, fmt.Errorf("failed to search messages: %v", err)
 	}
 
-	if len(ids) == 0 {
-		return nil
+	// "n:*" always matches the last message, even below n
+	uids := found[:0]
+	for _, uid := range found {
+		if uid > lastUID {
+			uids = append(uids, uid)
+		}
 	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
 
+	if lastUID == 0 && len(uids) > imapInitialSyncLimit {
+		uids = uids[len(uids)-imapInitialSyncLimit:]
+	}
+	return uids, nil
+}
+
+// fetchUIDs stores the given messages, which must be in ascending order, and
+// returns the highest UID up to which all of them were stored. When a message
+// fails, the UID stays below it so the next sync fetches it again.
+func (uc *UniboxController) fetchUIDs(imapClient *client.Client, uids []uint32, userID uint, senderID uint, mailbox string) (uint32, error) {
 	seqset := new(imap.SeqSet)
-	seqset.AddNum(ids...)
+	seqset.AddNum(uids...)
 
 	messages := make(chan *imap.Message, 10)
 	done := make(chan error, 1)
 
-	// --- CHANGE MADE HERE ---
-	// Replaced imap.FetchRFC822 with imap.FetchRFC822Peek and removed invalid imap.FetchBody
+	// BODY.PEEK[] leaves the \Seen flag alone
 	go func() {
-		done <- imapClient.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchItem("BODY.PEEK[]")}, messages)
+		done <- imapClient.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchItem("BODY.PEEK[]")}, messages)
 	}()
 
+	stored := make(map[uint32]bool, len(uids)) // whether each message received was stored
+	var processErr error
 	for msg := range messages {
-		if err := uc.processIMAPMessage(msg, userID, sender.ID); err != nil {
-			uc.logger.Printf("Failed to process message %d: %v", msg.SeqNum, err)
-			continue
+		err := uc.processIMAPMessage(msg, userID, senderID, mailbox)
+		if err != nil {
+			uc.logger.Printf("Failed to process message UID %d: %v", msg.Uid, err)
+			if processErr == nil {
+				processErr = fmt.Errorf("failed to store message UID %d: %v", msg.Uid, err)
+			}
+		}
+		stored[msg.Uid] = err == nil
+	}
+	fetchErr := <-done
+
+	var lastUID uint32
+	for _, uid := range uids {
+		ok, received := stored[uid]
+		if received && !ok {
+			break
+		}
+		// After a complete fetch, messages the server did not return were
+		// expunged meanwhile; after a failed one they may still come
+		if !received && fetchErr != nil {
+			break
+		}
+		lastUID = uid
+	}
+
+	if fetchErr != nil {
+		return lastUID, fmt.Errorf("error during fetch: %v", fetchErr)
+	}
+	return lastUID, processErr
+}
+
+func (uc *UniboxController) saveSyncState(state *models.MailboxSyncState) {
+	if err := uc.db.Save(state).Error; err != nil {
+		uc.logger.Printf("Failed to save sync state for sender %d: %v", state.SenderID, err)
+	}
+}
+
+func (uc *UniboxController) processIMAPMessage(msg *imap.Message, userID uint, senderID uint, mailbox string) error {
+	if msg.Body == nil {
+		return fmt.Errorf("message body not found")
+	}
+
+	// Get the RFC822 message body (entire message)
+	section := imap.BodySectionName{Peek: true}
+	literal := msg.GetBody(&section)
+	if literal == nil {
+		return fmt.Errorf("message body not found")
+	}
+
+	return uc.processRawMessage(literal, userID, senderID, mailbox, msg.Uid)
+}
+
+// processRawMessage parses a complete RFC 5322 message, whichever transport it
+// came from, and stores it in the user's Inbox. Delivery status notifications
+// are recorded as bounces and filed under Bounces instead. Replies to campaign
+// emails are tracked, with auto-replies kept apart from real ones. mailbox and
+// uid say where an IMAP message was fetched from and are empty otherwise.
+func (uc *UniboxController) processRawMessage(r io.Reader, userID uint, senderID uint, mailbox string, uid uint32) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read message: %v", err)
+	}
+
+	email, header, files, err := parseMessage(raw)
+	if err != nil {
+		return err
+	}
+
+	// The same message is seen again after a resync, through another
+	// transport or in API fetches until it is read, so it is only stored once
+	var existing models.UniboxEmail
+	err = uc.db.Where("user_id = ? AND sender_id = ? AND message_id = ?", userID, senderID, email.MessageID).
+		First(&existing).Error
+	if err == nil {
+		if uid != 0 && (existing.UID != uid || existing.Mailbox != mailbox) {
+			return uc.db.Model(&existing).Updates(map[string]interface{}{"mailbox": mailbox, "uid": uid}).Error
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to check for duplicate: %v", err)
+	}
+
+	email.UserID = userID
+	email.SenderID = senderID
+	email.Mailbox = mailbox
+	email.UID = uid
+
+	report, isBounce := utils.ParseDSN(raw)
+	var autoReply utils.AutoReply
+	if !isBounce {
+		text := email.Body
+		if text == "" {
+			text = utils.HTMLToText(email.BodyHTML)
 		}
+		autoReply = utils.DetectAutoReply(header, text, email.Date)
+		email.IsAutoReply = autoReply.IsAutoReply
 	}
 
-	if err := <-done; err != nil {
-		return fmt.Errorf("error during fetch: %v", err)
+	folderName := "Inbox"
+	if isBounce {
+		folderName = "Bounces"
 	}
+	if err := uc.saveEmail(email, folderName); err != nil {
+		return err
+	}
+	uc.storeAttachments(email, files)
 
+	if isBounce {
+		uc.recordBounces(report, userID, senderID)
+	} else {
+		uc.trackReply(email, autoReply)
+	}
+	uc.threadEmail(email)
 	return nil
 }
 
-func (uc *UniboxController) processIMAPMessage(msg *imap.Message, userID uint, senderID uint) error {
+// parseMessage reads the headers, bodies and attachments of a raw message
+// into an email that still needs its user and sender
+func parseMessage(raw []byte) (*models.UniboxEmail, mail.Header, []receivedAttachment, error) {
 	// Parse message
 	var bodyText, bodyHTML string
 	var attachments []string
+	var files []receivedAttachment
 
-	if msg.Body != nil {
-		// Get the RFC822 message body (entire message)
-		section := imap.BodySectionName{}
-		literal, ok := msg.Body[&section]
-		if !ok {
-			return fmt.Errorf("message body not found")
-		}
+	// Create a reader from the literal
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, mail.Header{}, nil, fmt.Errorf("failed to create message reader: %v", err)
+	}
 
-		// Create a reader from the literal
-		mr, err := mail.CreateReader(literal)
-		if err != nil {
-			return fmt.Errorf("failed to create message reader: %v", err)
+	header := mr.Header
+	messageID := strings.TrimSpace(header.Get("Message-Id"))
+	if messageID == "" {
+		// Without a Message-ID the content itself identifies the message
+		messageID = fmt.Sprintf("<%x@unibox.local>", sha256.Sum256(raw))
+	}
+
+	// Process each message part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break // Done with all parts
+		} else if err != nil {
+			return nil, header, nil, fmt.Errorf("failed to read next part: %v", err)
 		}
 
-		// Process each message part
-		for {
-			p, err := mr.NextPart()
-			if err == io.EOF {
-				break // Done with all parts
-			} else if err != nil {
-				return fmt.Errorf("failed to read next part: %v", err)
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			contentType, params, _ := h.ContentType()
+			b, err := io.ReadAll(p.Body)
+			if err != nil {
+				return nil, header, nil, fmt.Errorf("failed to read body: %v", err)
 			}
 
-			switch h := p.Header.(type) {
-			case *mail.InlineHeader:
-				contentType, _, _ := h.ContentType()
-				b, err := io.ReadAll(p.Body)
-				if err != nil {
-					return fmt.Errorf("failed to read body: %v", err)
-				}
+			_, dispParams, _ := h.ContentDisposition()
+			filename := dispParams["filename"]
+			if filename == "" {
+				filename = params["name"]
+			}
 
-				if strings.Contains(contentType, "text/html") {
-					bodyHTML = string(b)
-				} else if strings.Contains(contentType, "text/plain") {
-					bodyText = string(b)
+			if strings.Contains(contentType, "text/html") && filename == "" {
+				bodyHTML = string(b)
+			} else if strings.Contains(contentType, "text/plain") && filename == "" {
+				bodyText = string(b)
+			} else {
+				// Images shown in the body are referenced by Content-ID;
+				// other named parts are listed with the attachments
+				file := receivedAttachment{
+					Filename:    attachmentFilename(filename, contentType, len(files)+1),
+					ContentType: contentType,
+					ContentID:   strings.Trim(h.Get("Content-Id"), "<> "),
+					Data:        b,
 				}
-			case *mail.AttachmentHeader:
-				filename, _ := h.Filename()
-				attachments = append(attachments, filename)
-				// You could save the attachment here if needed
+				file.Inline = file.ContentID != ""
+				if !file.Inline {
+					attachments = append(attachments, file.Filename)
+				}
+				files = append(files, file)
+			}
+		case *mail.AttachmentHeader:
+			contentType, _, _ := h.ContentType()
+			disposition, _, _ := h.ContentDisposition()
+			filename, _ := h.Filename()
+			b, err := io.ReadAll(p.Body)
+			if err != nil {
+				return nil, header, nil, fmt.Errorf("failed to read attachment: %v", err)
 			}
+
+			// Parts without a disposition, such as the images of a
+			// multipart/related body, are inline when they have a Content-ID
+			file := receivedAttachment{
+				Filename:    attachmentFilename(filename, contentType, len(files)+1),
+				ContentType: contentType,
+				ContentID:   strings.Trim(h.Get("Content-Id"), "<> "),
+				Data:        b,
+			}
+			file.Inline = file.ContentID != "" && disposition != "attachment"
+			if !file.Inline {
+				attachments = append(attachments, file.Filename)
+			}
+			files = append(files, file)
 		}
 	}
 
-	// Rest of your function remains the same...
-	email := models.UniboxEmail{
-		UserID:      userID,
-		SenderID:    senderID,
-		MessageID:   msg.Envelope.MessageId,
-		ThreadID:    msg.Envelope.InReplyTo,
-		From:        formatAddress(msg.Envelope.From),
-		To:          formatAddress(msg.Envelope.To),
-		Subject:     msg.Envelope.Subject,
-		Body:        bodyText,
-		BodyHTML:    bodyHTML,
-		Date:        msg.Envelope.Date,
-		Attachments: attachments,
-	}
+	from, _ := header.AddressList("From")
+	to, _ := header.AddressList("To")
+	cc, _ := header.AddressList("Cc")
+	replyTo, _ := header.AddressList("Reply-To")
+	subject, _ := header.Subject()
+	date, err := header.Date()
+	if err != nil || date.IsZero() {
+		date = time.Now()
+	}
+
+	return &models.UniboxEmail{
+		MessageID:     messageID,
+		From:          formatMailAddresses(from),
+		To:            formatMailAddresses(to),
+		CC:            formatMailAddresses(cc),
+		ReplyTo:       formatMailAddresses(replyTo),
+		Subject:       subject,
+		ThreadSubject: utils.ThreadSubject(subject),
+		Body:          bodyText,
+		BodyHTML:      bodyHTML,
+		Date:          date,
+		Attachments:   attachments,
+		InReplyTo:     strings.TrimSpace(header.Get("In-Reply-To")),
+		References:    strings.TrimSpace(header.Get("References")),
+		Size:          len(raw),
+	}, header, files, nil
+}
 
-	// Save to database
-	if err := uc.db.Create(&email).Error; err != nil {
-		return fmt.Errorf("failed to save email: %v", err)
-	}
+// saveEmail stores a new email together with its place in one of the user's
+// folders, so a failure never leaves an email that no folder shows
+func (uc *UniboxController) saveEmail(email *models.UniboxEmail, folderName string) error {
+	return uc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(email).Error; err != nil {
+			return fmt.Errorf("failed to save email: %v", err)
+		}
+		return fileEmail(tx, email, folderName)
+	})
+}
 
-	// Add to Inbox folder
-	var inboxFolder models.UniboxFolder
-	if err := uc.db.Where("user_id = ? AND name = ?", userID, "Inbox").First(&inboxFolder).Error; err != nil {
-		return fmt.Errorf("failed to find Inbox folder: %v", err)
+// fileEmail adds an email to one of the user's folders
+func fileEmail(db *gorm.DB, email *models.UniboxEmail, folderName string) error {
+	var folder models.UniboxFolder
+	if err := db.Where("user_id = ? AND name = ?", email.UserID, folderName).First(&folder).Error; err != nil {
+		return fmt.Errorf("failed to find %s folder: %v", folderName, err)
 	}
 
 	emailFolder := models.UniboxEmailFolder{
 		EmailID:  email.ID,
-		FolderID: inboxFolder.ID,
+		FolderID: folder.ID,
 	}
 
-	if err := uc.db.Create(&emailFolder).Error; err != nil {
+	if err := db.Create(&emailFolder).Error; err != nil {
 		return fmt.Errorf("failed to add email to folder: %v", err)
 	}
-
 	return nil
 }
 
-func formatAddress(addrs []*imap.Address) string {
+// recordBounces stores a bounce for every failed recipient of a delivery
+// status notification, matched to the campaign email it reports on
+func (uc *UniboxController) recordBounces(report *utils.DSNReport, userID uint, senderID uint) {
+	activity, err := utils.FindBouncedActivity(uc.db, userID, report.OriginalMessageID)
+	if err != nil {
+		uc.logger.Printf("Failed to look up bounced message %s: %v", report.OriginalMessageID, err)
+	}
+
+	for _, recipient := range report.Recipients {
+		bounceType := recipient.BounceType()
+		if bounceType == "" {
+			continue
+		}
+
+		event := utils.BounceEvent{
+			Email:          recipient.Email,
+			Type:           bounceType,
+			Code:           recipient.Status,
+			Message:        utils.DescribeBounceStatus(recipient.Status),
+			DiagnosticCode: recipient.DiagnosticCode,
+		}
+		if err := utils.RecordBounce(uc.db, senderID, activity, event); err != nil {
+			uc.logger.Printf("Failed to record bounce for %s: %v", recipient.Email, err)
+		}
+	}
+}
+
+// formatMailAddresses writes an address list the way it appears in a header,
+// with names quoted or encoded, so it can be parsed again to reply
+func formatMailAddresses(addrs []*mail.Address) string {
 	var result []string
 	for _, addr := range addrs {
-		if addr.PersonalName != "" {
-			result = append(result, fmt.Sprintf("%s <%s>", addr.PersonalName, addr.MailboxName+"@"+addr.HostName))
-		} else {
-			result = append(result, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
-		}
+		result = append(result, addr.String())
 	}
 	return strings.Join(result, ", ")
 }
@@ -288,7 +658,8 @@ func (uc *UniboxController) GetEmails(c *fiber.Ctx) error {
 		Joins("JOIN unibox_email_folders ON unibox_email_folders.email_id = unibox_emails.id").
 		Where("unibox_email_folders.folder_id = ?", folder.ID).
 		Where("unibox_emails.user_id = ?", user.ID).
-		Preload("Sender")
+		Preload("Sender").
+		Preload("Files")
 
 	if search != "" {
 		query = query.Where("subject LIKE ? OR body LIKE ?", "%"+search+"%", "%"+search+"%")
@@ -308,6 +679,9 @@ func (uc *UniboxController) GetEmails(c *fiber.Ctx) error {
 			"error": "Failed to fetch emails",
 		})
 	}
+	for i := range emails {
+		resolveInlineImages(&emails[i])
+	}
 
 	return c.JSON(fiber.Map{
 		"data":  emails,
@@ -325,6 +699,7 @@ func (uc *UniboxController) GetEmail(c *fiber.Ctx) error {
 	var email models.UniboxEmail
 	if err := uc.db.Where("id = ? AND user_id = ?", emailID, user.ID).
 		Preload("Sender").
+		Preload("Files").
 		First(&email).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Email not found",
@@ -338,6 +713,7 @@ func (uc *UniboxController) GetEmail(c *fiber.Ctx) error {
 		}
 	}
 
+	resolveInlineImages(&email)
 	return c.JSON(email)
 }
 
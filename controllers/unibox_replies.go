@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"gorm.io/gorm"
+)
+
+// trackReply matches an incoming message to the campaign email it answers by
+// In-Reply-To and References. Real replies mark the email replied, which ends
+// the lead's sequence; auto-replies are recorded separately and, for
+// out-of-office notices with a return date, hold the lead until that date.
+func (uc *UniboxController) trackReply(email *models.UniboxEmail, autoReply utils.AutoReply) {
+	ids := utils.ParseReferences(email.References, email.InReplyTo)
+	if len(ids) == 0 {
+		return
+	}
+
+	var activity models.CampaignActivity
+	err := uc.db.Preload("Campaign").
+		Where("user_id = ? AND internet_message_id IN ? AND sent_at IS NOT NULL", email.UserID, ids).
+		Order("sent_at DESC").
+		First(&activity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		uc.logger.Printf("Failed to look up replied message for email %d: %v", email.ID, err)
+		return
+	}
+
+	uc.importCampaignMessage(&activity)
+
+	if autoReply.IsAutoReply {
+		err = uc.recordAutoReply(&activity, email, autoReply)
+	} else {
+		err = uc.recordReply(&activity, email)
+	}
+	if err != nil {
+		uc.logger.Printf("Failed to record reply to activity %d: %v", activity.ID, err)
+	}
+}
+
+func (uc *UniboxController) recordReply(activity *models.CampaignActivity, email *models.UniboxEmail) error {
+	if activity.RepliedAt != nil {
+		return nil
+	}
+
+	return uc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(activity).Update("replied_at", email.Date).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Campaign{}).Where("id = ?", activity.CampaignID).
+			UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Sender{}).Where("id = ?", activity.SenderID).
+			UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.LeadActivity{
+			LeadID:       activity.LeadID,
+			CampaignID:   &activity.CampaignID,
+			SenderID:     &activity.SenderID,
+			ActivityType: "replied",
+			ActivityAt:   email.Date,
+			Details:      email.Subject,
+		}).Error
+	})
+}
+
+func (uc *UniboxController) recordAutoReply(activity *models.CampaignActivity, email *models.UniboxEmail, autoReply utils.AutoReply) error {
+	return uc.db.Transaction(func(tx *gorm.DB) error {
+		if activity.AutoRepliedAt == nil {
+			if err := tx.Model(activity).Update("auto_replied_at", email.Date).Error; err != nil {
+				return err
+			}
+		}
+
+		activityType := "auto_replied"
+		if autoReply.OutOfOffice {
+			activityType = "out_of_office"
+		}
+		details := autoReply.Reason
+		if autoReply.ReturnDate != nil {
+			details += ", back on " + autoReply.ReturnDate.Format("2006-01-02")
+		}
+		if err := tx.Create(&models.LeadActivity{
+			LeadID:       activity.LeadID,
+			CampaignID:   &activity.CampaignID,
+			SenderID:     &activity.SenderID,
+			ActivityType: activityType,
+			ActivityAt:   email.Date,
+			Details:      details,
+		}).Error; err != nil {
+			return err
+		}
+
+		if !autoReply.OutOfOffice || autoReply.ReturnDate == nil || !activity.Campaign.RescheduleOutOfOffice {
+			return nil
+		}
+		// Pick up again the day after they are back
+		snoozedUntil := autoReply.ReturnDate.AddDate(0, 0, 1)
+		if snoozedUntil.Before(time.Now()) {
+			return nil
+		}
+		return tx.Model(&models.Lead{}).Where("id = ?", activity.LeadID).
+			Update("snoozed_until", snoozedUntil).Error
+	})
+}
+
+// importCampaignMessage copies the campaign email a reply answers from its
+// archive into the Sent folder, so the conversation shows both sides
+func (uc *UniboxController) importCampaignMessage(activity *models.CampaignActivity) {
+	if activity.ArchiveKey == "" {
+		return
+	}
+
+	var count int64
+	if err := uc.db.Model(&models.UniboxEmail{}).
+		Where("user_id = ? AND message_id = ?", activity.UserID, activity.InternetMessageID).
+		Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	raw, err := utils.LoadArchivedMessage(activity.ArchiveKey)
+	if err != nil {
+		if !errors.Is(err, utils.ErrBlobNotFound) {
+			uc.logger.Printf("Failed to load archived message for activity %d: %v", activity.ID, err)
+		}
+		return
+	}
+
+	email, _, files, err := parseMessage(raw)
+	if err != nil {
+		uc.logger.Printf("Failed to parse archived message for activity %d: %v", activity.ID, err)
+		return
+	}
+	email.UserID = activity.UserID
+	email.SenderID = activity.SenderID
+	email.MessageID = activity.InternetMessageID
+	email.IsSent = true
+	email.IsRead = true
+	if activity.SentAt != nil {
+		email.Date = *activity.SentAt
+	}
+
+	if err := uc.saveEmail(email, "Sent"); err != nil {
+		uc.logger.Printf("Failed to store campaign message for activity %d: %v", activity.ID, err)
+		return
+	}
+	uc.storeAttachments(email, files)
+	uc.threadEmail(email)
+}
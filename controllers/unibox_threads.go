@@ -0,0 +1,347 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// threadColumns are the columns threading needs, leaving out the bodies
+const threadColumns = `id, message_id, thread_id, subject, thread_subject, "from", "to", in_reply_to, "references", date, is_sent`
+
+// threadEmail files a new email into its conversation. It gathers the emails
+// the message references, those that reference it and those with its
+// subject and participant, together with the threads they are in, and
+// threads them again so that conversations the message connects are merged.
+func (uc *UniboxController) threadEmail(email *models.UniboxEmail) {
+	refs := utils.ParseReferences(email.References, email.InReplyTo)
+	related := uc.db.Where("message_id IN ?", append(refs, email.MessageID)).
+		Or(`"references" LIKE ?`, "%"+email.MessageID+"%").
+		Or("in_reply_to = ?", email.MessageID)
+	if participant := emailParticipant(email); email.ThreadSubject != "" && participant != "" {
+		related = related.Or(`thread_subject = ? AND ("from" ILIKE ? OR "to" ILIKE ?)`,
+			email.ThreadSubject, "%"+participant+"%", "%"+participant+"%")
+	}
+
+	var emails []models.UniboxEmail
+	if err := uc.db.Select(threadColumns).
+		Where("user_id = ?", email.UserID).
+		Where(related).
+		Find(&emails).Error; err != nil {
+		uc.logger.Printf("Failed to load related emails for email %d: %v", email.ID, err)
+		return
+	}
+
+	var threadIDs []string
+	for _, e := range emails {
+		if e.ThreadID != "" {
+			threadIDs = append(threadIDs, e.ThreadID)
+		}
+	}
+	if len(threadIDs) > 0 {
+		var members []models.UniboxEmail
+		if err := uc.db.Select(threadColumns).
+			Where("user_id = ? AND thread_id IN ?", email.UserID, threadIDs).
+			Find(&members).Error; err != nil {
+			uc.logger.Printf("Failed to load threads for email %d: %v", email.ID, err)
+			return
+		}
+		emails = append(emails, members...)
+	}
+
+	emails = append(emails, *email)
+	if err := uc.assignThreads(emails); err != nil {
+		uc.logger.Printf("Failed to thread email %d: %v", email.ID, err)
+		return
+	}
+
+	var threaded models.UniboxEmail
+	if err := uc.db.Select("thread_id").First(&threaded, email.ID).Error; err == nil {
+		email.ThreadID = threaded.ThreadID
+	}
+}
+
+// assignThreads threads the emails and stores each conversation's ID on the
+// emails whose thread changed
+func (uc *UniboxController) assignThreads(emails []models.UniboxEmail) error {
+	current := make(map[uint]string, len(emails))
+	messages := make([]utils.ThreadMessage, 0, len(emails))
+	for _, e := range emails {
+		if _, ok := current[e.ID]; ok {
+			continue
+		}
+		current[e.ID] = e.ThreadID
+		messages = append(messages, threadMessage(&e))
+	}
+
+	changes := make(map[string][]uint)
+	for _, root := range utils.BuildThreads(messages) {
+		threadID := utils.ThreadKey(root.RootMessageID())
+		root.Walk(func(message *utils.ThreadMessage, depth int) {
+			if current[message.ID] != threadID {
+				changes[threadID] = append(changes[threadID], message.ID)
+			}
+		})
+	}
+
+	for threadID, ids := range changes {
+		if err := uc.db.Model(&models.UniboxEmail{}).Where("id IN ?", ids).
+			Update("thread_id", threadID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func threadMessage(email *models.UniboxEmail) utils.ThreadMessage {
+	return utils.ThreadMessage{
+		ID:          email.ID,
+		MessageID:   email.MessageID,
+		References:  utils.ParseReferences(email.References, email.InReplyTo),
+		Subject:     email.Subject,
+		Participant: emailParticipant(email),
+		Date:        email.Date,
+	}
+}
+
+// emailParticipant is the other party of an email: whoever sent a received
+// email, or the first recipient of a sent one
+func emailParticipant(email *models.UniboxEmail) string {
+	if email.IsSent {
+		return firstAddress(email.To)
+	}
+	return firstAddress(email.From)
+}
+
+// firstAddress pulls the first address out of a formatted address list
+func firstAddress(list string) string {
+	if start := strings.Index(list, "<"); start >= 0 {
+		if end := strings.Index(list[start:], ">"); end > 0 {
+			return strings.ToLower(list[start+1 : start+end])
+		}
+	}
+	first, _, _ := strings.Cut(list, ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
+
+// threadSummary describes a conversation in the thread list
+type threadSummary struct {
+	ThreadID      string              `json:"thread_id"`
+	Subject       string              `json:"subject"`
+	MessageCount  int64               `json:"message_count"`
+	UnreadCount   int64               `json:"unread_count"`
+	Participants  []string            `json:"participants"`
+	LastMessageAt time.Time           `json:"last_message_at"`
+	LastMessage   *models.UniboxEmail `json:"last_message"`
+}
+
+// GetThreads returns the user's conversations, most recent first, with their
+// participants, unread count and last message. ?folder= limits the list to
+// conversations with a message in that folder.
+func (uc *UniboxController) GetThreads(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	search := c.Query("search")
+
+	query := uc.db.Model(&models.UniboxEmail{}).
+		Where("user_id = ? AND thread_id <> ''", user.ID)
+
+	if folderName := c.Query("folder"); folderName != "" {
+		var folder models.UniboxFolder
+		if err := uc.db.Where("user_id = ? AND name = ?", user.ID, folderName).First(&folder).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Folder not found",
+			})
+		}
+		query = query.Where("thread_id IN (?)", uc.db.Model(&models.UniboxEmail{}).
+			Select("unibox_emails.thread_id").
+			Joins("JOIN unibox_email_folders ON unibox_email_folders.email_id = unibox_emails.id AND unibox_email_folders.deleted_at IS NULL").
+			Where("unibox_email_folders.folder_id = ? AND unibox_emails.user_id = ?", folder.ID, user.ID))
+	}
+
+	if search != "" {
+		query = query.Where("thread_id IN (?)", uc.db.Model(&models.UniboxEmail{}).
+			Select("thread_id").
+			Where("user_id = ? AND (subject LIKE ? OR body LIKE ?)", user.ID, "%"+search+"%", "%"+search+"%"))
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Distinct("thread_id").Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to count threads",
+		})
+	}
+
+	var rows []struct {
+		ThreadID      string
+		MessageCount  int64
+		UnreadCount   int64
+		LastMessageAt time.Time
+		Participants  string
+	}
+	if err := query.Select(`thread_id,
+			COUNT(*) AS message_count,
+			COUNT(*) FILTER (WHERE NOT is_read) AS unread_count,
+			MAX(date) AS last_message_at,
+			STRING_AGG(DISTINCT "from", E'\n') AS participants`).
+		Group("thread_id").
+		Order("last_message_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch threads",
+		})
+	}
+
+	threadIDs := make([]string, len(rows))
+	for i, row := range rows {
+		threadIDs[i] = row.ThreadID
+	}
+
+	var lastMessages []models.UniboxEmail
+	if len(threadIDs) > 0 {
+		if err := uc.db.Select("DISTINCT ON (thread_id) *").
+			Where("user_id = ? AND thread_id IN ?", user.ID, threadIDs).
+			Order("thread_id, date DESC").
+			Preload("Sender").
+			Preload("Files").
+			Find(&lastMessages).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch threads",
+			})
+		}
+	}
+	lastByThread := make(map[string]*models.UniboxEmail, len(lastMessages))
+	for i := range lastMessages {
+		resolveInlineImages(&lastMessages[i])
+		lastByThread[lastMessages[i].ThreadID] = &lastMessages[i]
+	}
+
+	threads := make([]threadSummary, 0, len(rows))
+	for _, row := range rows {
+		participants := strings.Split(row.Participants, "\n")
+		for i, participant := range participants {
+			participants[i] = displayAddresses(participant)
+		}
+		summary := threadSummary{
+			ThreadID:      row.ThreadID,
+			MessageCount:  row.MessageCount,
+			UnreadCount:   row.UnreadCount,
+			Participants:  participants,
+			LastMessageAt: row.LastMessageAt,
+			LastMessage:   lastByThread[row.ThreadID],
+		}
+		if summary.LastMessage != nil {
+			summary.Subject = summary.LastMessage.Subject
+		}
+		threads = append(threads, summary)
+	}
+
+	return c.JSON(fiber.Map{
+		"data":  threads,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// threadEntry is a message of a conversation with its reply depth
+type threadEntry struct {
+	models.UniboxEmail
+	Depth int `json:"depth"`
+}
+
+// GetThread returns the messages of a conversation in reply order
+func (uc *UniboxController) GetThread(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	threadID := c.Params("threadId")
+
+	var emails []models.UniboxEmail
+	if err := uc.db.Where("user_id = ? AND thread_id = ?", user.ID, threadID).
+		Preload("Sender").
+		Preload("Files").
+		Order("date").
+		Find(&emails).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch thread",
+		})
+	}
+	if len(emails) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Thread not found",
+		})
+	}
+
+	byID := make(map[uint]*models.UniboxEmail, len(emails))
+	messages := make([]utils.ThreadMessage, len(emails))
+	for i := range emails {
+		resolveInlineImages(&emails[i])
+		byID[emails[i].ID] = &emails[i]
+		messages[i] = threadMessage(&emails[i])
+	}
+
+	entries := make([]threadEntry, 0, len(emails))
+	for _, root := range utils.BuildThreads(messages) {
+		root.Walk(func(message *utils.ThreadMessage, depth int) {
+			entries = append(entries, threadEntry{UniboxEmail: *byID[message.ID], Depth: depth})
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"thread_id": threadID,
+		"subject":   emails[0].Subject,
+		"messages":  entries,
+	})
+}
+
+// RebuildThreads threads all of the user's emails again, for mail stored
+// before threading or after threading rules change
+func (uc *UniboxController) RebuildThreads(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var emails []models.UniboxEmail
+	if err := uc.db.Select(threadColumns).Where("user_id = ?", user.ID).Find(&emails).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch emails",
+		})
+	}
+
+	// Older emails have no subject key yet
+	for _, email := range emails {
+		if email.ThreadSubject == utils.ThreadSubject(email.Subject) {
+			continue
+		}
+		if err := uc.db.Model(&models.UniboxEmail{}).Where("id = ?", email.ID).
+			Update("thread_subject", utils.ThreadSubject(email.Subject)).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to rebuild threads",
+			})
+		}
+	}
+
+	if err := uc.assignThreads(emails); err != nil {
+		uc.logger.Printf("Failed to rebuild threads for user %d: %v", user.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rebuild threads",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Threads rebuilt successfully",
+		"emails":  len(emails),
+	})
+}
@@ -27,8 +27,22 @@ func main() {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Create Fiber app
-	app := fiber.New()
+	// Load the offline GeoIP database used to enrich tracking events
+	if err := utils.InitGeoIP(config.AppConfig.GeoIPDBPath); err != nil {
+		logger.Printf("GeoIP enrichment disabled: %v", err)
+	}
+
+	// Attachments and sent message archives are kept outside the database
+	blobStore, err := utils.NewBlobStore(config.AppConfig.BlobStore)
+	if err != nil {
+		logger.Fatalf("Failed to configure blob store: %v", err)
+	}
+	utils.InitBlobStore(blobStore)
+
+	// Create Fiber app; the body limit leaves room for attachment uploads
+	app := fiber.New(fiber.Config{
+		BodyLimit: 16 << 20,
+	})
 
 	// Add CORS middleware
 	app.Use(middleware.CORS())
@@ -42,10 +56,31 @@ func main() {
 	defer cancel()
 	go warmupWorker.Start(ctx)
 
-	// Add this after the warmup worker initialization
-	uniboxWorker := worker.NewUniboxWorker(config.DB, log.New(os.Stdout, "UNIBOX: ", log.LstdFlags))
+	// IMAP mailboxes are watched with IDLE; the Unibox worker polls the rest
+	idleManager := worker.NewIMAPIdleManager(config.DB, config.AppConfig.IMAPIdleConnections, log.New(os.Stdout, "IDLE: ", log.LstdFlags))
+	go idleManager.Start(ctx)
+	uniboxWorker := worker.NewUniboxWorker(config.DB, idleManager, log.New(os.Stdout, "UNIBOX: ", log.LstdFlags))
 	go uniboxWorker.Start(ctx)
 
+	// Tracking hits are buffered and applied in batches off the request path
+	if err := utils.InitTrackingQueue(config.AppConfig.Redis); err != nil {
+		logger.Printf("Redis tracking queue unavailable, using in-process buffer: %v", err)
+	}
+	trackingWorker := worker.NewTrackingWorker(config.DB, utils.GetTrackingQueue(), log.New(os.Stdout, "TRACKING: ", log.LstdFlags))
+	go trackingWorker.Start(ctx)
+
+	// Sent message archives are removed once they pass the retention period
+	archiveWorker := worker.NewArchiveWorker(config.DB, config.AppConfig.ArchiveRetentionDays, log.New(os.Stdout, "ARCHIVE: ", log.LstdFlags))
+	go archiveWorker.Start(ctx)
+
+	// Start the inbound SMTP receiver for VERP bounces
+	bounceReceiver := worker.NewBounceReceiver(config.DB, config.AppConfig.BounceSMTPAddr, config.AppConfig.VERPDomain, log.New(os.Stdout, "BOUNCE: ", log.LstdFlags))
+	go bounceReceiver.Start(ctx)
+
+	// Senders and campaigns that hurt their reputation are paused automatically
+	reputationWorker := worker.NewReputationWorker(config.DB, config.AppConfig.Reputation, log.New(os.Stdout, "REPUTATION: ", log.LstdFlags))
+	go reputationWorker.Start(ctx)
+
 	// Setup routes
 	routes.SetupRoutes(app, config.DB)
 
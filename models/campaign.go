@@ -24,12 +24,15 @@ type Campaign struct {
 	ScheduledAt *time.Time `json:"scheduled_at"`
 	StartedAt   *time.Time `json:"started_at"`
 	CompletedAt *time.Time `json:"completed_at"`
+	PauseReason string     `json:"pause_reason"` // set when the reputation circuit breaker paused the campaign
 
 	// Tracking settings
 	TrackOpens      bool `gorm:"default:true" json:"track_opens"`
 	TrackClicks     bool `gorm:"default:true" json:"track_clicks"`
 	TrackReplies    bool `gorm:"default:true" json:"track_replies"`
 	UnsubscribeLink bool `gorm:"default:true" json:"unsubscribe_link"`
+	// Hold a lead's next step until after the return date in their out-of-office reply
+	RescheduleOutOfOffice bool `gorm:"default:true" json:"reschedule_out_of_office"`
 
 	// Statistics (denormalized for performance)
 	TotalRecipients  int `gorm:"default:0" json:"total_recipients"`
@@ -125,17 +128,38 @@ type CampaignActivity struct {
 	ClickedAt      *time.Time `json:"clicked_at"`
 	ClickCount     int        `gorm:"default:0" json:"click_count"`
 	RepliedAt      *time.Time `json:"replied_at"`
+	AutoRepliedAt  *time.Time `json:"auto_replied_at"` // Auto-reply or out-of-office, not counted as a reply
 	BouncedAt      *time.Time `json:"bounced_at"`
 	BounceType     string     `json:"bounce_type"` // hard, soft, block, etc.
 	UnsubscribedAt *time.Time `json:"unsubscribed_at"`
+	ComplainedAt   *time.Time `json:"complained_at"` // Spam complaint via feedback loop
+
+	// Sends the server deferred with a 4xx reply are tried again later
+	RetryCount  int        `gorm:"default:0" json:"retry_count"`
+	NextRetryAt *time.Time `gorm:"index" json:"next_retry_at"`
+	LastError   string     `json:"last_error"`
 
 	// Device and location info
-	IPAddress  string `json:"ip_address"`
-	UserAgent  string `json:"user_agent"`
-	Location   string `json:"location"`
-	DeviceType string `json:"device_type"` // desktop, mobile, tablet
-	SenderID   uint   `gorm:"not null;index" json:"sender_id"`
-	MessageID  string `json:"message_id"`
+	IPAddress   string `json:"ip_address"`
+	UserAgent   string `json:"user_agent"`
+	Location    string `json:"location"`
+	Country     string `gorm:"size:2;index" json:"country"` // ISO 3166-1 alpha-2
+	Region      string `json:"region"`
+	City        string `json:"city"`
+	EmailClient string `gorm:"index" json:"email_client"` // Gmail, Outlook, Apple Mail, etc.
+	DeviceType  string `json:"device_type"`               // desktop, mobile, tablet
+	SenderID    uint   `gorm:"not null;index" json:"sender_id"`
+	MessageID   string `gorm:"index" json:"message_id"`
+
+	// Message-ID header of the sent email, used to match replies and bounces
+	InternetMessageID string `gorm:"index" json:"internet_message_id"`
+
+	// Local part token of the VERP return path the email was sent with
+	VERPToken string `gorm:"index" json:"-"`
+
+	// Compressed copy of the MIME exactly as sent, kept in the blob store
+	ArchiveKey  string `gorm:"index" json:"-"`
+	MessageSize int    `json:"message_size"`
 
 	// Relations
 	Campaign    Campaign     `json:"-"`
@@ -165,6 +189,13 @@ type NodeData struct {
 	Body       string `json:"body,omitempty"`
 	TemplateID *uint  `json:"template_id,omitempty"`
 
+	// Email node envelope and attachments
+	ReplyTo       string            `json:"reply_to,omitempty"`
+	CC            []string          `json:"cc,omitempty"`
+	BCC           []string          `json:"bcc,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"` // custom X- headers
+	AttachmentIDs []uint            `json:"attachment_ids,omitempty"`
+
 	// Condition node fields
 	OpenedEmailEnabled     bool   `json:"openedEmailEnabled,omitempty"`
 	ClickedLinkEnabled     bool   `json:"clickedLinkEnabled,omitempty"`
@@ -195,6 +226,21 @@ type CampaignLeadList struct {
 }
 
 
+// CampaignAttachment is a file uploaded for a campaign's email nodes. The
+// content lives in the blob store under StorageKey.
+type CampaignAttachment struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index" json:"user_id"`
+	CampaignID  uint   `gorm:"not null;index" json:"campaign_id"`
+	Filename    string `gorm:"not null" json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	StorageKey  string `gorm:"not null" json:"-"`
+	Inline      bool   `gorm:"default:false" json:"inline"` // embedded image referenced from the HTML body
+	ContentID   string `json:"content_id,omitempty"`         // referenced as cid:<content_id>
+}
+
+
 // In your models package
 type CampaignSender struct {
     gorm.Model
@@ -48,9 +48,10 @@ type Lead struct {
 	IsDoNotContact bool `gorm:"default:false" json:"is_do_not_contact"`
 
 	// Metadata
-	Source      string     `json:"source"`
-	LastContact *time.Time `json:"last_contact"`
-	UserID      uint       `gorm:"index" json:"user_id"`
+	Source       string     `json:"source"`
+	LastContact  *time.Time `json:"last_contact"`
+	SnoozedUntil *time.Time `json:"snoozed_until"` // No campaign steps until then, set from out-of-office replies
+	UserID       uint       `gorm:"index" json:"user_id"`
 
 	// Relations
 	LeadListMemberships []LeadListMembership `gorm:"foreignKey:LeadID" json:"lists,omitempty"`
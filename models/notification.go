@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification tells a user about something the platform did on its own,
+// such as pausing a sender whose bounce rate got too high
+type Notification struct {
+	gorm.Model
+	UserID uint `gorm:"not null;index" json:"user_id"`
+
+	Type    string `gorm:"not null;index" json:"type"` // sender_paused, sender_resumed, campaign_paused
+	Title   string `gorm:"not null" json:"title"`
+	Message string `gorm:"type:text" json:"message"`
+
+	SenderID   *uint      `json:"sender_id,omitempty"`
+	CampaignID *uint      `json:"campaign_id,omitempty"`
+	ReadAt     *time.Time `json:"read_at"`
+}
@@ -15,6 +15,7 @@ type Sender struct {
 	Name      string `gorm:"not null" json:"name"`
 	FromEmail string `gorm:"not null" json:"from_email"`
 	FromName  string `gorm:"not null" json:"from_name"`
+	Signature string `gorm:"type:text" json:"signature"` // HTML, available to templates as {{signature}}
 
 	// Connection Type
 	ProviderType string `gorm:"not null" json:"provider_type"` // smtp, gmail, outlook, yahoo, etc.
@@ -39,6 +40,7 @@ type Sender struct {
 	OAuthToken        string    `gorm:"column:oauth_token" json:"-"`                 // Encrypted
 	OAuthRefreshToken string    `gorm:"column:oauth_refresh_token" json:"-"`         // Encrypted
 	OAuthExpiry       time.Time `gorm:"column:oauth_expiry" json:"oauth_expiry"`
+	NeedsReconnect    bool      `gorm:"default:false" json:"needs_reconnect"` // Set when the provider revokes the grant
 
 	// ========= Warmup Configuration =========
 	IsWarmingUp       bool       `gorm:"default:false" json:"is_warming_up"`
@@ -54,17 +56,35 @@ type Sender struct {
 	CustomTrackingDomain string `json:"custom_tracking_domain"`
 
 	// ========= Email Authentication =========
-	DKIMPrivateKey string `json:"dkim_private_key"` // Encrypted in application layer
+	DKIMPrivateKey string `json:"-"` // Encrypted in application layer
 	DKIMSelector   string `json:"dkim_selector"`
 	DMARCPolicy    string `json:"dmarc_policy"`
 	SPFRecord      string `json:"spf_record"`
 
+	// Send over SMTP with a VERP return path on the bounce domain, so every
+	// bounce is tied to its email. Only for senders whose server lets the
+	// envelope sender differ from the mailbox address.
+	UseVERP bool `gorm:"default:false" json:"use_verp"`
+
 	// ========= Status & Verification =========
+	IsActive     bool       `gorm:"default:true" json:"is_active"` // Inactive senders are left out of campaign rotation
 	SMTPVerified bool       `json:"smtp_verified" gorm:"default:false"`
 	IMAPVerified bool       `json:"imap_verified" gorm:"default:false"`
 	LastTestedAt *time.Time `json:"last_tested_at"`
 	LastError    *string    `json:"last_error"`
 
+	// Set by the reputation circuit breaker. A paused sender is left out of
+	// rotation until the user resumes it or PausedUntil passes; rates are
+	// measured again from ResumedAt.
+	PausedAt    *time.Time `json:"paused_at"`
+	PausedUntil *time.Time `json:"paused_until"`
+	PauseReason string     `json:"pause_reason"`
+	ResumedAt   *time.Time `json:"resumed_at"`
+
+	// Sends in a row that failed before reaching the recipient's server,
+	// such as connection, authentication or MAIL FROM errors
+	ConsecutiveFailures int `gorm:"default:0" json:"consecutive_failures"`
+
 	// ========= Usage Metrics =========
 	DailyLimit int     `gorm:"default:500" json:"daily_limit"`
 	SentToday  int     `gorm:"default:0" json:"sent_today"`
@@ -74,6 +94,10 @@ type Sender struct {
 	ClickRate  float64 `gorm:"default:0" json:"click_rate"`
 	BounceRate float64 `gorm:"default:0" json:"bounce_rate"`
 
+	// Rolling rates over the reputation window, as percentages
+	ComplaintRate float64 `gorm:"default:0" json:"complaint_rate"`
+	DeferralRate  float64 `gorm:"default:0" json:"deferral_rate"`
+
 	// Relations
 	WarmupSchedules []WarmupSchedule `gorm:"foreignKey:SenderID" json:"warmup_schedules,omitempty"`
 	// Campaigns       []Campaign       `gorm:"foreignKey:SenderID" json:"campaigns,omitempty"`
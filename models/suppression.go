@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+// Suppression blocks an address or a whole domain from ever being mailed by an account
+type Suppression struct {
+	gorm.Model
+	UserID uint `gorm:"not null;uniqueIndex:idx_suppressions_user_value" json:"user_id"`
+
+	Type   string `gorm:"not null" json:"type"`                                          // email, domain
+	Value  string `gorm:"not null;uniqueIndex:idx_suppressions_user_value" json:"value"` // Lower-cased address or domain
+	Source string `gorm:"not null;index" json:"source"`                                  // manual, import, bounce, unsubscribe, complaint
+	Reason string `json:"reason"`
+
+	CampaignID *uint `json:"campaign_id,omitempty"`
+
+	// Relations
+	Campaign *Campaign `json:"campaign,omitempty"`
+}
@@ -12,9 +12,11 @@ type UniboxEmail struct {
 	UserID      uint      `gorm:"not null;index" json:"user_id"`
 	SenderID    uint      `gorm:"not null;index" json:"sender_id"`
 	MessageID   string    `gorm:"not null;index" json:"message_id"`
-	ThreadID    string    `gorm:"index" json:"thread_id"`
+	ThreadID    string    `gorm:"index" json:"thread_id"` // shared by the messages of a conversation
 	From        string    `gorm:"not null" json:"from"`
 	To          string    `gorm:"not null" json:"to"`
+	CC          string    `json:"cc"`
+	ReplyTo     string    `json:"reply_to"` // where replies go when it differs from From
 	Subject     string    `json:"subject"`
 	Body        string    `gorm:"type:text" json:"body"`
 	BodyHTML    string    `gorm:"type:text" json:"body_html"`
@@ -27,10 +29,38 @@ type UniboxEmail struct {
 	InReplyTo   string    `json:"in_reply_to"`
 	References  string    `json:"references"`
 	Size        int       `json:"size"`
+	IsAutoReply bool      `gorm:"default:false" json:"is_auto_reply"`
+	IsSent      bool      `gorm:"default:false" json:"is_sent"` // sent from the sender's mailbox rather than received
+
+	// IMAP mailbox and UID the message was fetched from
+	Mailbox string `json:"mailbox"`
+	UID     uint32 `json:"uid"`
+
+	// Subject without reply and forward prefixes, for threading by subject
+	ThreadSubject string `gorm:"index" json:"-"`
 
 	// Relations
-	User   User   `json:"-"`
-	Sender Sender `json:"sender"`
+	User   User               `json:"-"`
+	Sender Sender             `json:"sender"`
+	Files  []UniboxAttachment `gorm:"foreignKey:EmailID" json:"files,omitempty"` // stored attachments and inline images
+}
+
+// UniboxAttachment is an attachment or inline image of a Unibox email. The
+// content is kept in the blob store under its checksum, so a file that
+// arrives in several emails is stored once per user. Content over the user's
+// storage quota is not kept; the attachment is still listed with Stored false.
+type UniboxAttachment struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index" json:"user_id"`
+	EmailID     uint   `gorm:"not null;index" json:"email_id"`
+	Filename    string `gorm:"not null" json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Checksum    string `gorm:"index" json:"checksum"` // SHA-256 of the content, hex encoded
+	StorageKey  string `json:"-"`
+	Stored      bool   `gorm:"default:false" json:"stored"`
+	Inline      bool   `gorm:"default:false" json:"inline"` // shown in the body rather than attached
+	ContentID   string `json:"content_id,omitempty"`        // referenced from the HTML body as cid:<content_id>
 }
 
 
@@ -49,6 +79,24 @@ type UniboxFolder struct {
 
 
 
+// MailboxSyncState remembers how far a sender's IMAP mailbox has been
+// fetched. UIDs are only meaningful for one UIDVALIDITY; when the server
+// reports another, the mailbox is fetched again from the start. Mailboxes
+// read through the Gmail API or Microsoft Graph are tracked by received
+// time instead.
+type MailboxSyncState struct {
+	gorm.Model
+	SenderID       uint       `gorm:"not null;uniqueIndex:idx_mailbox_sync_sender_mailbox" json:"sender_id"`
+	Mailbox        string     `gorm:"not null;uniqueIndex:idx_mailbox_sync_sender_mailbox" json:"mailbox"`
+	UIDValidity    uint32     `json:"uid_validity"`
+	LastUID        uint32     `json:"last_uid"`         // highest UID stored so far
+	LastReceivedAt *time.Time `json:"last_received_at"` // received time up to which API mail is stored
+	LastSyncedAt   *time.Time `json:"last_synced_at"`
+
+	// Relations
+	Sender Sender `json:"-"`
+}
+
 // UniboxEmailFolder joins emails to folders
 type UniboxEmailFolder struct {
 	gorm.Model
@@ -50,6 +50,9 @@ type User struct {
 	ResetToken          *string `gorm:"size:255"`
 	ResetTokenExpiresAt *time.Time
 
+	// Unibox attachment storage, in megabytes; 0 uses the default quota
+	StorageQuotaMB int `gorm:"default:0" json:"storage_quota_mb"`
+
 	// Relations
 	Senders            []Sender            `gorm:"foreignKey:UserID" json:"senders,omitempty"`
 	Campaigns          []Campaign          `gorm:"foreignKey:UserID" json:"campaigns,omitempty"`
@@ -69,6 +69,8 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	leadController := controller.NewLeadController(db, log.New(os.Stdout, "LEAD: ", log.LstdFlags))
 	dashboardController := controller.NewDashboardController(db, log.New(os.Stdout, "DASHBOARD: ", log.LstdFlags))
 	uniboxController := controller.NewUniboxController(db, log.New(os.Stdout, "UNIBOX: ", log.LstdFlags))
+	suppressionController := controller.NewSuppressionController(db, log.New(os.Stdout, "SUPPRESSION: ", log.LstdFlags))
+	notificationController := controller.NewNotificationController(db, log.New(os.Stdout, "NOTIFICATION: ", log.LstdFlags))
 
 	// API group with versioning and protection
 	api := app.Group("/api/v1", middleware.Protected(), logger.New(logger.Config{
@@ -90,6 +92,8 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	sender.Delete("/:id", controller.DeleteSender)
 	sender.Post("/:id/test", controller.TestSender)
 	sender.Post("/:id/verify", controller.VerifySender)
+	sender.Post("/:id/dkim/check", controller.CheckSenderDKIM)
+	sender.Post("/:id/resume", controller.ResumeSender)
 
 	// Warmup routes
 	warmup := sender.Group("/:id/warmup")
@@ -113,9 +117,15 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	campaign := api.Group("/campaigns")
 	campaign.Post("/", campaignController.CreateCampaign)
 	campaign.Get("/", campaignController.GetCampaigns)
+	campaign.Post("/spintax-preview", campaignController.PreviewSpintax)
+	campaign.Post("/lint", campaignController.LintContent)
 	campaign.Get("/:id", campaignController.GetCampaign)
 	campaign.Put("/:id", campaignController.UpdateCampaign)
 	campaign.Post("/:id/start", campaignController.StartCampaign)
+	campaign.Get("/:id/personalization-check", campaignController.CheckCampaignPersonalization)
+	campaign.Get("/:id/lint", campaignController.LintCampaign)
+	campaign.Post("/:id/preview", campaignController.PreviewCampaignEmail)
+	campaign.Post("/:id/preview/send", campaignController.SendTestEmail)
 	campaign.Post("/:id/stop", campaignController.StopCampaign)
 	campaign.Get("/:id/flow", campaignController.GetCampaignFlow)
 	campaign.Put("/:id/flow", campaignController.UpdateCampaignFlow)
@@ -127,6 +137,10 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	// routes.go - Add these to your existing routes
 	campaign.Put("/:id/settings", campaignController.UpdateCampaignSettings)
 	campaign.Get("/:id/tracking-stats", campaignController.GetTrackingStats)
+	campaign.Get("/:id/activities/:activityId/message", campaignController.GetSentMessage)
+	campaign.Get("/:id/attachments", campaignController.GetCampaignAttachments)
+	campaign.Post("/:id/attachments", campaignController.UploadCampaignAttachment)
+	campaign.Delete("/:id/attachments/:attachmentId", campaignController.DeleteCampaignAttachment)
 
 	// WebSocket route for campaign progress
 	app.Get("/api/v1/campaigns/progress", websocket.New(func(c *websocket.Conn) {
@@ -134,6 +148,8 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	}))
 	app.Get("/track/open/:messageID/:token", campaignController.HandleOpenTracking)
 	app.Get("/track/click/:messageID/:token", campaignController.HandleClickTracking)
+	app.Get("/unsubscribe/:messageID/:token", campaignController.HandleUnsubscribePage)
+	app.Post("/unsubscribe/:messageID/:token", campaignController.HandleUnsubscribe)
 
 	// Lead routes
 	lead := api.Group("/leads")
@@ -155,6 +171,20 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	leadList.Post("/:id/add-leads", leadController.AddLeadsToList)
 	leadList.Post("/:id/remove-leads", leadController.RemoveLeadsFromList)
 	leadList.Get("/:id/leads", leadController.GetLeadListMembers)
+
+	// Suppression list routes
+	suppression := api.Group("/suppressions")
+	suppression.Get("/", suppressionController.GetSuppressions)
+	suppression.Post("/", suppressionController.CreateSuppressions)
+	suppression.Post("/import", suppressionController.ImportSuppressions)
+	suppression.Get("/export", suppressionController.ExportSuppressions)
+	suppression.Delete("/:id", suppressionController.DeleteSuppression)
+
+	// Notification routes
+	notification := api.Group("/notifications")
+	notification.Get("/", notificationController.GetNotifications)
+	notification.Put("/read-all", notificationController.MarkAllNotificationsRead)
+	notification.Put("/:id/read", notificationController.MarkNotificationRead)
 	
 
 	// Start the sender counter reset goroutine
@@ -169,6 +199,16 @@ func SetupAPIRoutes(app *fiber.App, db *gorm.DB) {
 	unibox.Get("/emails/:id", uniboxController.GetEmail)
 	unibox.Put("/emails/:id", uniboxController.UpdateEmail)
 	unibox.Put("/emails/:id/move", uniboxController.MoveEmail)
+	unibox.Post("/emails/:id/reply", uniboxController.ReplyEmail)
+	unibox.Post("/emails/:id/reply-all", uniboxController.ReplyAllEmail)
+	unibox.Post("/emails/:id/forward", uniboxController.ForwardEmail)
+	unibox.Get("/emails/:id/attachments", uniboxController.GetEmailAttachments)
+	unibox.Get("/attachments/:id", uniboxController.DownloadAttachment)
+	unibox.Get("/storage", uniboxController.GetStorageUsage)
+	unibox.Post("/compose", uniboxController.ComposeEmail)
+	unibox.Get("/threads", uniboxController.GetThreads)
+	unibox.Post("/threads/rebuild", uniboxController.RebuildThreads)
+	unibox.Get("/threads/:threadId", uniboxController.GetThread)
 	unibox.Get("/folders", uniboxController.GetFolders)
 	unibox.Post("/folders", uniboxController.CreateFolder)
 	unibox.Delete("/folders/:id", uniboxController.DeleteFolder)
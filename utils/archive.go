@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// ArchiveMessage stores the gzip compressed MIME of a sent message and
+// returns its blob key
+func ArchiveMessage(userID, campaignID uint, messageID string, raw []byte) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("archive/%d/%d/%s.eml.gz", userID, campaignID, messageID)
+	if err := GetBlobStore().Put(key, buf.Bytes(), "application/gzip"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// LoadArchivedMessage returns the raw MIME of an archived message
+func LoadArchivedMessage(key string) ([]byte, error) {
+	data, err := GetBlobStore().Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("archive %s is corrupt: %v", key, err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// maxReturnDateAhead bounds how far out an out-of-office return date may be
+const maxReturnDateAhead = 120 * 24 * time.Hour
+
+// AutoReply describes a message that was sent by a machine in response to
+// an email rather than written by the recipient
+type AutoReply struct {
+	IsAutoReply bool
+	OutOfOffice bool
+	Reason      string     // the header or phrase that gave it away
+	ReturnDate  *time.Time // when an out-of-office recipient is back, if mentioned
+}
+
+var (
+	autoReplySubjectRe = regexp.MustCompile(`(?i)^\s*(auto(matic)?[ -]?(reply|response|antwort|svar)|auto:|autoreply|abwesenheit|automatische antwort|réponse automatique|respuesta automática|risposta automatica|resposta automática|automatisch antwoord|odpowiedź automatyczna|automaattinen vastaus|out of (the )?office\b|ooo\b|absence:|absent:|fuera de la oficina)`)
+
+	outOfOfficeRe = regexp.MustCompile(`(?i)(out of (the )?office|\booo\b|on (annual |parental |maternity |sick )?leave|on (vacation|holiday)|away from (the|my) (office|desk)|limited access to (my )?e-?mail|nicht im büro|abwesend|abwesenheitsnotiz|im urlaub|absente?\b|en congés?|en vacances|fuera de la oficina|de vacaciones|ausente|fuori (ufficio|sede)|in ferie|fora do escritório|de férias|afwezig|met vakantie|frånvarande|på semester|poza biurem|na urlopie)`)
+
+	quotedReplyRe = regexp.MustCompile(`(?im)^(on .{0,200} wrote:|am .{0,200} schrieb|le .{0,200} a écrit|el .{0,200} escribió|-{2,} ?original message ?-{2,}|from: .+)$`)
+
+	numericDateRe = regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b|\b(\d{1,2})[./](\d{1,2})(?:[./](\d{2,4}))?\b`)
+	dayMonthRe    = regexp.MustCompile(`(?i)\b(\d{1,2})(?:st|nd|rd|th|er|e|º)?\.?\s+(?:(?:de|of)\s+)?([a-zäçéèûóąćęłńśźż]{3,12})\.?(?:,?\s+(?:de\s+)?(\d{4}))?`)
+	monthDayRe    = regexp.MustCompile(`(?i)([a-zäçéèûóąćęłńśźż]{3,12})\.?\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4}))?`)
+)
+
+// monthNames maps month names and common abbreviations in the languages
+// out-of-office notices are usually written in
+var monthNames = map[string]time.Month{}
+
+// ambiguousMonths are abbreviations that are also everyday English words
+// ("I'm out 2 days"); they only count in a full date such as "14 set 2026"
+var ambiguousMonths = map[string]bool{"out": true, "set": true, "ago": true}
+
+func init() {
+	for month, names := range map[time.Month][]string{
+		time.January:   {"january", "jan", "januar", "janvier", "janv", "enero", "ene", "gennaio", "gen", "janeiro", "januari", "stycznia"},
+		time.February:  {"february", "feb", "februar", "février", "févr", "febrero", "febbraio", "fevereiro", "fev", "februari", "lutego"},
+		time.March:     {"march", "mar", "märz", "mars", "marzo", "março", "maart", "marca"},
+		time.April:     {"april", "apr", "avril", "avr", "abril", "abr", "aprile", "kwietnia"},
+		time.May:       {"may", "mai", "mayo", "maggio", "maio", "mei", "maja"},
+		time.June:      {"june", "jun", "juni", "juin", "junio", "giugno", "giu", "junho", "czerwca"},
+		time.July:      {"july", "jul", "juli", "juillet", "juil", "julio", "luglio", "lug", "julho", "lipca"},
+		time.August:    {"august", "aug", "août", "agosto", "ago", "augustus", "sierpnia"},
+		time.September: {"september", "sep", "sept", "septembre", "septiembre", "settembre", "set", "setembro", "września"},
+		time.October:   {"october", "oct", "oktober", "okt", "octobre", "octubre", "ottobre", "ott", "outubro", "out", "października"},
+		time.November:  {"november", "nov", "novembre", "noviembre", "novembro", "listopada"},
+		time.December:  {"december", "dec", "dezember", "dez", "décembre", "déc", "diciembre", "dic", "dicembre", "dezembro", "grudnia"},
+	} {
+		for _, name := range names {
+			monthNames[name] = month
+		}
+	}
+}
+
+// DetectAutoReply classifies an incoming message from its headers
+// (Auto-Submitted, X-Autoreply, Precedence, ...) and its subject. People
+// write "I was on vacation" too, so out-of-office phrasing in the body only
+// tells what kind of auto-reply a message is. For out-of-office notices the
+// latest date mentioned within the next months is taken as the return date.
+func DetectAutoReply(header mail.Header, body string, received time.Time) AutoReply {
+	var result AutoReply
+
+	subject, _ := header.Subject()
+	unquoted := stripQuotedReply(body)
+
+	switch {
+	case autoSubmitted(header.Get("Auto-Submitted")):
+		result.Reason = "Auto-Submitted: " + header.Get("Auto-Submitted")
+	case header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != "":
+		result.Reason = "X-Autoreply header"
+	case strings.EqualFold(header.Get("X-Autogenerated"), "reply"):
+		result.Reason = "X-Autogenerated: Reply"
+	case precedenceAuto(header.Get("Precedence")):
+		result.Reason = "Precedence: " + header.Get("Precedence")
+	case autoReplySubjectRe.MatchString(subject):
+		result.Reason = "subject: " + subject
+	default:
+		return result
+	}
+	result.IsAutoReply = true
+
+	if outOfOfficeRe.MatchString(subject) || outOfOfficeRe.MatchString(unquoted) {
+		result.OutOfOffice = true
+		result.ReturnDate = findReturnDate(unquoted, received)
+	}
+	return result
+}
+
+func autoSubmitted(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return value != "" && value != "no"
+}
+
+func precedenceAuto(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "auto_reply", "bulk", "junk":
+		return true
+	}
+	return false
+}
+
+// stripQuotedReply cuts the quoted original message off a reply
+func stripQuotedReply(body string) string {
+	if loc := quotedReplyRe.FindStringIndex(body); loc != nil {
+		body = body[:loc[0]]
+	}
+
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// findReturnDate returns the latest date in the text that falls after the
+// message was received and not too far ahead. Notices usually read "from
+// March 3 until March 14", so the latest date is the day the person is back.
+func findReturnDate(text string, received time.Time) *time.Time {
+	today := time.Date(received.Year(), received.Month(), received.Day(), 0, 0, 0, 0, received.Location())
+	var best *time.Time
+
+	consider := func(year int, month time.Month, day int) {
+		if month < time.January || month > time.December || day < 1 || day > 31 {
+			return
+		}
+		if year == 0 {
+			// Without a year the date is the next one to come
+			year = today.Year()
+			if time.Date(year, month, day, 0, 0, 0, 0, today.Location()).Before(today) {
+				year++
+			}
+		} else if year < 100 {
+			year += 2000
+		}
+
+		date := time.Date(year, month, day, 0, 0, 0, 0, today.Location())
+		if date.Day() != day || date.Before(today) || date.Sub(today) > maxReturnDateAhead {
+			return
+		}
+		if best == nil || date.After(*best) {
+			best = &date
+		}
+	}
+
+	for _, match := range numericDateRe.FindAllStringSubmatch(text, -1) {
+		if match[1] != "" {
+			consider(atoi(match[1]), time.Month(atoi(match[2])), atoi(match[3]))
+			continue
+		}
+		first, second, year := atoi(match[4]), atoi(match[5]), atoi(match[6])
+		if match[6] != "" && len(match[6]) != 2 && len(match[6]) != 4 {
+			continue
+		}
+		// Day first, as most of the world writes it, unless that can not be
+		if second > 12 && first <= 12 {
+			first, second = second, first
+		}
+		consider(year, time.Month(second), first)
+	}
+
+	for _, match := range dayMonthRe.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(match[2])
+		if ambiguousMonths[name] && match[3] == "" {
+			continue
+		}
+		if month, ok := monthNames[name]; ok {
+			consider(atoi(match[3]), month, atoi(match[1]))
+		}
+	}
+	for _, match := range monthDayRe.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(match[1])
+		if ambiguousMonths[name] {
+			continue
+		}
+		if month, ok := monthNames[name]; ok {
+			consider(atoi(match[3]), month, atoi(match[2]))
+		}
+	}
+
+	return best
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
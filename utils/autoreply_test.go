@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestFindReturnDate(t *testing.T) {
+	march := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+	december := time.Date(2026, time.December, 20, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		text     string
+		received time.Time
+		want     string // YYYY-MM-DD, or "" for no date
+	}{
+		{"range", "I am away from March 3 until March 14.", march, "2026-03-14"},
+		{"iso", "Back on 2026-03-14.", march, "2026-03-14"},
+		{"day first", "Back on 14/03.", march, "2026-03-14"},
+		{"month first", "Back on 03/14.", march, "2026-03-14"},
+		{"two digit year", "Back on 14.03.26.", march, "2026-03-14"},
+		{"ordinal", "Back on the 14th of March.", march, "2026-03-14"},
+		{"german", "Ich bin ab dem 14. März 2026 wieder im Büro.", march, "2026-03-14"},
+		{"french", "De retour le 14 mars.", march, "2026-03-14"},
+		{"spanish", "Vuelvo el 14 de marzo de 2026.", march, "2026-03-14"},
+		{"portuguese", "Volto dia 14 out 2026.", time.Date(2026, time.October, 1, 9, 0, 0, 0, time.UTC), "2026-10-14"},
+		{"portuguese in range", "De férias até 4 set 2026.", time.Date(2026, time.August, 20, 9, 0, 0, 0, time.UTC), "2026-09-04"},
+		{"out as a word", "I'm out 2 days, back soon.", march, ""},
+		{"set as a word", "The meeting is set 3 weeks out.", march, ""},
+		{"ago as a word", "I wrote you 5 ago.", march, ""},
+		{"next year", "Back January 5.", december, "2027-01-05"},
+		{"past date only", "Away since February 20.", march, ""},
+		{"too far ahead", "Back on 2026-12-01.", march, ""},
+		{"impossible day", "Back on 31/02.", march, ""},
+		{"no date", "I will answer when I am back.", march, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findReturnDate(tt.text, tt.received)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("findReturnDate(%q) = nil, want %s", tt.text, tt.want)
+			case got != nil && got.Format("2006-01-02") != tt.want:
+				t.Errorf("findReturnDate(%q) = %s, want %q", tt.text, got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectAutoReply(t *testing.T) {
+	received := time.Date(2026, time.March, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		header      map[string]string
+		body        string
+		autoReply   bool
+		outOfOffice bool
+		returnDate  string
+	}{
+		{
+			name:        "auto-submitted out of office",
+			header:      map[string]string{"Auto-Submitted": "auto-replied", "Subject": "Re: Hello"},
+			body:        "I am out of the office until March 14.",
+			autoReply:   true,
+			outOfOffice: true,
+			returnDate:  "2026-03-14",
+		},
+		{
+			name:   "auto-submitted no",
+			header: map[string]string{"Auto-Submitted": "no", "Subject": "Re: Hello"},
+			body:   "Sounds good, let's talk on Friday.",
+		},
+		{
+			name:      "x-autoreply",
+			header:    map[string]string{"X-Autoreply": "yes", "Subject": "Re: Hello"},
+			body:      "Thanks for your email, we will get back to you shortly.",
+			autoReply: true,
+		},
+		{
+			name:      "precedence",
+			header:    map[string]string{"Precedence": "auto_reply", "Subject": "Re: Hello"},
+			body:      "Thanks for your email.",
+			autoReply: true,
+		},
+		{
+			name:      "subject",
+			header:    map[string]string{"Subject": "Automatic reply: Hello"},
+			body:      "Thanks for your email.",
+			autoReply: true,
+		},
+		{
+			name:        "out of office subject",
+			header:      map[string]string{"Subject": "Out of Office: Hello"},
+			body:        "I am back on March 14.",
+			autoReply:   true,
+			outOfOffice: true,
+			returnDate:  "2026-03-14",
+		},
+		{
+			name:   "reply about out of office",
+			header: map[string]string{"Subject": "Re: Out of office next week?"},
+			body:   "I'm on vacation then, let's meet on March 20.",
+		},
+		{
+			name:        "german subject",
+			header:      map[string]string{"Subject": "Abwesenheitsnotiz: Hello"},
+			body:        "Ich bin ab dem 14. März wieder da.",
+			autoReply:   true,
+			outOfOffice: true,
+			returnDate:  "2026-03-14",
+		},
+		{
+			name:   "body only",
+			header: map[string]string{"Subject": "Re: Hello"},
+			body:   "Hi, I'm on vacation until 14/03 with limited access to email.",
+		},
+		{
+			name:   "short human reply",
+			header: map[string]string{"Subject": "Re: Hello"},
+			body:   "Sorry, was on vacation, yes let's talk",
+		},
+		{
+			name:   "long human reply",
+			header: map[string]string{"Subject": "Re: Hello"},
+			body:   "I was out of the office last week. " + strings.Repeat("Here is what I think about your proposal. ", 25),
+		},
+		{
+			name:   "quoted out of office",
+			header: map[string]string{"Subject": "Re: Automatic reply: Hello"},
+			body:   "Sounds good!\n\nOn Mon, Mar 1, 2026 at 10:00 Lead wrote:\n> I am out of the office until March 14.",
+		},
+		{
+			name:   "x-auto-response-suppress",
+			header: map[string]string{"X-Auto-Response-Suppress": "All", "Subject": "Re: Hello"},
+			body:   "Sounds good, let's talk on Friday.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header mail.Header
+			for key, value := range tt.header {
+				header.Set(key, value)
+			}
+
+			got := DetectAutoReply(header, tt.body, received)
+			if got.IsAutoReply != tt.autoReply || got.OutOfOffice != tt.outOfOffice {
+				t.Fatalf("DetectAutoReply = %+v, want auto-reply %v, out of office %v", got, tt.autoReply, tt.outOfOffice)
+			}
+			if tt.autoReply && got.Reason == "" {
+				t.Error("auto-reply without a reason")
+			}
+			var returnDate string
+			if got.ReturnDate != nil {
+				returnDate = got.ReturnDate.Format("2006-01-02")
+			}
+			if returnDate != tt.returnDate {
+				t.Errorf("ReturnDate = %q, want %q", returnDate, tt.returnDate)
+			}
+		})
+	}
+}
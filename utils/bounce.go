@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+
+	"gorm.io/gorm"
+)
+
+// BounceEvent is one failed delivery to one recipient
+type BounceEvent struct {
+	Email          string
+	Type           string // BounceHard, BounceSoft or BounceBlock
+	Code           string // enhanced status code
+	Message        string
+	DiagnosticCode string
+	At             time.Time
+}
+
+// FindBouncedActivity looks up the campaign email a bounce refers to by the
+// Message-ID of the original message. It returns nil when the bounce is for
+// mail the platform did not send.
+func FindBouncedActivity(db *gorm.DB, userID uint, messageID string) (*models.CampaignActivity, error) {
+	messageID = strings.TrimSpace(messageID)
+	if messageID == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(messageID, "<") {
+		messageID = "<" + messageID + ">"
+	}
+
+	var activity models.CampaignActivity
+	err := db.Where("user_id = ? AND internet_message_id = ?", userID, messageID).First(&activity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+// RecordBounce stores a bounce and, when it belongs to a campaign email to
+// that recipient, marks the activity bounced and counts it on the campaign.
+// Hard bounces also flag the recipient's leads and suppress the address.
+// activity may be nil for bounces that could not be matched.
+func RecordBounce(db *gorm.DB, senderID uint, activity *models.CampaignActivity, event BounceEvent) error {
+	email := strings.ToLower(strings.TrimSpace(event.Email))
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		bounce := models.Bounce{
+			Email:          email,
+			SenderID:       senderID,
+			Type:           event.Type,
+			Code:           event.Code,
+			Message:        event.Message,
+			DiagnosticCode: event.DiagnosticCode,
+		}
+		if activity != nil {
+			bounce.CampaignID = &activity.CampaignID
+		}
+		if err := tx.Create(&bounce).Error; err != nil {
+			return err
+		}
+		if activity == nil {
+			return nil
+		}
+
+		var lead models.Lead
+		if err := tx.First(&lead, activity.LeadID).Error; err != nil {
+			return err
+		}
+		// The Message-ID is shared by every recipient of the email; only a
+		// bounce for the lead itself says something about the lead
+		if strings.ToLower(lead.Email) != email {
+			return nil
+		}
+
+		updates := map[string]interface{}{"bounce_type": event.Type}
+		if activity.BouncedAt == nil {
+			updates["bounced_at"] = event.At
+		}
+		if err := tx.Model(activity).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if activity.BouncedAt == nil {
+			if err := tx.Model(&models.Campaign{}).
+				Where("id = ?", activity.CampaignID).
+				Update("bounce_count", gorm.Expr("bounce_count + 1")).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&models.LeadActivity{
+			LeadID:       lead.ID,
+			CampaignID:   &activity.CampaignID,
+			SenderID:     &activity.SenderID,
+			ActivityType: "bounced",
+			ActivityAt:   event.At,
+			Details:      strings.TrimSpace(event.Type + " " + event.Code + " " + event.DiagnosticCode),
+		}).Error; err != nil {
+			return err
+		}
+
+		if event.Type != BounceHard {
+			return nil
+		}
+
+		if err := tx.Model(&models.Lead{}).
+			Where("user_id = ? AND LOWER(email) = ?", lead.UserID, email).
+			Update("is_bounced", true).Error; err != nil {
+			return err
+		}
+
+		reason := strings.TrimSpace(event.Code + " " + event.Message)
+		return SuppressAddress(tx, activity.UserID, email, SuppressionSourceBounce, reason, &activity.CampaignID)
+	})
+}
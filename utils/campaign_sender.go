@@ -13,15 +13,33 @@ type CampaignSender struct {
 	DB     *gorm.DB
 	Logger *log.Logger
 }
+// MailServiceInterface delivers an email through the given sender's
+// mailbox and returns what was sent
 type MailServiceInterface interface {
-    Send(email Email) (string, error)
+    Send(sender *models.Sender, email Email) (*SentMessage, error)
+}
+
+// SentMessage is the Message-ID and the exact MIME handed to the server
+type SentMessage struct {
+    MessageID  string
+    Raw        []byte
+    Refused    []*SMTPRejectionError // Cc and Bcc recipients the server refused
+    ReturnPath string                // envelope sender of an SMTP send; empty for the provider APIs
 }
 
 type Email struct {
-    From    string
-    To      string
-    Subject string
-    Body    string
+    From        string
+    To          string
+    ReplyTo     string
+    CC          []string
+    BCC         []string // Delivered to but never shown to the other recipients
+    Subject     string
+    Body        string // HTML part
+    Text        string // Plain-text alternative part; generated from Body when empty
+    Headers     map[string]string
+    MessageID   string // Message-ID header; generated on the sender's domain when empty
+    ReturnPath  string // Envelope sender for SMTP delivery; the sender's address when empty
+    Attachments []Attachment
 }
 
 func NewCampaignSender(db *gorm.DB, logger *log.Logger) *CampaignSender {
@@ -31,10 +49,11 @@ func NewCampaignSender(db *gorm.DB, logger *log.Logger) *CampaignSender {
 	}
 }
 
-// RotateSender selects the next available sender for a campaign
+// RotateSender selects the next available sender for a campaign. Senders
+// paused by the reputation breaker are skipped.
 func (cs *CampaignSender) RotateSender(userID uint) (*models.Sender, error) {
 	var senders []models.Sender
-	if err := cs.DB.Where("user_id = ? AND is_active = ?", userID, true).Find(&senders).Error; err != nil {
+	if err := cs.DB.Where("user_id = ? AND is_active = ? AND needs_reconnect = ? AND paused_at IS NULL", userID, true, false).Find(&senders).Error; err != nil {
 		return nil, err
 	}
 
@@ -65,7 +84,10 @@ func (cs *CampaignSender) RotateSender(userID uint) (*models.Sender, error) {
 func (cs *CampaignSender) UpdateSenderUsage(senderID uint) error {
 	return cs.DB.Model(&models.Sender{}).
 		Where("id = ?", senderID).
-		Update("sent_today", gorm.Expr("sent_today + ?", 1)).
+		Updates(map[string]interface{}{
+			"sent_today": gorm.Expr("sent_today + ?", 1),
+			"total_sent": gorm.Expr("total_sent + ?", 1),
+		}).
 		Error
 }
 
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimHeaderKeys are the header fields covered by the signature, following
+// RFC 6376 section 5.4.1. Fields missing from a message are still listed so
+// they cannot be added after signing.
+var dkimHeaderKeys = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMCheckResult is the outcome of a signing self-check against DNS
+type DKIMCheckResult struct {
+	Domain    string `json:"domain"`
+	Selector  string `json:"selector"`
+	Record    string `json:"record"`
+	Algorithm string `json:"algorithm"`
+	Valid     bool   `json:"valid"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ParseDKIMPrivateKey accepts a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519
+// (PKCS#8) key, or a base64 encoded raw Ed25519 seed or private key
+func ParseDKIMPrivateKey(key string) (crypto.Signer, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, errors.New("DKIM private key is empty")
+	}
+
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			switch k := parsed.(type) {
+			case *rsa.PrivateKey:
+				return k, nil
+			case ed25519.PrivateKey:
+				return k, nil
+			}
+			return nil, errors.New("DKIM key must be RSA or Ed25519")
+		}
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.New("DKIM private key must be PEM or base64 encoded")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, errors.New("unrecognised DKIM private key format")
+}
+
+// HasDKIM reports whether a sender is configured to sign its mail
+func HasDKIM(sender *models.Sender) bool {
+	return sender.DKIMPrivateKey != "" && sender.DKIMSelector != ""
+}
+
+// SignDKIM prepends a DKIM-Signature header (relaxed/relaxed) to a raw message.
+// Messages from senders without a key are returned unchanged.
+func SignDKIM(sender *models.Sender, raw []byte) ([]byte, error) {
+	if !HasDKIM(sender) {
+		return raw, nil
+	}
+
+	decrypted, err := Decrypt(sender.DKIMPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt DKIM key: %v", err)
+	}
+	signer, err := ParseDKIMPrivateKey(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DKIM key: %v", err)
+	}
+
+	domain := ExtractDomain(sender.FromEmail)
+	if domain == "" {
+		return nil, errors.New("sender has no valid from domain for DKIM")
+	}
+
+	options := &dkim.SignOptions{
+		Domain:                 domain,
+		Selector:               sender.DKIMSelector,
+		Signer:                 signer,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             dkimHeaderKeys,
+	}
+
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, bytes.NewReader(raw), options); err != nil {
+		return nil, fmt.Errorf("DKIM signing failed: %v", err)
+	}
+	return signed.Bytes(), nil
+}
+
+// CheckDKIM signs a probe message with the sender's key and verifies it
+// against the public key published at <selector>._domainkey.<domain>
+func CheckDKIM(sender *models.Sender) DKIMCheckResult {
+	result := DKIMCheckResult{
+		Domain:   ExtractDomain(sender.FromEmail),
+		Selector: sender.DKIMSelector,
+	}
+	if !HasDKIM(sender) {
+		result.Error = "DKIM private key and selector are not configured"
+		return result
+	}
+
+	if decrypted, err := Decrypt(sender.DKIMPrivateKey); err == nil {
+		if signer, err := ParseDKIMPrivateKey(decrypted); err == nil {
+			switch signer.Public().(type) {
+			case *rsa.PublicKey:
+				result.Algorithm = "rsa-sha256"
+			case ed25519.PublicKey:
+				result.Algorithm = "ed25519-sha256"
+			}
+		}
+	}
+
+	records, err := net.LookupTXT(sender.DKIMSelector + "._domainkey." + result.Domain)
+	if err != nil {
+		result.Error = fmt.Sprintf("DKIM record lookup failed: %v", err)
+		return result
+	}
+	result.Record = strings.Join(records, "")
+
+	probe := fmt.Sprintf("From: <%s>\r\nTo: <%s>\r\nSubject: DKIM self-check\r\nDate: %s\r\nMessage-ID: %s\r\n\r\nDKIM self-check\r\n",
+		sender.FromEmail, sender.FromEmail, time.Now().Format(time.RFC1123Z), GenerateMessageID("dkim-check", sender.FromEmail))
+
+	signed, err := SignDKIM(sender, []byte(probe))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	verifications, err := dkim.Verify(bytes.NewReader(signed))
+	if err != nil {
+		result.Error = fmt.Sprintf("DKIM verification failed: %v", err)
+		return result
+	}
+	if len(verifications) == 0 {
+		result.Error = "no DKIM signature found"
+		return result
+	}
+	if verifications[0].Err != nil {
+		result.Error = verifications[0].Err.Error()
+		return result
+	}
+
+	result.Valid = true
+	return result
+}
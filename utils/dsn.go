@@ -0,0 +1,408 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// Bounce types
+const (
+	BounceHard  = "hard"  // the address does not exist or permanently rejects mail
+	BounceSoft  = "soft"  // temporary failure: mailbox full, server unavailable, ...
+	BounceBlock = "block" // rejected because of the sender or the content (policy, spam, blocklists)
+)
+
+// maxDSNText caps how much of a human-readable bounce is kept for heuristics
+const maxDSNText = 64 << 10
+
+// DSNRecipient is one recipient a delivery status notification reports on
+type DSNRecipient struct {
+	Email          string
+	Action         string // failed, delayed, delivered, relayed or expanded
+	Status         string // enhanced status code such as 5.1.1
+	DiagnosticCode string // the remote server's reply
+}
+
+// BounceType classifies the failure, or returns "" when the recipient did not fail
+func (r DSNRecipient) BounceType() string {
+	switch strings.ToLower(r.Action) {
+	case "delivered", "relayed", "expanded":
+		return ""
+	}
+	return ClassifyBounce(r.Status, r.DiagnosticCode)
+}
+
+// DSNReport is a parsed delivery status notification
+type DSNReport struct {
+	ReportingMTA      string
+	OriginalMessageID string // Message-ID of the email that bounced
+	Recipients        []DSNRecipient
+	Standard          bool // read from an RFC 3464 message/delivery-status part
+}
+
+var (
+	// The class must not continue a dotted number, or IP addresses would match
+	enhancedStatusRe = regexp.MustCompile(`(?:^|[^\d.])([245])\.(\d{1,3})\.(\d{1,3})(?:[^\d.]|$)`)
+	basicStatusRe    = regexp.MustCompile(`\b([45][0-5]\d)[ -][A-Za-z#<(]`)
+	replyCodeRe      = regexp.MustCompile(`^\s*([245])\d\d\b`)
+	messageIDLineRe  = regexp.MustCompile(`(?im)^[ \t>]*Message-ID:\s*(<[^>\s]+>)`)
+
+	// Recipient patterns used by MTAs that do not send RFC 3464 reports:
+	// Exim and Sendmail quote the RCPT command, qmail starts a line with <address>:
+	rcptToRe      = regexp.MustCompile(`(?i)RCPT TO:?\s*<([^>\s]+@[^>\s]+)>`)
+	qmailRcptRe   = regexp.MustCompile(`(?m)^<([^>\s]+@[^>\s]+)>:`)
+	anyAddressRe  = regexp.MustCompile(`[A-Za-z0-9._%+'-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	blockReasonRe = regexp.MustCompile(`(?i)\b(blocked|block ?list(ed)?|black ?list(ed)?|deny ?list(ed)?|spamhaus|spamcop|barracuda|rbl|dnsbl|reputation|spam|policy|banned|access denied)\b`)
+	mailboxFullRe = regexp.MustCompile(`(?i)(mailbox (is )?full|over ?quota|quota exceeded|insufficient (system )?storage)`)
+)
+
+// daemonLocalParts are the From addresses bounce messages are sent from
+var daemonLocalParts = []string{"mailer-daemon", "mailerdaemon", "postmaster", "mail-delivery-subsystem"}
+
+// ParseDSN reads a delivery status notification. Standard RFC 3464 reports
+// (multipart/report with a message/delivery-status part) are parsed field by
+// field; for the free-form bounces some servers still send, the failed
+// recipients and status are picked out of the text when the message comes
+// from a mailer daemon, names X-Failed-Recipients or is a multipart/report.
+// The subject alone is not enough, as people reply to emails about delivery
+// failures too. It reports false for messages that are not bounces, and for
+// messages sent by one of the recipients they report on.
+func ParseDSN(raw []byte) (*DSNReport, bool) {
+	entity, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, false
+	}
+	header := mail.Header{Header: entity.Header}
+
+	report := &DSNReport{}
+	var text strings.Builder
+
+	entity.Walk(func(path []int, part *message.Entity, err error) error {
+		if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+			return nil
+		}
+		mediaType, _, _ := part.Header.ContentType()
+		switch strings.ToLower(mediaType) {
+		case "message/delivery-status", "message/global-delivery-status":
+			report.Standard = true
+			parseDeliveryStatus(part.Body, report)
+		case "message/rfc822", "message/global", "text/rfc822-headers", "message/rfc822-headers":
+			if report.OriginalMessageID == "" {
+				report.OriginalMessageID = originalMessageID(part.Body)
+			}
+		case "text/plain", "":
+			if text.Len() < maxDSNText {
+				body, _ := io.ReadAll(io.LimitReader(part.Body, int64(maxDSNText-text.Len())))
+				text.Write(body)
+				text.WriteString("\n")
+			}
+		}
+		return nil
+	})
+
+	if !report.Standard {
+		if !looksLikeBounce(header) {
+			return nil, false
+		}
+		parseFreeFormBounce(text.String(), header, report)
+	}
+
+	if report.OriginalMessageID == "" {
+		report.OriginalMessageID = messageIDFromText(text.String(), header.Get("Message-Id"))
+	}
+
+	if len(report.Recipients) == 0 {
+		return nil, false
+	}
+	// A recipient writing back is a reply, whatever it looks like
+	if from, err := header.AddressList("From"); err == nil && len(from) > 0 {
+		for _, recipient := range report.Recipients {
+			if strings.EqualFold(recipient.Email, from[0].Address) {
+				return nil, false
+			}
+		}
+	}
+	return report, true
+}
+
+// parseDeliveryStatus reads the blocks of a message/delivery-status body:
+// one with per-message fields followed by one per recipient
+func parseDeliveryStatus(body io.Reader, report *DSNReport) {
+	reader := textproto.NewReader(bufio.NewReader(body))
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 {
+			if mta := fields.Get("Reporting-Mta"); mta != "" {
+				report.ReportingMTA = dsnValue(mta)
+			}
+
+			recipient := fields.Get("Final-Recipient")
+			if recipient == "" {
+				recipient = fields.Get("Original-Recipient")
+			}
+			if recipient != "" {
+				status := fields.Get("Status")
+				if code, _ := findStatus(status); code != "" {
+					status = code
+				}
+				report.Recipients = append(report.Recipients, DSNRecipient{
+					Email:          strings.ToLower(strings.Trim(dsnValue(recipient), "<>")),
+					Action:         strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+					Status:         status,
+					DiagnosticCode: dsnValue(fields.Get("Diagnostic-Code")),
+				})
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dsnValue strips the type prefix of a typed DSN field ("rfc822; a@b.com")
+func dsnValue(value string) string {
+	if _, rest, ok := strings.Cut(value, ";"); ok {
+		value = rest
+	}
+	return strings.TrimSpace(value)
+}
+
+// originalMessageID reads the Message-ID from the returned copy or headers
+// of the bounced message
+func originalMessageID(body io.Reader) string {
+	reader := textproto.NewReader(bufio.NewReader(body))
+	headers, _ := reader.ReadMIMEHeader()
+	return strings.TrimSpace(headers.Get("Message-Id"))
+}
+
+// messageIDFromText finds a quoted Message-ID header in a bounce's text,
+// skipping the bounce's own ID
+func messageIDFromText(text, own string) string {
+	for _, match := range messageIDLineRe.FindAllStringSubmatch(text, -1) {
+		if match[1] != strings.TrimSpace(own) {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// looksLikeBounce tells from the headers whether a message that has no
+// machine-readable delivery status was sent by a mail system
+func looksLikeBounce(header mail.Header) bool {
+	if header.Get("X-Failed-Recipients") != "" {
+		return true
+	}
+	if mediaType, _, err := header.ContentType(); err == nil && strings.EqualFold(mediaType, "multipart/report") {
+		return true
+	}
+	if from, err := header.AddressList("From"); err == nil && len(from) > 0 {
+		if isDaemonLocalPart(strings.ToLower(strings.SplitN(from[0].Address, "@", 2)[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFreeFormBounce picks the failed recipients and the status out of a
+// human-readable bounce
+func parseFreeFormBounce(text string, header mail.Header, report *DSNReport) {
+	var recipients []string
+	seen := map[string]bool{}
+	add := func(address string) {
+		address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+		if address != "" && strings.Contains(address, "@") && !seen[address] {
+			seen[address] = true
+			recipients = append(recipients, address)
+		}
+	}
+
+	// Exim names the failed recipients in a header
+	for _, address := range strings.Split(header.Get("X-Failed-Recipients"), ",") {
+		add(address)
+	}
+	for _, re := range []*regexp.Regexp{rcptToRe, qmailRcptRe} {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			add(match[1])
+		}
+	}
+
+	// Last resort: the first address in the notice that is neither the
+	// daemon nor the mailbox the bounce was delivered to
+	if len(recipients) == 0 {
+		skip := map[string]bool{}
+		for _, field := range []string{"From", "To", "Return-Path"} {
+			if list, err := header.AddressList(field); err == nil {
+				for _, address := range list {
+					skip[strings.ToLower(address.Address)] = true
+				}
+			}
+		}
+		for _, address := range anyAddressRe.FindAllString(noticeText(text), -1) {
+			address = strings.ToLower(address)
+			local := strings.SplitN(address, "@", 2)[0]
+			if skip[address] || isDaemonLocalPart(local) {
+				continue
+			}
+			add(address)
+			break
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	status, diagnostic := freeFormStatus(text)
+	for _, address := range recipients {
+		report.Recipients = append(report.Recipients, DSNRecipient{
+			Email:          address,
+			Action:         "failed",
+			Status:         status,
+			DiagnosticCode: diagnostic,
+		})
+	}
+	if strings.HasPrefix(status, "4.") {
+		for i := range report.Recipients {
+			report.Recipients[i].Action = "delayed"
+		}
+	}
+}
+
+// noticeText cuts a bounce before the returned copy of the original message,
+// whose headers are full of unrelated addresses
+func noticeText(text string) string {
+	lower := strings.ToLower(text)
+	cut := len(text)
+	for _, marker := range []string{"original message", "copy of the message", "message headers follow", "returned message", "\nreceived:", "\nreturn-path:"} {
+		if i := strings.Index(lower, marker); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+	return text[:cut]
+}
+
+// freeFormStatus finds the enhanced status code in a bounce's text, falling
+// back to the basic SMTP reply code. The line it was found on is returned as
+// the diagnostic. A bounce without any code counts as temporary, so an
+// address is never given up on a guess.
+func freeFormStatus(text string) (string, string) {
+	if code, pos := findStatus(text); code != "" {
+		return code, lineAround(text, pos)
+	}
+	if match := basicStatusRe.FindStringSubmatchIndex(text); match != nil {
+		code := text[match[2]:match[3]]
+		return code[:1] + ".0.0", lineAround(text, match[2])
+	}
+	return "4.0.0", ""
+}
+
+// findStatus returns the first enhanced status code in a text and its offset
+func findStatus(text string) (string, int) {
+	match := enhancedStatusRe.FindStringSubmatchIndex(text)
+	if match == nil {
+		return "", -1
+	}
+	return text[match[2]:match[7]], match[2]
+}
+
+func lineAround(text string, pos int) string {
+	start := strings.LastIndexByte(text[:pos], '\n') + 1
+	end := strings.IndexByte(text[pos:], '\n')
+	if end < 0 {
+		end = len(text) - pos
+	}
+	line := strings.TrimSpace(text[start : pos+end])
+	if len(line) > 500 {
+		line = line[:500]
+	}
+	return line
+}
+
+func isDaemonLocalPart(local string) bool {
+	for _, daemon := range daemonLocalParts {
+		if local == daemon {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyBounce sorts a failed delivery into hard, soft or block from its
+// enhanced status code and the server's diagnostic text. Success codes
+// return "", and a failure without any code is soft.
+func ClassifyBounce(status, diagnostic string) string {
+	class, subject, detail := "", "", ""
+	match := enhancedStatusRe.FindStringSubmatch(status)
+	if match == nil {
+		match = enhancedStatusRe.FindStringSubmatch(diagnostic)
+	}
+	if match != nil {
+		class, subject, detail = match[1], match[2], match[3]
+	} else if code := replyCodeRe.FindStringSubmatch(diagnostic); code != nil {
+		class = code[1]
+	} else if strings.HasPrefix(strings.TrimSpace(status), "5") {
+		class = "5"
+	} else {
+		class = "4"
+	}
+
+	switch {
+	case class == "2":
+		return ""
+	case subject == "7" || blockReasonRe.MatchString(diagnostic):
+		return BounceBlock
+	case class == "4":
+		return BounceSoft
+	case subject == "2" && detail == "2", subject == "3" && detail == "4", mailboxFullRe.MatchString(diagnostic):
+		// Mailbox full or message too big: the address itself is fine
+		return BounceSoft
+	}
+	return BounceHard
+}
+
+// DescribeBounceStatus gives a short explanation of an enhanced status code
+func DescribeBounceStatus(status string) string {
+	match := enhancedStatusRe.FindStringSubmatch(status)
+	if match == nil {
+		return "Delivery failed"
+	}
+	switch match[2] + "." + match[3] {
+	case "1.1":
+		return "Mailbox does not exist"
+	case "1.2":
+		return "Destination domain does not exist"
+	case "2.1":
+		return "Mailbox disabled"
+	case "2.2":
+		return "Mailbox full"
+	case "3.4":
+		return "Message too big"
+	case "4.7":
+		return "Delivery time expired"
+	case "7.1":
+		return "Rejected by recipient's policy"
+	}
+	switch match[2] {
+	case "1":
+		return "Bad recipient address"
+	case "2":
+		return "Mailbox unavailable"
+	case "3":
+		return "Recipient mail system problem"
+	case "4":
+		return "Network or routing problem"
+	case "5":
+		return "Mail delivery protocol problem"
+	case "6":
+		return "Message content rejected"
+	case "7":
+		return "Rejected for security or policy reasons"
+	}
+	return "Delivery failed"
+}
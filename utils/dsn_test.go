@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// crlf turns a test message written with \n line endings into wire format
+func crlf(s string) []byte {
+	return []byte(strings.ReplaceAll(s, "\n", "\r\n"))
+}
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		standard   bool
+		messageID  string
+		recipients []DSNRecipient
+	}{
+		{
+			name: "rfc 3464 report",
+			raw: `From: Mail Delivery System <MAILER-DAEMON@mx.example.net>
+Subject: Undelivered Mail Returned to Sender
+Message-ID: <bounce-1@mx.example.net>
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B"
+
+--B
+Content-Type: text/plain
+
+Your message could not be delivered.
+--B
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+
+Final-Recipient: rfc822; <Lead@Example.org>
+Action: failed
+Status: 5.1.1 (user unknown)
+Diagnostic-Code: smtp; 550 5.1.1 <lead@example.org>: User unknown
+
+Final-Recipient: rfc822; other@example.org
+Action: delayed
+Status: 4.2.2
+Diagnostic-Code: smtp; 452 4.2.2 Mailbox full
+--B
+Content-Type: text/rfc822-headers
+
+Message-ID: <original@mailnexy.test>
+Subject: Hello
+--B--
+`,
+			standard:  true,
+			messageID: "<original@mailnexy.test>",
+			recipients: []DSNRecipient{
+				{Email: "lead@example.org", Action: "failed", Status: "5.1.1", DiagnosticCode: "550 5.1.1 <lead@example.org>: User unknown"},
+				{Email: "other@example.org", Action: "delayed", Status: "4.2.2", DiagnosticCode: "452 4.2.2 Mailbox full"},
+			},
+		},
+		{
+			name: "exim",
+			raw: `From: Mail Delivery System <Mailer-Daemon@mx.example.net>
+To: sender@mailnexy.test
+Subject: Mail delivery failed: returning message to sender
+X-Failed-Recipients: lead@example.org
+
+This message was created automatically by mail delivery software.
+
+A message that you sent could not be delivered to one or more of its
+recipients. This is a permanent error. The following address(es) failed:
+
+  lead@example.org
+    SMTP error from remote mail server after RCPT TO:<lead@example.org>:
+    550 5.1.1 No such user here
+
+------ This is a copy of the message, including all the headers. ------
+
+Message-ID: <original@mailnexy.test>
+`,
+			messageID: "<original@mailnexy.test>",
+			recipients: []DSNRecipient{
+				{Email: "lead@example.org", Action: "failed", Status: "5.1.1", DiagnosticCode: "550 5.1.1 No such user here"},
+			},
+		},
+		{
+			name: "qmail",
+			raw: `From: MAILER-DAEMON@mx.example.net
+To: sender@mailnexy.test
+Subject: failure notice
+
+Hi. This is the qmail-send program at mx.example.net.
+I'm afraid I wasn't able to deliver your message to the following addresses.
+
+<lead@example.org>:
+192.0.2.10 does not like recipient.
+Remote host said: 550 No such mailbox
+`,
+			recipients: []DSNRecipient{
+				{Email: "lead@example.org", Action: "failed", Status: "5.0.0", DiagnosticCode: "Remote host said: 550 No such mailbox"},
+			},
+		},
+		{
+			name: "delay notice without a code",
+			raw: `From: postmaster@mx.example.net
+To: sender@mailnexy.test
+Subject: Delivery Status Notification (Delay)
+
+Delivery to lead@example.org is delayed. The server will retry for 2 days.
+`,
+			recipients: []DSNRecipient{
+				{Email: "lead@example.org", Action: "delayed", Status: "4.0.0"},
+			},
+		},
+		{
+			name: "failure notice without a code",
+			raw: `From: Mail Delivery Subsystem <mailer-daemon@mx.example.net>
+To: sender@mailnexy.test
+Subject: Returned mail
+
+Your message to lead@example.org could not be delivered.
+`,
+			recipients: []DSNRecipient{
+				{Email: "lead@example.org", Action: "delayed", Status: "4.0.0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, ok := ParseDSN(crlf(tt.raw))
+			if !ok {
+				t.Fatal("not parsed as a bounce")
+			}
+			if report.Standard != tt.standard {
+				t.Errorf("Standard = %v, want %v", report.Standard, tt.standard)
+			}
+			if report.OriginalMessageID != tt.messageID {
+				t.Errorf("OriginalMessageID = %q, want %q", report.OriginalMessageID, tt.messageID)
+			}
+			if len(report.Recipients) != len(tt.recipients) {
+				t.Fatalf("Recipients = %+v, want %+v", report.Recipients, tt.recipients)
+			}
+			for i, want := range tt.recipients {
+				if got := report.Recipients[i]; got != want {
+					t.Errorf("Recipients[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDSNIgnoresOtherMail(t *testing.T) {
+	for name, raw := range map[string]string{
+		"reply": `From: Lead <lead@example.org>
+Subject: Re: Hello
+
+Thanks, lead@example.org is the right address. 550 people read it.
+`,
+		"reply with a bounce subject": `From: Lead <lead@example.org>
+To: sender@mailnexy.test
+Subject: Re: Delivery failure rates in your warehouse
+In-Reply-To: <original@mailnexy.test>
+
+Our delivery failure rate is 5.1.1 percent, write to ops@example.org.
+`,
+		"bounce subject from a person": `From: ops@example.org
+To: sender@mailnexy.test
+Subject: Undeliverable: Hello
+
+RCPT TO:<lead@example.org> failed with 550 5.1.1.
+`,
+		"from a reported recipient": `From: lead@example.org
+To: sender@mailnexy.test
+Subject: Re: Hello
+X-Failed-Recipients: lead@example.org
+
+550 5.1.1 just kidding
+`,
+		"report without recipients": `From: MAILER-DAEMON@mx.example.net
+Subject: Undelivered Mail Returned to Sender
+Content-Type: multipart/report; report-type=delivery-status; boundary="B"
+
+--B
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+--B--
+`,
+	} {
+		if report, ok := ParseDSN(crlf(raw)); ok {
+			t.Errorf("%s: parsed as a bounce: %+v", name, report)
+		}
+	}
+}
+
+func TestClassifyBounce(t *testing.T) {
+	tests := []struct {
+		status     string
+		diagnostic string
+		want       string
+	}{
+		{"5.1.1", "550 5.1.1 User unknown", BounceHard},
+		{"5.1.2", "", BounceHard},
+		{"5.7.1", "550 5.7.1 Message rejected", BounceBlock},
+		{"5.0.0", "554 Your IP is listed on Spamhaus", BounceBlock},
+		{"5.1.1", "550 5.1.1 Blocked by policy", BounceBlock},
+		{"4.2.2", "452 4.2.2 Mailbox full", BounceSoft},
+		{"4.4.7", "", BounceSoft},
+		{"5.2.2", "", BounceSoft},
+		{"5.3.4", "552 5.3.4 Message too big", BounceSoft},
+		{"5.0.0", "552 Mailbox is full", BounceSoft},
+		{"2.0.0", "250 OK", ""},
+		{"", "smtp; 550 5.1.1 User unknown", BounceHard},
+		{"", "421 Try again later", BounceSoft},
+		{"4", "", BounceSoft},
+		{"", "550 Rejected by 10.5.1.1", BounceHard},
+		{"5", "", BounceHard},
+		{"", "User unknown", BounceSoft},
+		{"", "", BounceSoft},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyBounce(tt.status, tt.diagnostic); got != tt.want {
+			t.Errorf("ClassifyBounce(%q, %q) = %q, want %q", tt.status, tt.diagnostic, got, tt.want)
+		}
+	}
+}
+
+func TestDSNRecipientBounceType(t *testing.T) {
+	delivered := DSNRecipient{Action: "delivered", Status: "5.1.1"}
+	if got := delivered.BounceType(); got != "" {
+		t.Errorf("delivered recipient classified as %q", got)
+	}
+	failed := DSNRecipient{Action: "failed", Status: "5.1.1"}
+	if got := failed.BounceType(); got != BounceHard {
+		t.Errorf("failed recipient classified as %q, want %q", got, BounceHard)
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoLocation is the result of an offline IP lookup
+type GeoLocation struct {
+	Country     string `json:"country"`
+	CountryCode string `json:"country_code"`
+	Region      string `json:"region"`
+	City        string `json:"city"`
+}
+
+var (
+	geoIPReader *geoip2.Reader
+	geoIPMu     sync.RWMutex
+)
+
+// InitGeoIP opens a MaxMind-format (GeoLite2/GeoIP2 City or Country) database.
+// Lookups return empty results until a database has been loaded, so
+// enrichment is simply skipped when no path is configured.
+func InitGeoIP(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+
+	geoIPMu.Lock()
+	defer geoIPMu.Unlock()
+	if geoIPReader != nil {
+		geoIPReader.Close()
+	}
+	geoIPReader = reader
+	return nil
+}
+
+// LookupLocation resolves an IP address against the local GeoIP database
+func LookupLocation(ipAddress string) GeoLocation {
+	var location GeoLocation
+
+	ip := net.ParseIP(strings.TrimSpace(ipAddress))
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() {
+		return location
+	}
+
+	geoIPMu.RLock()
+	defer geoIPMu.RUnlock()
+	if geoIPReader == nil {
+		return location
+	}
+
+	// City databases are a superset of country databases; fall back for the latter
+	if record, err := geoIPReader.City(ip); err == nil {
+		location.Country = record.Country.Names["en"]
+		location.CountryCode = record.Country.IsoCode
+		if len(record.Subdivisions) > 0 {
+			location.Region = record.Subdivisions[0].Names["en"]
+		}
+		location.City = record.City.Names["en"]
+		return location
+	}
+
+	if record, err := geoIPReader.Country(ip); err == nil {
+		location.Country = record.Country.Names["en"]
+		location.CountryCode = record.Country.IsoCode
+	}
+	return location
+}
+
+// String formats the location as "City, Region, Country", skipping empty parts
+func (l GeoLocation) String() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{l.City, l.Region, l.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// EngagementEnrichment returns the activity columns derived from a tracking
+// hit's IP address and user agent. Hits fetched through a provider's image
+// proxy keep the client but carry no usable location.
+func EngagementEnrichment(ipAddress, userAgent string) map[string]interface{} {
+	agent := ParseUserAgent(userAgent)
+	updates := map[string]interface{}{
+		"ip_address":   ipAddress,
+		"user_agent":   userAgent,
+		"email_client": agent.Client,
+		"device_type":  agent.DeviceType,
+	}
+
+	if agent.Proxied {
+		return updates
+	}
+
+	location := LookupLocation(ipAddress)
+	if location.CountryCode != "" {
+		updates["country"] = location.CountryCode
+		updates["region"] = location.Region
+		updates["city"] = location.City
+		updates["location"] = location.String()
+	}
+	return updates
+}
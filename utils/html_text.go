@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	inlineSpacePattern = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLinesPattern  = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText renders an HTML body as readable plain text for the text/plain
+// alternative part: block elements become line breaks, list items get a
+// bullet and links keep their destination after the link text
+func HTMLToText(htmlContent string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(htmlContent))
+
+	skipDepth := 0
+	var hrefs []string
+	var linkText []int // length of b when each open <a> started
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		switch tt {
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			b.WriteString(inlineSpacePattern.ReplaceAllString(strings.ReplaceAll(string(z.Text()), "\n", " "), " "))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Script, atom.Style, atom.Head, atom.Title:
+				if tt == html.StartTagToken {
+					skipDepth++
+				}
+			case atom.Br:
+				b.WriteString("\n")
+			case atom.Hr:
+				b.WriteString("\n----------\n")
+			case atom.Li:
+				b.WriteString("\n- ")
+			case atom.Img:
+				if alt := attrValue(tok, "alt"); alt != "" {
+					b.WriteString(alt)
+				}
+			case atom.A:
+				hrefs = append(hrefs, attrValue(tok, "href"))
+				linkText = append(linkText, b.Len())
+			default:
+				if isBlockElement(tok.DataAtom) {
+					b.WriteString("\n\n")
+				}
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Script, atom.Style, atom.Head, atom.Title:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case atom.A:
+				if len(hrefs) == 0 {
+					continue
+				}
+				href := hrefs[len(hrefs)-1]
+				start := linkText[len(linkText)-1]
+				hrefs, linkText = hrefs[:len(hrefs)-1], linkText[:len(linkText)-1]
+
+				text := strings.TrimSpace(b.String()[start:])
+				if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(strings.ToLower(href), "mailto:") && text != href {
+					b.WriteString(" (" + href + ")")
+				}
+			case atom.Td, atom.Th:
+				b.WriteString(" ")
+			default:
+				if isBlockElement(tok.DataAtom) {
+					b.WriteString("\n\n")
+				}
+			}
+		}
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text := blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
+
+func attrValue(tok html.Token, key string) string {
+	for _, attr := range tok.Attr {
+		if attr.Key == key {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+func isBlockElement(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Table, atom.Tr, atom.Ul, atom.Ol, atom.Blockquote,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Pre,
+		atom.Section, atom.Article, atom.Header, atom.Footer, atom.Body:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// Attachment is a file carried by an outgoing email. Attachments with a
+// ContentID are inline parts the HTML body references as cid:<ContentID>.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	ContentID   string
+}
+
+// Inline reports whether the attachment is an inline part of the HTML body
+func (a Attachment) Inline() bool {
+	return a.ContentID != ""
+}
+
+// mimePart is a node of the MIME tree; parts with children are multipart
+type mimePart struct {
+	header   message.Header
+	body     []byte
+	children []*mimePart
+}
+
+// BuildMIMEMessage renders an email as RFC 5322 message. The body is laid out
+//
+//	multipart/mixed                  (only with regular attachments)
+//	  multipart/related              (only with inline attachments)
+//	    multipart/alternative
+//	      text/plain
+//	      text/html
+//	    inline images
+//	  attachments
+//
+// with the layers that have nothing to wrap left out. A missing plain-text
+// part is generated from the HTML. Bcc is only written when includeBcc is
+// set: SMTP delivers to Bcc recipients through the envelope, while the
+// provider APIs read them from the header and strip it before delivery.
+func BuildMIMEMessage(sender *models.Sender, email Email, messageID string, includeBcc bool) ([]byte, error) {
+	var h mail.Header
+	h.SetAddressList("From", []*mail.Address{{Name: sender.FromName, Address: sender.FromEmail}})
+	h.SetAddressList("To", parseAddresses([]string{email.To}))
+	if len(email.CC) > 0 {
+		h.SetAddressList("Cc", parseAddresses(email.CC))
+	}
+	if includeBcc && len(email.BCC) > 0 {
+		h.SetAddressList("Bcc", parseAddresses(email.BCC))
+	}
+	if email.ReplyTo != "" {
+		h.SetAddressList("Reply-To", parseAddresses([]string{email.ReplyTo}))
+	}
+	h.SetSubject(email.Subject)
+	h.SetDate(time.Now())
+	h.Set("Message-ID", messageID)
+	h.Set("MIME-Version", "1.0")
+
+	for key, value := range email.Headers {
+		h.Set(key, value)
+	}
+
+	root := buildMIMEBody(email)
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := root.header.Get(key); value != "" {
+			h.Set(key, value)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := message.CreateWriter(&buf, h.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMIMEPart(w, root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func buildMIMEBody(email Email) *mimePart {
+	text := email.Text
+	if text == "" && email.Body != "" {
+		text = HTMLToText(email.Body)
+	}
+
+	var body *mimePart
+	switch {
+	case email.Body != "" && text != "":
+		body = multipartNode("multipart/alternative",
+			textNode("text/plain", text),
+			textNode("text/html", email.Body))
+	case email.Body != "":
+		body = textNode("text/html", email.Body)
+	default:
+		body = textNode("text/plain", text)
+	}
+
+	var inline, attached []*mimePart
+	for _, attachment := range email.Attachments {
+		if attachment.Inline() && email.Body != "" {
+			inline = append(inline, attachmentNode(attachment))
+		} else {
+			attached = append(attached, attachmentNode(attachment))
+		}
+	}
+
+	if len(inline) > 0 {
+		body = multipartNode("multipart/related", append([]*mimePart{body}, inline...)...)
+	}
+	if len(attached) > 0 {
+		body = multipartNode("multipart/mixed", append([]*mimePart{body}, attached...)...)
+	}
+	return body
+}
+
+func textNode(contentType, content string) *mimePart {
+	var h message.Header
+	h.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	return &mimePart{header: h, body: []byte(content)}
+}
+
+func multipartNode(contentType string, children ...*mimePart) *mimePart {
+	var h message.Header
+	h.SetContentType(contentType, nil)
+	return &mimePart{header: h, children: children}
+}
+
+func attachmentNode(a Attachment) *mimePart {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var h message.Header
+	h.SetContentType(contentType, map[string]string{"name": a.Filename})
+	h.Set("Content-Transfer-Encoding", "base64")
+	if a.Inline() {
+		h.SetContentDisposition("inline", map[string]string{"filename": a.Filename})
+		h.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	} else {
+		h.SetContentDisposition("attachment", map[string]string{"filename": a.Filename})
+	}
+	return &mimePart{header: h, body: a.Data}
+}
+
+func writeMIMEPart(w *message.Writer, part *mimePart) error {
+	if len(part.children) == 0 {
+		if _, err := w.Write(part.body); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+
+	for _, child := range part.children {
+		cw, err := w.CreatePart(child.header)
+		if err != nil {
+			return err
+		}
+		if err := writeMIMEPart(cw, child); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// parseAddresses accepts bare addresses as well as "Name <address>" forms,
+// and comma-separated lists of them
+func parseAddresses(values []string) []*mail.Address {
+	addresses := make([]*mail.Address, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if addr, err := mail.ParseAddress(value); err == nil {
+			addresses = append(addresses, addr)
+		} else if list, err := mail.ParseAddressList(value); err == nil {
+			addresses = append(addresses, list...)
+		} else {
+			addresses = append(addresses, &mail.Address{Address: value})
+		}
+	}
+	return addresses
+}
+
+// envelopeRecipients returns the bare addresses of every recipient of an
+// email for the SMTP RCPT TO commands: the To addresses the message must
+// reach, and the Cc and Bcc copies it is still sent without
+func envelopeRecipients(email Email) (to, copies []string) {
+	seen := make(map[string]bool)
+	add := func(list []string, values []string) []string {
+		for _, addr := range parseAddresses(values) {
+			key := strings.ToLower(addr.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			list = append(list, addr.Address)
+		}
+		return list
+	}
+
+	to = add(to, []string{email.To})
+	copies = add(copies, append(append([]string(nil), email.CC...), email.BCC...))
+	return to, copies
+}
+
+// DetectContentType returns the MIME type for a file name, falling back to
+// the type sniffed by the caller
+func DetectContentType(filename, sniffed string) string {
+	if i := strings.LastIndex(filename, "."); i >= 0 {
+		if byExt := mime.TypeByExtension(strings.ToLower(filename[i:])); byExt != "" {
+			return byExt
+		}
+	}
+	if sniffed == "" {
+		return "application/octet-stream"
+	}
+	return sniffed
+}
+
+// ValidateCustomHeader checks a user supplied header. Only X- headers may
+// be set so structural and authentication headers stay under our control.
+func ValidateCustomHeader(key, value string) error {
+	if !strings.HasPrefix(strings.ToLower(key), "x-") || len(key) < 3 {
+		return fmt.Errorf("header %q is not allowed, only X- headers can be set", key)
+	}
+	for _, r := range key {
+		if r <= ' ' || r >= 0x7f || r == ':' {
+			return fmt.Errorf("header name %q is invalid", key)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %q contains a line break", key)
+	}
+	return nil
+}
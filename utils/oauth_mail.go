@@ -0,0 +1,430 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mailnexy/config"
+	"mailnexy/models"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// OAuth mail providers
+const (
+	OAuthProviderGoogle    = "google"
+	OAuthProviderMicrosoft = "microsoft"
+)
+
+const (
+	gmailAPIBase = "https://gmail.googleapis.com/gmail/v1/users/me"
+	graphAPIBase = "https://graph.microsoft.com/v1.0/me"
+
+	// Access tokens are refreshed this long before they expire so a request
+	// never starts with a token that lapses mid-flight
+	oauthRefreshLeeway = 2 * time.Minute
+	oauthHTTPTimeout   = 60 * time.Second
+)
+
+// ErrSenderNeedsReconnect is returned when the provider has revoked the
+// sender's refresh token and the user has to connect the mailbox again
+var ErrSenderNeedsReconnect = errors.New("sender authorization was revoked, reconnect the mailbox")
+
+// OAuthMailProvider returns the normalised OAuth provider a sender sends and
+// reads through, or "" for senders using SMTP/IMAP passwords
+func OAuthMailProvider(sender *models.Sender) string {
+	if sender.OAuthRefreshToken == "" {
+		return ""
+	}
+	switch strings.ToLower(sender.OAuthProvider) {
+	case "google", "gmail":
+		return OAuthProviderGoogle
+	case "microsoft", "outlook", "office365":
+		return OAuthProviderMicrosoft
+	}
+	return ""
+}
+
+func senderOAuthConfig(provider string) (*oauth2.Config, error) {
+	switch provider {
+	case OAuthProviderGoogle:
+		return &oauth2.Config{
+			ClientID:     config.AppConfig.Google.ClientID,
+			ClientSecret: config.AppConfig.Google.ClientSecret,
+			RedirectURL:  config.AppConfig.Google.RedirectURI,
+			Scopes: []string{
+				"https://www.googleapis.com/auth/gmail.send",
+				"https://www.googleapis.com/auth/gmail.readonly",
+			},
+			Endpoint: google.Endpoint,
+		}, nil
+	case OAuthProviderMicrosoft:
+		return &oauth2.Config{
+			ClientID:     config.AppConfig.Microsoft.ClientID,
+			ClientSecret: config.AppConfig.Microsoft.ClientSecret,
+			RedirectURL:  config.AppConfig.Microsoft.RedirectURI,
+			Scopes:       []string{"offline_access", "Mail.Send", "Mail.Read"},
+			Endpoint:     microsoft.AzureADEndpoint("common"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported OAuth provider: %s", provider)
+}
+
+// senderTokenSource refreshes a sender's access token ahead of expiry and
+// writes refreshed tokens back to the database encrypted
+type senderTokenSource struct {
+	senderID uint
+	base     oauth2.TokenSource
+
+	mu      sync.Mutex
+	current string
+}
+
+func (s *senderTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		if isRevokedGrant(err) {
+			markSenderNeedsReconnect(s.senderID, err)
+			return nil, ErrSenderNeedsReconnect
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.current {
+		if err := saveSenderToken(s.senderID, token); err != nil {
+			return nil, fmt.Errorf("failed to store refreshed token: %v", err)
+		}
+		s.current = token.AccessToken
+	}
+	return token, nil
+}
+
+// SenderHTTPClient returns an HTTP client authorised as the sender's mailbox
+func SenderHTTPClient(sender *models.Sender) (*http.Client, error) {
+	if sender.NeedsReconnect {
+		return nil, ErrSenderNeedsReconnect
+	}
+
+	provider := OAuthMailProvider(sender)
+	cfg, err := senderOAuthConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := Decrypt(sender.OAuthToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt OAuth token: %v", err)
+	}
+	refreshToken, err := Decrypt(sender.OAuthRefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt OAuth refresh token: %v", err)
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       sender.OAuthExpiry,
+		TokenType:    "Bearer",
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: oauthHTTPTimeout})
+	source := &senderTokenSource{
+		senderID: sender.ID,
+		base:     oauth2.ReuseTokenSourceWithExpiry(token, cfg.TokenSource(ctx, token), oauthRefreshLeeway),
+		current:  accessToken,
+	}
+
+	client := oauth2.NewClient(ctx, source)
+	client.Timeout = oauthHTTPTimeout
+	return client, nil
+}
+
+func saveSenderToken(senderID uint, token *oauth2.Token) error {
+	encryptedAccess, err := Encrypt(token.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"oauth_token":  encryptedAccess,
+		"oauth_expiry": token.Expiry,
+	}
+
+	// Providers may rotate the refresh token on use
+	if token.RefreshToken != "" {
+		encryptedRefresh, err := Encrypt(token.RefreshToken)
+		if err != nil {
+			return err
+		}
+		updates["oauth_refresh_token"] = encryptedRefresh
+	}
+
+	return config.DB.Model(&models.Sender{}).Where("id = ?", senderID).Updates(updates).Error
+}
+
+func markSenderNeedsReconnect(senderID uint, cause error) {
+	message := "OAuth authorization revoked: " + cause.Error()
+	config.DB.Model(&models.Sender{}).
+		Where("id = ?", senderID).
+		Updates(map[string]interface{}{
+			"needs_reconnect": true,
+			"last_error":      message,
+		})
+}
+
+// isRevokedGrant reports whether a token refresh failed because the grant
+// itself is no longer valid, as opposed to a transient provider error
+func isRevokedGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return false
+	}
+	if retrieveErr.ErrorCode == "invalid_grant" || retrieveErr.ErrorCode == "unauthorized_client" {
+		return true
+	}
+	return strings.Contains(string(retrieveErr.Body), "invalid_grant")
+}
+
+// sendViaAPI submits a fully rendered MIME message through the provider's API
+func sendViaAPI(sender *models.Sender, raw []byte) error {
+	client, err := SenderHTTPClient(sender)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	switch OAuthMailProvider(sender) {
+	case OAuthProviderGoogle:
+		payload, _ := json.Marshal(map[string]string{"raw": base64.RawURLEncoding.EncodeToString(raw)})
+		req, err = http.NewRequest(http.MethodPost, gmailAPIBase+"/messages/send", bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case OAuthProviderMicrosoft:
+		// Graph accepts a base64 encoded MIME message on sendMail
+		req, err = http.NewRequest(http.MethodPost, graphAPIBase+"/sendMail", strings.NewReader(base64.StdEncoding.EncodeToString(raw)))
+		if err == nil {
+			req.Header.Set("Content-Type", "text/plain")
+		}
+	default:
+		return fmt.Errorf("sender %d is not connected through OAuth", sender.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return unwrapOAuthError(err)
+	}
+	defer resp.Body.Close()
+	return checkAPIResponse(resp)
+}
+
+// apiMessage is a message listed by a mail API, before its content is
+// fetched. Gmail only tells when a message was received along with its content.
+type apiMessage struct {
+	id       string
+	received time.Time
+}
+
+// FetchOAuthMessages hands the raw MIME of the inbox messages received since
+// the given time to handle, read or not, oldest first. Listing is newest
+// first and stops at limit messages, so the first sync (a zero since)
+// imports the newest ones. It returns the received time up to which every
+// message was handled without error, to pass as since on the next sync;
+// messages after a failed one are still handled and are seen again then.
+func FetchOAuthMessages(sender *models.Sender, since time.Time, limit int, handle func(raw []byte) error) (time.Time, error) {
+	client, err := SenderHTTPClient(sender)
+	if err != nil {
+		return since, err
+	}
+
+	var messages []apiMessage
+	var fetch func(message apiMessage) ([]byte, time.Time, error)
+	switch OAuthMailProvider(sender) {
+	case OAuthProviderGoogle:
+		messages, err = listGmailMessages(client, since, limit)
+		fetch = func(message apiMessage) ([]byte, time.Time, error) { return fetchGmailMessage(client, message.id) }
+	case OAuthProviderMicrosoft:
+		messages, err = listGraphMessages(client, since, limit)
+		fetch = func(message apiMessage) ([]byte, time.Time, error) {
+			raw, err := fetchGraphMessage(client, message.id)
+			return raw, message.received, err
+		}
+	default:
+		return since, fmt.Errorf("sender %d is not connected through OAuth", sender.ID)
+	}
+	if err != nil {
+		return since, err
+	}
+
+	watermark := since
+	failed := false
+	for i := len(messages) - 1; i >= 0; i-- {
+		raw, received, err := fetch(messages[i])
+		if err != nil {
+			return watermark, err
+		}
+		if received.Before(since) {
+			continue
+		}
+		if err := handle(raw); err != nil {
+			failed = true
+			continue
+		}
+		if !failed && received.After(watermark) {
+			watermark = received
+		}
+	}
+	return watermark, nil
+}
+
+// listGmailMessages lists the inbox messages received since the given time,
+// newest first. Gmail searches by whole seconds, so the search starts a
+// second early.
+func listGmailMessages(client *http.Client, since time.Time, limit int) ([]apiMessage, error) {
+	query := url.Values{}
+	query.Set("labelIds", "INBOX")
+	if !since.IsZero() {
+		query.Set("q", fmt.Sprintf("after:%d", since.Unix()-1))
+	}
+	query.Set("maxResults", fmt.Sprint(min(limit, 500)))
+
+	var messages []apiMessage
+	for {
+		var list struct {
+			Messages []struct {
+				ID string `json:"id"`
+			} `json:"messages"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(client, gmailAPIBase+"/messages?"+query.Encode(), &list); err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Messages {
+			messages = append(messages, apiMessage{id: item.ID})
+			if len(messages) >= limit {
+				return messages, nil
+			}
+		}
+		if list.NextPageToken == "" {
+			return messages, nil
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+}
+
+// fetchGmailMessage returns a message's MIME and when Gmail received it
+func fetchGmailMessage(client *http.Client, id string) ([]byte, time.Time, error) {
+	var message struct {
+		Raw          string `json:"raw"`
+		InternalDate int64  `json:"internalDate,string"`
+	}
+	if err := getJSON(client, gmailAPIBase+"/messages/"+url.PathEscape(id)+"?format=raw", &message); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	raw, err := base64.URLEncoding.DecodeString(message.Raw)
+	if err != nil {
+		raw, err = base64.RawURLEncoding.DecodeString(message.Raw)
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decode Gmail message %s: %v", id, err)
+	}
+	return raw, time.UnixMilli(message.InternalDate), nil
+}
+
+// listGraphMessages lists the inbox messages received since the given time,
+// newest first
+func listGraphMessages(client *http.Client, since time.Time, limit int) ([]apiMessage, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("$filter", "receivedDateTime ge "+since.UTC().Format(time.RFC3339))
+	}
+	query.Set("$orderby", "receivedDateTime desc")
+	query.Set("$select", "id,receivedDateTime")
+	query.Set("$top", fmt.Sprint(min(limit, 1000)))
+
+	var messages []apiMessage
+	next := graphAPIBase + "/mailFolders/inbox/messages?" + query.Encode()
+	for next != "" {
+		var list struct {
+			Value []struct {
+				ID               string    `json:"id"`
+				ReceivedDateTime time.Time `json:"receivedDateTime"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := getJSON(client, next, &list); err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Value {
+			messages = append(messages, apiMessage{id: item.ID, received: item.ReceivedDateTime})
+			if len(messages) >= limit {
+				return messages, nil
+			}
+		}
+		next = list.NextLink
+	}
+	return messages, nil
+}
+
+func fetchGraphMessage(client *http.Client, id string) ([]byte, error) {
+	resp, err := client.Get(graphAPIBase + "/messages/" + url.PathEscape(id) + "/$value")
+	if err != nil {
+		return nil, unwrapOAuthError(err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkAPIResponse(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func getJSON(client *http.Client, endpoint string, out interface{}) error {
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return unwrapOAuthError(err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkAPIResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkAPIResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("mail API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// unwrapOAuthError surfaces ErrSenderNeedsReconnect from inside the
+// *url.Error the HTTP client wraps token source failures in
+func unwrapOAuthError(err error) error {
+	if errors.Is(err, ErrSenderNeedsReconnect) {
+		return ErrSenderNeedsReconnect
+	}
+	return err
+}
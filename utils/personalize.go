@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"mailnexy/models"
+)
+
+// Personalization tags look like {{first_name}}, {{company | default:"your team"}}
+// or {{first_name | capitalize | first_word}}. Sections are written as
+// {{#if company}}...{{else}}...{{/if}} and may be nested.
+var templateTagPattern = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// htmlVariables hold HTML already and are not escaped in HTML bodies
+var htmlVariables = map[string]bool{"signature": true}
+
+// passthroughVariables are filled later in the send pipeline and are left
+// untouched by personalization
+var passthroughVariables = map[string]bool{"unsubscribe_url": true}
+
+// TemplateData maps normalised variable names to their values
+type TemplateData map[string]string
+
+// BuildTemplateData collects the variables available to a lead's email:
+// lead fields, custom fields, sender fields and computed values. Standard
+// lead fields win over custom fields with the same name.
+func BuildTemplateData(lead *models.Lead, customFields []models.LeadCustomField, sender *models.Sender, now time.Time) TemplateData {
+	data := TemplateData{}
+
+	for _, field := range customFields {
+		data[normalizeVariable(field.Name)] = strings.TrimSpace(field.Value)
+	}
+
+	if lead != nil {
+		data["email"] = lead.Email
+		data["first_name"] = strings.TrimSpace(lead.FirstName)
+		data["last_name"] = strings.TrimSpace(lead.LastName)
+		data["full_name"] = strings.TrimSpace(lead.FirstName + " " + lead.LastName)
+		data["company"] = strings.TrimSpace(lead.Company)
+		data["position"] = strings.TrimSpace(lead.Position)
+		data["phone"] = strings.TrimSpace(lead.Phone)
+		data["website"] = strings.TrimSpace(lead.Website)
+		data["domain"] = ExtractDomain(lead.Email)
+	}
+
+	if sender != nil {
+		data["sender_name"] = sender.FromName
+		data["sender_first_name"] = firstWord(sender.FromName)
+		data["sender_email"] = sender.FromEmail
+		data["signature"] = sender.Signature
+	}
+
+	data["day_of_week"] = now.Weekday().String()
+	data["month"] = now.Month().String()
+	data["year"] = fmt.Sprint(now.Year())
+	data["today"] = now.Format("January 2, 2006")
+	switch hour := now.Hour(); {
+	case hour < 12:
+		data["time_of_day"] = "morning"
+	case hour < 17:
+		data["time_of_day"] = "afternoon"
+	default:
+		data["time_of_day"] = "evening"
+	}
+
+	return data
+}
+
+// TemplateResult is a rendered template and the variables it needed but
+// had no value or fallback for
+type TemplateResult struct {
+	Output  string
+	Missing []string
+}
+
+// Personalize renders a subject or body for one lead. Values are HTML
+// escaped when isHTML is set. Variables without a value and without a
+// default render empty and are reported in Missing.
+func Personalize(template string, data TemplateData, isHTML bool) (TemplateResult, error) {
+	nodes, err := parseTemplate(template)
+	if err != nil {
+		return TemplateResult{}, err
+	}
+
+	r := templateRenderer{data: data, isHTML: isHTML, missing: map[string]bool{}}
+	r.render(nodes)
+
+	result := TemplateResult{Output: r.out.String()}
+	for name := range r.missing {
+		result.Missing = append(result.Missing, name)
+	}
+	sort.Strings(result.Missing)
+	return result, nil
+}
+
+type templateFilter struct {
+	name string
+	arg  string
+}
+
+type templateNode struct {
+	text string
+
+	// variable tags
+	variable string
+	filters  []templateFilter
+	raw      string
+
+	// {{#if}} sections
+	condition string
+	then      []templateNode
+	otherwise []templateNode
+	isSection bool
+}
+
+type templateFrame struct {
+	node       templateNode
+	inElse     bool
+	parentBody *[]templateNode
+}
+
+func parseTemplate(template string) ([]templateNode, error) {
+	var root []templateNode
+	body := &root
+	var stack []*templateFrame
+
+	last := 0
+	for _, loc := range templateTagPattern.FindAllStringSubmatchIndex(template, -1) {
+		if loc[0] > last {
+			*body = append(*body, templateNode{text: template[last:loc[0]]})
+		}
+		last = loc[1]
+
+		raw := template[loc[0]:loc[1]]
+		tag := template[loc[2]:loc[3]]
+
+		switch {
+		case strings.HasPrefix(tag, "#if"):
+			condition := normalizeVariable(strings.TrimSpace(strings.TrimPrefix(tag, "#if")))
+			if condition == "" {
+				return nil, fmt.Errorf("%s needs a variable", raw)
+			}
+			frame := &templateFrame{node: templateNode{isSection: true, condition: condition}, parentBody: body}
+			stack = append(stack, frame)
+			body = &frame.node.then
+
+		case tag == "else":
+			if len(stack) == 0 || stack[len(stack)-1].inElse {
+				return nil, fmt.Errorf("unexpected {{else}}")
+			}
+			frame := stack[len(stack)-1]
+			frame.inElse = true
+			body = &frame.node.otherwise
+
+		case tag == "/if":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected {{/if}}")
+			}
+			frame := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			body = frame.parentBody
+			*body = append(*body, frame.node)
+
+		default:
+			node, err := parseVariableTag(tag, raw)
+			if err != nil {
+				return nil, err
+			}
+			*body = append(*body, node)
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("{{#if %s}} is not closed", stack[len(stack)-1].node.condition)
+	}
+	if last < len(template) {
+		*body = append(*body, templateNode{text: template[last:]})
+	}
+	return root, nil
+}
+
+func parseVariableTag(tag, raw string) (templateNode, error) {
+	parts := splitFilters(tag)
+	node := templateNode{variable: normalizeVariable(parts[0]), raw: raw}
+	if node.variable == "" {
+		return node, fmt.Errorf("%s has no variable name", raw)
+	}
+
+	for _, part := range parts[1:] {
+		name, arg, _ := strings.Cut(part, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		arg = unquote(strings.TrimSpace(arg))
+
+		switch name {
+		case "default", "capitalize", "upper", "lower", "first_word", "trim":
+		default:
+			return node, fmt.Errorf("unknown filter %q in %s", name, raw)
+		}
+		node.filters = append(node.filters, templateFilter{name: name, arg: arg})
+	}
+	return node, nil
+}
+
+// splitFilters splits a tag on | outside of quoted filter arguments
+func splitFilters(tag string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range tag {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '|':
+			parts = append(parts, strings.TrimSpace(tag[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(tag[start:]))
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+type templateRenderer struct {
+	data    TemplateData
+	isHTML  bool
+	missing map[string]bool
+	out     strings.Builder
+}
+
+func (r *templateRenderer) render(nodes []templateNode) {
+	for _, node := range nodes {
+		switch {
+		case node.isSection:
+			if strings.TrimSpace(r.data[node.condition]) != "" {
+				r.render(node.then)
+			} else {
+				r.render(node.otherwise)
+			}
+		case node.variable != "":
+			r.out.WriteString(r.renderVariable(node))
+		default:
+			r.out.WriteString(node.text)
+		}
+	}
+}
+
+func (r *templateRenderer) renderVariable(node templateNode) string {
+	value, known := r.data[node.variable]
+	if !known && passthroughVariables[node.variable] {
+		return node.raw
+	}
+
+	value = strings.TrimSpace(value)
+	hasDefault := false
+	for _, filter := range node.filters {
+		if filter.name == "default" {
+			hasDefault = true
+			if value == "" {
+				value = filter.arg
+			}
+			continue
+		}
+		value = applyFilter(filter.name, value)
+	}
+
+	if value == "" && !hasDefault {
+		r.missing[node.variable] = true
+	}
+
+	switch {
+	case htmlVariables[node.variable] && !r.isHTML:
+		return strings.TrimSpace(HTMLToText(value))
+	case htmlVariables[node.variable]:
+		return value
+	case r.isHTML:
+		return html.EscapeString(value)
+	}
+	return value
+}
+
+func applyFilter(name, value string) string {
+	switch name {
+	case "capitalize":
+		return capitalizeWords(value)
+	case "upper":
+		return strings.ToUpper(value)
+	case "lower":
+		return strings.ToLower(value)
+	case "first_word":
+		return firstWord(value)
+	case "trim":
+		return strings.TrimSpace(value)
+	}
+	return value
+}
+
+// capitalizeWords upper-cases the first letter of every word and
+// lower-cases the rest, so "jOHN o'neil" becomes "John O'neil"
+func capitalizeWords(s string) string {
+	runes := []rune(strings.ToLower(s))
+	start := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) || r == '-' {
+			start = true
+			continue
+		}
+		if start {
+			runes[i] = unicode.ToUpper(r)
+			start = false
+		}
+	}
+	return string(runes)
+}
+
+func firstWord(s string) string {
+	if fields := strings.Fields(s); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// normalizeVariable maps "First Name", "first-name" and "FIRST_NAME" to
+// first_name so custom field names work however they were imported
+func normalizeVariable(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"mailnexy/config"
+	"mailnexy/models"
+
+	"gorm.io/gorm"
+)
+
+// DeliveryRates counts how the emails of a sender or campaign fared
+type DeliveryRates struct {
+	Attempts   int64 // emails the recipient's server answered for
+	Failed     int64 // sends that failed before reaching it: connection, authentication, TLS or MAIL FROM errors
+	Bounced    int64 // hard and block bounces
+	Complained int64
+	Deferred   int64 // 4xx deferrals and soft bounces
+}
+
+// Bounce and complaint rates are measured over the emails that reached the
+// recipient's server; the deferral rate counts failed sends as deferrals too
+func (r DeliveryRates) BounceRate() float64    { return percent(r.Bounced, r.Attempts) }
+func (r DeliveryRates) ComplaintRate() float64 { return percent(r.Complained, r.Attempts) }
+func (r DeliveryRates) DeferralRate() float64 {
+	return percent(r.Deferred+r.Failed, r.Attempts+r.Failed)
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// Breach returns why the rates cross the configured limits, or "" when they
+// are fine or based on too few emails to judge
+func (r DeliveryRates) Breach(cfg config.ReputationConfig) string {
+	for _, check := range []struct {
+		name  string
+		rate  float64
+		limit float64
+		count int64
+		total int64
+	}{
+		{"Bounce", r.BounceRate(), cfg.MaxBounceRate, r.Bounced, r.Attempts},
+		{"Complaint", r.ComplaintRate(), cfg.MaxComplaintRate, r.Complained, r.Attempts},
+		{"Deferral", r.DeferralRate(), cfg.MaxDeferralRate, r.Deferred + r.Failed, r.Attempts + r.Failed},
+	} {
+		if check.total == 0 || check.total < int64(cfg.MinSends) {
+			continue
+		}
+		if check.limit > 0 && check.rate > check.limit {
+			return fmt.Sprintf("%s rate %.1f%% is above the %g%% limit (%d of %d emails in the last %dh)",
+				check.name, check.rate, check.limit, check.count, check.total, cfg.WindowHours)
+		}
+	}
+	return ""
+}
+
+// SenderDeliveryRates measures a sender's emails attempted since the given time
+func SenderDeliveryRates(db *gorm.DB, senderID uint, since time.Time) (DeliveryRates, error) {
+	return deliveryRates(db.Where("sender_id = ?", senderID), since)
+}
+
+// CampaignDeliveryRates measures a campaign's emails attempted since the given time
+func CampaignDeliveryRates(db *gorm.DB, campaignID uint, since time.Time) (DeliveryRates, error) {
+	return deliveryRates(db.Where("campaign_id = ?", campaignID), since)
+}
+
+// deliveryRates counts activities by outcome. An activity reached the
+// recipient's server once it was sent, bounced or deferred by it; one that
+// is still unsent with an error failed on the sender's side.
+func deliveryRates(scope *gorm.DB, since time.Time) (DeliveryRates, error) {
+	var rates DeliveryRates
+	err := scope.Model(&models.CampaignActivity{}).
+		Select(`COUNT(*) FILTER (WHERE sent_at IS NOT NULL OR bounced_at IS NOT NULL OR retry_count > 0) AS attempts,
+			COUNT(*) FILTER (WHERE sent_at IS NULL AND bounced_at IS NULL AND retry_count = 0 AND last_error <> '') AS failed,
+			COUNT(*) FILTER (WHERE bounced_at IS NOT NULL AND bounce_type <> ?) AS bounced,
+			COUNT(*) FILTER (WHERE complained_at IS NOT NULL) AS complained,
+			COUNT(*) FILTER (WHERE retry_count > 0 OR bounce_type = ?) AS deferred`, BounceSoft, BounceSoft).
+		Where("created_at >= ?", since).
+		Scan(&rates).Error
+	return rates, err
+}
+
+// SenderErrorBreach returns why a sender whose sends keep failing before
+// they reach any recipient's server should be paused, or ""
+func SenderErrorBreach(sender *models.Sender, cfg config.ReputationConfig) string {
+	if cfg.MaxSenderErrors <= 0 || sender.ConsecutiveFailures < cfg.MaxSenderErrors {
+		return ""
+	}
+	reason := fmt.Sprintf("%d sends in a row failed before reaching the recipient's server", sender.ConsecutiveFailures)
+	if sender.LastError != nil && *sender.LastError != "" {
+		reason += " (last error: " + *sender.LastError + ")"
+	}
+	return reason
+}
+
+// PauseSender takes a sender out of rotation for the configured cool-down,
+// or until the user resumes it, and tells the user why
+func PauseSender(db *gorm.DB, sender *models.Sender, reason string, cfg config.ReputationConfig) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"paused_at":    now,
+		"paused_until": nil,
+		"pause_reason": reason,
+	}
+	message := reason + ". Resume the sender once the cause is fixed."
+	if cfg.CoolDownHours > 0 {
+		until := now.Add(time.Duration(cfg.CoolDownHours) * time.Hour)
+		updates["paused_until"] = until
+		message = fmt.Sprintf("%s. It resumes on its own at %s, or earlier once you resume it.", reason, until.Format(time.RFC1123))
+	}
+
+	if err := db.Model(sender).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	notification := models.Notification{
+		UserID:   sender.UserID,
+		Type:     "sender_paused",
+		Title:    fmt.Sprintf("Sender %s was paused", sender.FromEmail),
+		Message:  message,
+		SenderID: &sender.ID,
+	}
+	if err := db.Create(&notification).Error; err != nil {
+		return fmt.Errorf("paused, but failed to notify the user: %v", err)
+	}
+	return nil
+}
+
+// PauseCampaign stops a running campaign and tells the user why and what to
+// do about it. The campaign worker stops once the status is no longer
+// "sending".
+func PauseCampaign(db *gorm.DB, campaign *models.Campaign, reason, advice string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(campaign).Updates(map[string]interface{}{
+			"status":       "paused",
+			"pause_reason": reason,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("campaign_id = ?", campaign.ID).Delete(&models.CampaignExecution{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	notification := models.Notification{
+		UserID:     campaign.UserID,
+		Type:       "campaign_paused",
+		Title:      fmt.Sprintf("Campaign %s was paused", campaign.Name),
+		Message:    reason + ". " + advice,
+		CampaignID: &campaign.ID,
+	}
+	if err := db.Create(&notification).Error; err != nil {
+		return fmt.Errorf("paused, but failed to notify the user: %v", err)
+	}
+	return nil
+}
+
+// ResumeSender lifts a reputation pause. Rates are measured again from now
+// on, so the sends that tripped the breaker do not pause it right away.
+func ResumeSender(db *gorm.DB, sender *models.Sender) error {
+	return db.Model(sender).Updates(map[string]interface{}{
+		"paused_at":            nil,
+		"paused_until":         nil,
+		"pause_reason":         "",
+		"resumed_at":           time.Now(),
+		"consecutive_failures": 0,
+	}).Error
+}
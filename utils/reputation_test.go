@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mailnexy/config"
+	"mailnexy/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testReputationConfig = config.ReputationConfig{
+	WindowHours:      24,
+	MinSends:         10,
+	MaxBounceRate:    5,
+	MaxComplaintRate: 0.3,
+	MaxDeferralRate:  20,
+	MaxSenderErrors:  5,
+}
+
+func TestDeliveryRatesBreach(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates DeliveryRates
+		want  string // prefix of the reason, "" for no breach
+	}{
+		{"healthy", DeliveryRates{Attempts: 100, Bounced: 2, Deferred: 5}, ""},
+		{"too few sends", DeliveryRates{Attempts: 5, Bounced: 5}, ""},
+		{"bounces", DeliveryRates{Attempts: 100, Bounced: 6}, "Bounce rate 6.0%"},
+		{"complaints", DeliveryRates{Attempts: 1000, Complained: 4}, "Complaint rate 0.4%"},
+		{"deferrals", DeliveryRates{Attempts: 100, Deferred: 21}, "Deferral rate 21.0%"},
+		// Failed sends never reached a server, so they do not water down
+		// the bounce rate but do count as deferrals
+		{"failures do not dilute bounces", DeliveryRates{Attempts: 20, Failed: 80, Bounced: 2}, "Bounce rate 10.0%"},
+		{"failures are deferrals", DeliveryRates{Attempts: 20, Failed: 80}, "Deferral rate 80.0%"},
+		{"only failures", DeliveryRates{Failed: 10}, "Deferral rate 100.0%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rates.Breach(testReputationConfig)
+			if (tt.want == "") != (got == "") || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Breach = %q, want %q...", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSenderErrorBreach(t *testing.T) {
+	lastError := "SMTP authentication failed: 535 bad credentials"
+	sender := &models.Sender{ConsecutiveFailures: 4, LastError: &lastError}
+	if reason := SenderErrorBreach(sender, testReputationConfig); reason != "" {
+		t.Errorf("paused after 4 failures: %q", reason)
+	}
+
+	sender.ConsecutiveFailures = 5
+	if reason := SenderErrorBreach(sender, testReputationConfig); !strings.Contains(reason, lastError) {
+		t.Errorf("SenderErrorBreach = %q, want the last error in it", reason)
+	}
+
+	disabled := testReputationConfig
+	disabled.MaxSenderErrors = 0
+	if reason := SenderErrorBreach(sender, disabled); reason != "" {
+		t.Errorf("paused with the check disabled: %q", reason)
+	}
+}
+
+func TestSenderDeliveryRates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "rates.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.CampaignActivity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	activities := []models.CampaignActivity{
+		{SentAt: &now},                                   // delivered
+		{SentAt: &now, ComplainedAt: &now},               // complaint
+		{BouncedAt: &now, BounceType: BounceHard},        // rejected at RCPT
+		{BouncedAt: &now, BounceType: BounceSoft},        // soft bounce
+		{RetryCount: 1, LastError: "RCPT 451 try later"}, // deferred by the server
+		{LastError: "failed to connect to SMTP server"},  // never reached a server
+		{LastError: "SMTP authentication failed"},        // never reached a server
+	}
+	for i := range activities {
+		activities[i].SenderID = 1
+		activities[i].LeadID = uint(i + 1)
+		activities[i].MessageID = strings.Repeat("x", i+1)
+		if err := db.Create(&activities[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rates, err := SenderDeliveryRates(db, 1, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DeliveryRates{Attempts: 5, Failed: 2, Bounced: 1, Complained: 1, Deferred: 2}
+	if rates != want {
+		t.Errorf("SenderDeliveryRates = %+v, want %+v", rates, want)
+	}
+}
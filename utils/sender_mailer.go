@@ -0,0 +1,562 @@
+package utils
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mailnexy/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	smtpPoolSize      = 3
+	smtpIdleTimeout   = 2 * time.Minute
+	smtpDialTimeout   = 15 * time.Second
+	smtpSendTimeout   = 2 * time.Minute
+	smtpAcquireWait   = 30 * time.Second
+	smtpJanitorPeriod = time.Minute
+)
+
+// ErrSMTPPoolBusy is returned when every pooled connection of a sender stays
+// in use for longer than the acquire timeout
+var ErrSMTPPoolBusy = errors.New("all SMTP connections for this sender are busy")
+
+// SMTPRejectionError is returned when the server refuses a transaction with
+// an error reply, which tells the recipient's fate without waiting for a DSN
+type SMTPRejectionError struct {
+	Stage     string // MAIL, RCPT or DATA
+	Recipient string // the refused address for RCPT rejections
+	Code      int
+	Status    string // enhanced status code; derived from the reply code when the server gives none
+	Message   string
+
+	err *textproto.Error
+}
+
+func (e *SMTPRejectionError) Error() string {
+	if e.Recipient != "" {
+		return fmt.Sprintf("%s %s rejected: %d %s", e.Stage, e.Recipient, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s rejected: %d %s", e.Stage, e.Code, e.Message)
+}
+
+func (e *SMTPRejectionError) Unwrap() error {
+	return e.err
+}
+
+// Permanent reports whether the server refused for good (5xx) rather than
+// asking to try again later (4xx)
+func (e *SMTPRejectionError) Permanent() bool {
+	return e.Code >= 500
+}
+
+// smtpRejection turns an error reply into an SMTPRejectionError and passes
+// other errors through
+func smtpRejection(stage, recipient string, err error) error {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return err
+	}
+
+	status, _ := findStatus(reply.Msg)
+	if status == "" {
+		status = strconv.Itoa(reply.Code/100) + ".0.0"
+	}
+	return &SMTPRejectionError{
+		Stage:     stage,
+		Recipient: recipient,
+		Code:      reply.Code,
+		Status:    status,
+		Message:   strings.Join(strings.Fields(reply.Msg), " "),
+		err:       reply,
+	}
+}
+
+// SenderMailService delivers mail through each sender's own SMTP server,
+// keeping a small pool of authenticated connections per sender
+type SenderMailService struct {
+	mu    sync.Mutex
+	pools map[uint]*smtpPool
+}
+
+var (
+	senderMailer     *SenderMailService
+	senderMailerOnce sync.Once
+)
+
+// SenderMailer returns the process-wide sender mail service
+func SenderMailer() *SenderMailService {
+	senderMailerOnce.Do(func() {
+		senderMailer = &SenderMailService{pools: make(map[uint]*smtpPool)}
+		go senderMailer.closeIdleLoop()
+	})
+	return senderMailer
+}
+
+// Send delivers an email through the sender's mailbox and returns the
+// Message-ID and final MIME it was sent with. OAuth-connected senders go
+// through the Gmail API or Microsoft Graph, all others through SMTP.
+func (s *SenderMailService) Send(sender *models.Sender, email Email) (*SentMessage, error) {
+	messageID := email.MessageID
+	if messageID == "" {
+		messageID = GenerateMessageID(uuid.New().String(), sender.FromEmail)
+	}
+
+	if OAuthMailProvider(sender) != "" {
+		raw, err := BuildMIMEMessage(sender, email, messageID, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build message: %v", err)
+		}
+		if err := sendViaAPI(sender, raw); err != nil {
+			return nil, err
+		}
+		return &SentMessage{MessageID: messageID, Raw: raw}, nil
+	}
+
+	raw, err := BuildMIMEMessage(sender, email, messageID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message: %v", err)
+	}
+
+	// Gmail and Microsoft sign API submissions themselves; mail relayed
+	// through the sender's own SMTP server is signed here
+	signed, err := SignDKIM(sender, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bounces go to the envelope sender, which may be a VERP address
+	from := sender.FromEmail
+	if email.ReturnPath != "" {
+		from = email.ReturnPath
+	}
+
+	to, copies := envelopeRecipients(email)
+	refused, err := s.pool(sender).send(from, to, copies, signed)
+	var rejection *SMTPRejectionError
+	if from != sender.FromEmail && errors.As(err, &rejection) && rejection.Stage == "MAIL" {
+		// Many servers only let a mailbox send as itself; bounces then come
+		// back to the mailbox, where the inbox sync picks them up
+		from = sender.FromEmail
+		refused, err = s.pool(sender).send(from, to, copies, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SentMessage{MessageID: messageID, Raw: signed, Refused: refused, ReturnPath: from}, nil
+}
+
+// GenerateMessageID builds an RFC 5322 Message-ID on the sender's domain
+func GenerateMessageID(id, fromEmail string) string {
+	domain := ExtractDomain(fromEmail)
+	if domain == "" {
+		domain = "localhost"
+	}
+	return fmt.Sprintf("<%s@%s>", id, domain)
+}
+
+// pool returns the connection pool for a sender, replacing it when the
+// sender's SMTP settings have changed since it was created
+func (s *SenderMailService) pool(sender *models.Sender) *smtpPool {
+	key := smtpPoolKey(sender)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pool, ok := s.pools[sender.ID]
+	if ok && pool.key == key {
+		return pool
+	}
+	if ok {
+		go pool.close()
+	}
+
+	pool = newSMTPPool(*sender, key)
+	s.pools[sender.ID] = pool
+	return pool
+}
+
+func (s *SenderMailService) closeIdleLoop() {
+	ticker := time.NewTicker(smtpJanitorPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		pools := make([]*smtpPool, 0, len(s.pools))
+		for _, pool := range s.pools {
+			pools = append(pools, pool)
+		}
+		s.mu.Unlock()
+
+		for _, pool := range pools {
+			pool.closeIdle()
+		}
+	}
+}
+
+func smtpPoolKey(sender *models.Sender) string {
+	return strings.Join([]string{
+		sender.SMTPHost,
+		strconv.Itoa(sender.SMTPPort),
+		sender.SMTPUsername,
+		sender.SMTPPassword,
+		strings.ToUpper(sender.Encryption),
+	}, "|")
+}
+
+// smtpPool is a bounded set of authenticated connections to one sender's server
+type smtpPool struct {
+	sender models.Sender
+	key    string
+	slots  chan struct{}
+
+	mu   sync.Mutex
+	idle []*smtpConn
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func newSMTPPool(sender models.Sender, key string) *smtpPool {
+	return &smtpPool{
+		sender: sender,
+		key:    key,
+		slots:  make(chan struct{}, smtpPoolSize),
+	}
+}
+
+// send delivers a message to the to recipients and whichever of the copies
+// the server accepts. The refused copies are returned; a refused to
+// recipient fails the whole send.
+func (p *smtpPool) send(from string, to, copies []string, msg []byte) ([]*SMTPRejectionError, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-time.After(smtpAcquireWait):
+		return nil, ErrSMTPPoolBusy
+	}
+	defer func() { <-p.slots }()
+
+	conn, reused, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	refused, err := conn.envelope(from, to, copies)
+	if err != nil && reused && isStaleConnError(err) {
+		// The server dropped an idle connection; retry once on a fresh one.
+		// Only the envelope is retried: once DATA has started the server
+		// may have accepted the message, and a retry could deliver it twice.
+		conn.close()
+		if conn, err = p.dial(); err != nil {
+			return nil, err
+		}
+		refused, err = conn.envelope(from, to, copies)
+	}
+	if err == nil {
+		err = conn.data(msg)
+	}
+
+	if err != nil {
+		// A rejected transaction leaves the session usable after RSET
+		var reply *textproto.Error
+		if errors.As(err, &reply) && conn.client.Reset() == nil {
+			p.put(conn)
+		} else {
+			conn.close()
+		}
+		return nil, err
+	}
+
+	p.put(conn)
+	return refused, nil
+}
+
+// get pops a healthy idle connection or dials a new one
+func (p *smtpPool) get() (*smtpConn, bool, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(conn.lastUsed) < smtpIdleTimeout {
+			return conn, true, nil
+		}
+		conn.close()
+	}
+
+	conn, err := p.dial()
+	return conn, false, err
+}
+
+func (p *smtpPool) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+	p.mu.Lock()
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+func (p *smtpPool) closeIdle() {
+	p.mu.Lock()
+	var keep, expired []*smtpConn
+	for _, conn := range p.idle {
+		if time.Since(conn.lastUsed) < smtpIdleTimeout {
+			keep = append(keep, conn)
+		} else {
+			expired = append(expired, conn)
+		}
+	}
+	p.idle = keep
+	p.mu.Unlock()
+
+	for _, conn := range expired {
+		conn.close()
+	}
+}
+
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.close()
+	}
+}
+
+// dial opens and authenticates a connection honouring the sender's
+// encryption mode: SSL/TLS use implicit TLS, STARTTLS requires the upgrade
+// and anything else upgrades whenever the server offers STARTTLS. Passwords
+// are only sent over an encrypted connection.
+func (p *smtpPool) dial() (*smtpConn, error) {
+	sender := p.sender
+	addr := net.JoinHostPort(sender.SMTPHost, strconv.Itoa(sender.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: sender.SMTPHost}
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+
+	var conn net.Conn
+	var err error
+	encryption := strings.ToUpper(sender.Encryption)
+	if encryption == "SSL" || encryption == "TLS" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+
+	client, err := smtp.NewClient(conn, sender.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create SMTP client: %v", err)
+	}
+
+	c := &smtpConn{conn: conn, client: client}
+	if err := client.Hello(heloName()); err != nil {
+		c.close()
+		return nil, fmt.Errorf("SMTP EHLO failed: %v", err)
+	}
+
+	if encryption != "SSL" && encryption != "TLS" {
+		ok, _ := client.Extension("STARTTLS")
+		if !ok && encryption == "STARTTLS" {
+			c.close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+		if ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				c.close()
+				return nil, fmt.Errorf("failed to start TLS: %v", err)
+			}
+		}
+	}
+
+	if sender.SMTPUsername != "" {
+		password, err := Decrypt(sender.SMTPPassword)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("failed to decrypt SMTP password: %v", err)
+		}
+
+		auth, err := smtpAuth(client, sender.SMTPUsername, password)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		if auth != nil {
+			if err := client.Auth(auth); err != nil {
+				c.close()
+				return nil, fmt.Errorf("SMTP authentication failed: %v", err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// envelope starts a transaction with MAIL and RCPT. A copy recipient the
+// server refuses is skipped and returned, so one bad Cc or Bcc address does
+// not keep the message from its main recipients.
+func (c *smtpConn) envelope(from string, to, copies []string) ([]*SMTPRejectionError, error) {
+	c.conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+
+	if err := c.client.Mail(from); err != nil {
+		return nil, smtpRejection("MAIL", "", err)
+	}
+	for _, rcpt := range to {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return nil, smtpRejection("RCPT", rcpt, err)
+		}
+	}
+
+	var refused []*SMTPRejectionError
+	for _, rcpt := range copies {
+		err := c.client.Rcpt(rcpt)
+		if err == nil {
+			continue
+		}
+		var rejection *SMTPRejectionError
+		if !errors.As(smtpRejection("RCPT", rcpt, err), &rejection) {
+			return nil, err
+		}
+		refused = append(refused, rejection)
+	}
+	return refused, nil
+}
+
+// data hands the message over to the server
+func (c *smtpConn) data(msg []byte) error {
+	w, err := c.client.Data()
+	if err != nil {
+		return smtpRejection("DATA", "", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return smtpRejection("DATA", "", w.Close())
+}
+
+func (c *smtpConn) close() {
+	if c.client != nil {
+		c.client.Quit()
+		c.client.Close()
+		return
+	}
+	c.conn.Close()
+}
+
+// smtpAuth picks PLAIN or LOGIN depending on what the server advertises.
+// Servers that do not offer AUTH are used unauthenticated.
+func smtpAuth(client *smtp.Client, username, password string) (smtp.Auth, error) {
+	ok, mechanisms := client.Extension("AUTH")
+	if !ok {
+		return nil, nil
+	}
+
+	mechanisms = strings.ToUpper(mechanisms)
+	switch {
+	case strings.Contains(mechanisms, "PLAIN"):
+		return &plainAuth{username: username, password: password}, nil
+	case strings.Contains(mechanisms, "LOGIN"):
+		return &loginAuth{username: username, password: password}, nil
+	}
+	return nil, fmt.Errorf("no supported SMTP auth mechanism in %q", mechanisms)
+}
+
+// errCleartextAuth refuses to send a password the network could read
+var errCleartextAuth = errors.New("SMTP server offers no encryption, refusing to send the password unencrypted")
+
+// plainAuth implements AUTH PLAIN. Like net/smtp's PlainAuth it refuses to
+// authenticate over an unencrypted connection, except to localhost.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errCleartextAuth
+	}
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements the AUTH LOGIN mechanism still required by some
+// providers, including Office 365
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errCleartextAuth
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.Contains(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.Contains(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func heloName() string {
+	if host, err := os.Hostname(); err == nil && strings.Contains(host, ".") {
+		return host
+	}
+	return "localhost"
+}
+
+// isStaleConnError reports whether an error looks like a connection the
+// server closed while it sat idle in the pool
+func isStaleConnError(err error) bool {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "EOF") || strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset")
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net"
+	netsmtp "net/smtp"
+	"strings"
+	"sync"
+	"testing"
+
+	"mailnexy/models"
+
+	"github.com/emersion/go-smtp"
+	"gorm.io/gorm"
+)
+
+// testSMTPBackend accepts mail for every address except those starting with
+// "bad", refuses VERP return paths and remembers the envelope sender and
+// recipients of each delivered message
+type testSMTPBackend struct {
+	mu        sync.Mutex
+	froms     []string
+	delivered [][]string
+}
+
+func (b *testSMTPBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &testSMTPSession{backend: b}, nil
+}
+
+type testSMTPSession struct {
+	backend *testSMTPBackend
+	from    string
+	rcpts   []string
+}
+
+func (s *testSMTPSession) Mail(from string, opts *smtp.MailOptions) error {
+	if strings.HasPrefix(from, "bounces+") {
+		return &smtp.SMTPError{Code: 553, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "Sender address not owned by user"}
+	}
+	s.from = from
+	return nil
+}
+
+func (s *testSMTPSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if strings.HasPrefix(to, "bad") {
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "No such user"}
+	}
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *testSMTPSession) Data(r io.Reader) error {
+	if _, err := io.ReadAll(r); err != nil {
+		return err
+	}
+	s.backend.mu.Lock()
+	s.backend.froms = append(s.backend.froms, s.from)
+	s.backend.delivered = append(s.backend.delivered, s.rcpts)
+	s.backend.mu.Unlock()
+	return nil
+}
+
+func (s *testSMTPSession) Reset()        { s.rcpts = nil }
+func (s *testSMTPSession) Logout() error { return nil }
+
+// startTestSMTPServer serves the backend on a free localhost port and
+// returns a pool for a sender that relays through it
+func startTestSMTPServer(t *testing.T) (*testSMTPBackend, *smtpPool) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &testSMTPBackend{}
+	server := smtp.NewServer(backend)
+	server.Domain = "localhost"
+	go server.Serve(listener)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	pool := newSMTPPool(models.Sender{SMTPHost: "127.0.0.1", SMTPPort: addr.Port}, "")
+	t.Cleanup(func() {
+		pool.close()
+		server.Close()
+	})
+	return backend, pool
+}
+
+func TestSMTPPoolSendSkipsRefusedCopies(t *testing.T) {
+	backend, pool := startTestSMTPServer(t)
+
+	refused, err := pool.send("sender@example.com", []string{"lead@example.org"},
+		[]string{"bad-crm@example.com", "team@example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if len(refused) != 1 || refused[0].Recipient != "bad-crm@example.com" || refused[0].Status != "5.1.1" {
+		t.Errorf("refused = %+v, want the bad copy with 5.1.1", refused)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if len(backend.delivered) != 1 || strings.Join(backend.delivered[0], ",") != "lead@example.org,team@example.com" {
+		t.Errorf("delivered = %q, want one message to the lead and the accepted copy", backend.delivered)
+	}
+}
+
+func TestSMTPPoolSendFailsWhenRecipientRefused(t *testing.T) {
+	backend, pool := startTestSMTPServer(t)
+
+	_, err := pool.send("sender@example.com", []string{"bad-lead@example.org"},
+		[]string{"team@example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	var rejection *SMTPRejectionError
+	if !errors.As(err, &rejection) || rejection.Stage != "RCPT" || rejection.Recipient != "bad-lead@example.org" {
+		t.Fatalf("err = %v, want a RCPT rejection of the lead", err)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if len(backend.delivered) != 0 {
+		t.Errorf("delivered = %q, want nothing", backend.delivered)
+	}
+}
+
+func TestSenderMailServiceFallsBackFromVERP(t *testing.T) {
+	backend, pool := startTestSMTPServer(t)
+	sender := &models.Sender{
+		Model:     gorm.Model{ID: 1},
+		FromEmail: "sender@example.com",
+		SMTPHost:  pool.sender.SMTPHost,
+		SMTPPort:  pool.sender.SMTPPort,
+	}
+	pool.key = smtpPoolKey(sender)
+	service := &SenderMailService{pools: map[uint]*smtpPool{sender.ID: pool}}
+
+	sent, err := service.Send(sender, Email{
+		To:         "lead@example.org",
+		Subject:    "Hi",
+		Body:       "<p>Hello</p>",
+		ReturnPath: VERPAddress("token", "bounce.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if sent.ReturnPath != sender.FromEmail {
+		t.Errorf("ReturnPath = %q, want the sender's address", sent.ReturnPath)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if len(backend.froms) != 1 || backend.froms[0] != sender.FromEmail {
+		t.Errorf("delivered from %q, want one message from the sender's address", backend.froms)
+	}
+}
+
+func TestSMTPAuthRefusesCleartext(t *testing.T) {
+	tests := []struct {
+		server netsmtp.ServerInfo
+		ok     bool
+	}{
+		{netsmtp.ServerInfo{Name: "smtp.example.com", TLS: true}, true},
+		{netsmtp.ServerInfo{Name: "smtp.example.com"}, false},
+		{netsmtp.ServerInfo{Name: "localhost"}, true},
+		{netsmtp.ServerInfo{Name: "127.0.0.1"}, true},
+	}
+
+	for _, auth := range []netsmtp.Auth{&plainAuth{"user", "secret"}, &loginAuth{"user", "secret"}} {
+		for _, tt := range tests {
+			server := tt.server
+			_, _, err := auth.Start(&server)
+			if (err == nil) != tt.ok {
+				t.Errorf("%T.Start(%+v) = %v, want ok %v", auth, tt.server, err, tt.ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Lint severities
+const (
+	LintInfo    = "info"
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+// Scores at or above SpamScoreThreshold are rated poor and block a campaign
+// launch without a force override, in the spirit of SpamAssassin's default of 5
+const (
+	SpamScoreThreshold = 5.0
+	spamScoreFair      = 2.0
+)
+
+// SpamLintInput is the content checked by LintEmailContent
+type SpamLintInput struct {
+	Subject     string   `json:"subject"`
+	Body        string   `json:"body"`
+	Attachments []string `json:"attachments"` // file names
+
+	// UnsubscribeFooter is set when the send pipeline appends an
+	// unsubscribe footer to bodies that do not place the link themselves
+	UnsubscribeFooter bool `json:"unsubscribe_footer"`
+}
+
+// SpamLintWarning is one rule that matched, with what to change
+type SpamLintWarning struct {
+	Rule     string  `json:"rule"`
+	Severity string  `json:"severity"`
+	Points   float64 `json:"points"`
+	Message  string  `json:"message"`
+}
+
+// SpamLintResult is the total score and the rules that contributed to it
+type SpamLintResult struct {
+	Score    float64           `json:"score"`
+	Rating   string            `json:"rating"` // good, fair, poor
+	Warnings []SpamLintWarning `json:"warnings"`
+}
+
+var spamTriggerPhrases = []string{
+	"100% free", "100% satisfied", "act now", "additional income", "all natural",
+	"apply now", "as seen on", "be your own boss", "best price", "buy direct",
+	"call now", "cash bonus", "click below", "click here", "congratulations",
+	"dear friend", "double your", "earn extra cash", "eliminate debt", "extra income",
+	"fast cash", "free access", "free gift", "free trial", "guaranteed",
+	"increase sales", "limited time", "lowest price", "make money", "million dollars",
+	"miracle", "no catch", "no cost", "no credit check", "no obligation",
+	"once in a lifetime", "order now", "risk-free", "risk free", "special promotion",
+	"this isn't spam", "this is not spam", "urgent", "while supplies last", "winner",
+	"you have been selected", "you're a winner",
+}
+
+var urlShorteners = map[string]bool{
+	"bit.ly": true, "tinyurl.com": true, "goo.gl": true, "t.co": true, "ow.ly": true,
+	"is.gd": true, "buff.ly": true, "rebrand.ly": true, "cutt.ly": true, "shorturl.at": true,
+	"tiny.cc": true, "rb.gy": true, "s.id": true, "t.ly": true,
+}
+
+var riskyAttachmentExtensions = map[string]bool{
+	".exe": true, ".scr": true, ".bat": true, ".cmd": true, ".com": true, ".js": true,
+	".vbs": true, ".jar": true, ".msi": true, ".ps1": true, ".iso": true, ".img": true,
+	".zip": true, ".rar": true, ".7z": true, ".html": true, ".htm": true,
+	".docm": true, ".xlsm": true, ".pptm": true,
+}
+
+type htmlLinkInfo struct {
+	href string
+	text string
+}
+
+// LintEmailContent scores an email against local content rules. It needs no
+// network access, so results are a guide rather than a delivery prediction.
+func LintEmailContent(in SpamLintInput) SpamLintResult {
+	l := &spamLinter{}
+
+	isHTML := IsHTML(in.Body)
+	text := in.Body
+	var links []htmlLinkInfo
+	images := 0
+	if isHTML {
+		text = HTMLToText(in.Body)
+		links, images = scanHTML(in.Body)
+	} else {
+		for _, link := range textURLPattern.FindAllString(in.Body, -1) {
+			links = append(links, htmlLinkInfo{href: strings.TrimRight(link, ".,;:!?")})
+		}
+	}
+
+	l.checkPhrases(in.Subject + "\n" + text)
+	l.checkSubject(in.Subject)
+	l.checkCaps(text)
+	l.checkLinks(links)
+	l.checkImages(images, text)
+	if isHTML {
+		l.checkTextRatio(in.Body, text)
+	}
+	l.checkUnsubscribe(in.Body, in.UnsubscribeFooter)
+	l.checkAttachments(in.Attachments)
+
+	return l.result()
+}
+
+type spamLinter struct {
+	warnings []SpamLintWarning
+}
+
+func (l *spamLinter) add(rule, severity string, points float64, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, SpamLintWarning{
+		Rule:     rule,
+		Severity: severity,
+		Points:   points,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *spamLinter) result() SpamLintResult {
+	result := SpamLintResult{Warnings: l.warnings}
+	if result.Warnings == nil {
+		result.Warnings = []SpamLintWarning{}
+	}
+	sort.SliceStable(result.Warnings, func(i, j int) bool {
+		return result.Warnings[i].Points > result.Warnings[j].Points
+	})
+
+	for _, w := range result.Warnings {
+		result.Score += w.Points
+	}
+	result.Score = math.Round(result.Score*10) / 10
+	switch {
+	case result.Score >= SpamScoreThreshold:
+		result.Rating = "poor"
+	case result.Score >= spamScoreFair:
+		result.Rating = "fair"
+	default:
+		result.Rating = "good"
+	}
+	return result
+}
+
+func (l *spamLinter) checkPhrases(content string) {
+	lower := strings.ToLower(content)
+	var found []string
+	for _, phrase := range spamTriggerPhrases {
+		if strings.Contains(lower, phrase) {
+			found = append(found, phrase)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	points := 0.5 * float64(len(found))
+	if points > 3 {
+		points = 3
+	}
+	l.add("spam_phrases", LintWarning, points,
+		"Rephrase common spam trigger phrases: %s", strings.Join(found, ", "))
+}
+
+func (l *spamLinter) checkSubject(subject string) {
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		l.add("subject_missing", LintError, 2, "Add a subject line; empty subjects are heavily filtered")
+		return
+	}
+
+	if len([]rune(subject)) > 80 {
+		l.add("subject_length", LintInfo, 0.3, "Shorten the subject to under 80 characters so it is not truncated")
+	}
+	if upperRatio(subject) > 0.6 && letterCount(subject) >= 8 {
+		l.add("subject_caps", LintWarning, 1.5, "Avoid writing the subject in capital letters")
+	}
+	if n := strings.Count(subject, "!"); n > 1 {
+		l.add("subject_punctuation", LintWarning, 0.8, "Use at most one exclamation mark in the subject (found %d)", n)
+	}
+	if strings.ContainsAny(subject, "$€£") {
+		l.add("subject_currency", LintInfo, 0.5, "Currency symbols in the subject look promotional")
+	}
+	if lower := strings.ToLower(subject); strings.HasPrefix(lower, "re:") || strings.HasPrefix(lower, "fwd:") {
+		l.add("subject_fake_reply", LintWarning, 1, "Do not start a first message with RE: or FWD:")
+	}
+}
+
+func (l *spamLinter) checkCaps(text string) {
+	words, caps := 0, 0
+	for _, word := range strings.Fields(text) {
+		if letterCount(word) < 3 {
+			continue
+		}
+		words++
+		if upperRatio(word) == 1 {
+			caps++
+		}
+	}
+	if words >= 10 && float64(caps)/float64(words) > 0.1 {
+		l.add("body_caps", LintWarning, 1, "%d of %d words are in capital letters; use normal case", caps, words)
+	}
+}
+
+func (l *spamLinter) checkLinks(links []htmlLinkInfo) {
+	if len(links) > 5 {
+		l.add("link_count", LintWarning, 0.2*float64(len(links)-5)+0.5,
+			"Cold emails with many links look like newsletters; %d links found, keep it to 1-3", len(links))
+	}
+
+	shortened := map[string]bool{}
+	for _, link := range links {
+		host := linkHost(link.href)
+		if urlShorteners[host] {
+			shortened[host] = true
+		}
+
+		// Link text that shows a different domain than the link goes to is a
+		// classic phishing marker
+		if shown := linkHost(link.text); shown != "" && host != "" && !sameSite(shown, host) {
+			l.add("link_mismatch", LintError, 2,
+				"Link text shows %s but points to %s; show the real destination", shown, host)
+		}
+	}
+	if len(shortened) > 0 {
+		var hosts []string
+		for host := range shortened {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		l.add("url_shortener", LintError, 2, "Replace shortened links (%s) with full URLs", strings.Join(hosts, ", "))
+	}
+}
+
+func (l *spamLinter) checkImages(images int, text string) {
+	if images == 0 {
+		return
+	}
+	if len(strings.TrimSpace(text)) < 100 {
+		l.add("image_only", LintError, 2.5, "The email is mostly images; add real text content")
+	} else if images > 3 {
+		l.add("image_count", LintWarning, 0.8, "Use fewer images (%d found); cold emails work best as plain text", images)
+	}
+}
+
+func (l *spamLinter) checkTextRatio(htmlBody, text string) {
+	if len(htmlBody) < 500 {
+		return
+	}
+	ratio := float64(len(strings.TrimSpace(text))) / float64(len(htmlBody))
+	if ratio < 0.2 {
+		l.add("text_html_ratio", LintWarning, 1,
+			"Only %.0f%% of the HTML is visible text; simplify the markup", ratio*100)
+	}
+}
+
+func (l *spamLinter) checkUnsubscribe(body string, footer bool) {
+	if footer || strings.Contains(body, UnsubscribePlaceholder) {
+		return
+	}
+	lower := strings.ToLower(body)
+	if strings.Contains(lower, "unsubscribe") || strings.Contains(lower, "opt out") || strings.Contains(lower, "opt-out") {
+		return
+	}
+	l.add("unsubscribe_missing", LintWarning, 1.5,
+		"Add an unsubscribe link with %s or enable the unsubscribe footer", UnsubscribePlaceholder)
+}
+
+func (l *spamLinter) checkAttachments(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	var risky []string
+	for _, name := range names {
+		if riskyAttachmentExtensions[strings.ToLower(filepath.Ext(name))] {
+			risky = append(risky, name)
+		}
+	}
+	if len(risky) > 0 {
+		l.add("attachment_type", LintError, 3,
+			"Attachments of these types are blocked or quarantined by most providers: %s", strings.Join(risky, ", "))
+		return
+	}
+	l.add("attachments", LintInfo, 0.5, "Attachments in a first email lower deliverability; consider linking instead")
+}
+
+// scanHTML collects the links, their visible text and the image count
+func scanHTML(body string) ([]htmlLinkInfo, int) {
+	var links []htmlLinkInfo
+	images := 0
+
+	z := html.NewTokenizer(strings.NewReader(body))
+	var current *htmlLinkInfo
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.A:
+				current = nil
+				if href := attrValue(tok, "href"); strings.HasPrefix(strings.ToLower(href), "http") {
+					links = append(links, htmlLinkInfo{href: href})
+					current = &links[len(links)-1]
+				}
+			case atom.Img:
+				// Tracking pixels are added at send time and not counted here
+				images++
+			}
+		case html.TextToken:
+			if current != nil {
+				current.text += string(z.Text())
+			}
+		case html.EndTagToken:
+			if tok := z.Token(); tok.DataAtom == atom.A {
+				current = nil
+			}
+		}
+	}
+	return links, images
+}
+
+// linkHost returns the host a URL or URL-like text refers to, without www.
+// Text that does not look like a URL gives "".
+func linkHost(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, " \t\n") {
+		return ""
+	}
+	if !strings.Contains(s, "://") {
+		if !strings.Contains(s, ".") {
+			return ""
+		}
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	dot := strings.LastIndex(host, ".")
+	if dot <= 0 || len(host)-dot-1 < 2 {
+		return ""
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+// sameSite treats a host and its subdomains as the same destination
+func sameSite(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
+
+func letterCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			n++
+		}
+	}
+	return n
+}
+
+func upperRatio(s string) float64 {
+	letters, upper := 0, 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters == 0 {
+		return 0
+	}
+	return float64(upper) / float64(letters)
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"math/rand"
+	"strings"
+)
+
+// Spintax picks one option from each {a|b|c} group; groups may be nested.
+// Blocks of the form [shuffle]first[|]second[|]third[/shuffle] have their
+// items emitted in random order. Personalization tags ({{first_name}}) and
+// braces without a | (inline CSS for example) are left as they are.
+const (
+	shuffleOpen  = "[shuffle]"
+	shuffleSep   = "[|]"
+	shuffleClose = "[/shuffle]"
+)
+
+var errUnclosedShuffle = errors.New("[shuffle] block is not closed")
+
+type spinNode struct {
+	text    string
+	options [][]spinNode // {a|b} group
+	items   [][]spinNode // [shuffle] block
+	shuffle bool
+}
+
+// SpinSeed derives a stable seed from the values identifying one rendering,
+// such as campaign, node and lead IDs, so a lead always gets the same copy
+func SpinSeed(parts ...interface{}) int64 {
+	h := fnv.New64a()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%v|", part)
+	}
+	return int64(h.Sum64())
+}
+
+// Spin expands spintax and shuffle blocks using the given seed
+func Spin(text string, seed int64) (string, error) {
+	if !hasSpintax(text) {
+		return text, nil
+	}
+
+	nodes, err := parseSpintax(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	expandSpintax(&b, nodes, rand.New(rand.NewSource(seed)))
+	return b.String(), nil
+}
+
+// SpinPermutations returns how many distinct expansions a text can produce
+func SpinPermutations(text string) (*big.Int, error) {
+	nodes, err := parseSpintax(text)
+	if err != nil {
+		return nil, err
+	}
+	return countSpintax(nodes), nil
+}
+
+func hasSpintax(text string) bool {
+	return strings.Contains(text, "|") || strings.Contains(text, shuffleOpen)
+}
+
+type spinParser struct {
+	src string
+	pos int
+}
+
+func parseSpintax(text string) ([]spinNode, error) {
+	p := &spinParser{src: text}
+	return p.sequence(false, false)
+}
+
+// sequence reads nodes until the end of input or, inside a group or
+// shuffle block, until the delimiter that ends the current option
+func (p *spinParser) sequence(inGroup, inShuffle bool) ([]spinNode, error) {
+	var nodes []spinNode
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, spinNode{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) {
+		rest := p.src[p.pos:]
+
+		switch {
+		case inShuffle && (strings.HasPrefix(rest, shuffleSep) || strings.HasPrefix(rest, shuffleClose)):
+			flush()
+			return nodes, nil
+
+		case inGroup && (rest[0] == '|' || rest[0] == '}'):
+			flush()
+			return nodes, nil
+
+		case strings.HasPrefix(rest, "{{"):
+			// Personalization tag, copied verbatim
+			end := strings.Index(rest, "}}")
+			if end < 0 {
+				text.WriteString(rest)
+				p.pos = len(p.src)
+				continue
+			}
+			text.WriteString(rest[:end+2])
+			p.pos += end + 2
+
+		case strings.HasPrefix(rest, shuffleOpen):
+			flush()
+			node, err := p.shuffleBlock()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+
+		case rest[0] == '{':
+			start := p.pos
+			if node, ok, err := p.group(); err != nil {
+				return nil, err
+			} else if ok {
+				flush()
+				nodes = append(nodes, node...)
+				continue
+			}
+			// No closing brace: the brace is plain text
+			p.pos = start + 1
+			text.WriteByte('{')
+
+		default:
+			text.WriteByte(rest[0])
+			p.pos++
+		}
+	}
+
+	flush()
+	return nodes, nil
+}
+
+// group parses {a|b|c}. It reports false when the group is never closed.
+// A group without alternatives is kept literally, braces included.
+func (p *spinParser) group() ([]spinNode, bool, error) {
+	p.pos++ // {
+
+	var options [][]spinNode
+	for {
+		option, err := p.sequence(true, false)
+		if err != nil {
+			return nil, false, err
+		}
+		options = append(options, option)
+
+		if p.pos >= len(p.src) {
+			return nil, false, nil
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			break
+		}
+		p.pos++ // |
+	}
+
+	if len(options) == 1 {
+		literal := append([]spinNode{{text: "{"}}, options[0]...)
+		return append(literal, spinNode{text: "}"}), true, nil
+	}
+	return []spinNode{{options: options}}, true, nil
+}
+
+func (p *spinParser) shuffleBlock() (spinNode, error) {
+	p.pos += len(shuffleOpen)
+
+	node := spinNode{shuffle: true}
+	for {
+		item, err := p.sequence(false, true)
+		if err != nil {
+			return node, err
+		}
+		node.items = append(node.items, item)
+
+		rest := p.src[p.pos:]
+		switch {
+		case strings.HasPrefix(rest, shuffleSep):
+			p.pos += len(shuffleSep)
+		case strings.HasPrefix(rest, shuffleClose):
+			p.pos += len(shuffleClose)
+			return node, nil
+		default:
+			return node, errUnclosedShuffle
+		}
+	}
+}
+
+func expandSpintax(b *strings.Builder, nodes []spinNode, rng *rand.Rand) {
+	for _, node := range nodes {
+		switch {
+		case node.shuffle:
+			for _, i := range rng.Perm(len(node.items)) {
+				expandSpintax(b, node.items[i], rng)
+			}
+		case node.options != nil:
+			expandSpintax(b, node.options[rng.Intn(len(node.options))], rng)
+		default:
+			b.WriteString(node.text)
+		}
+	}
+}
+
+func countSpintax(nodes []spinNode) *big.Int {
+	total := big.NewInt(1)
+	for _, node := range nodes {
+		switch {
+		case node.shuffle:
+			total.Mul(total, new(big.Int).MulRange(1, int64(len(node.items))))
+			for _, item := range node.items {
+				total.Mul(total, countSpintax(item))
+			}
+		case node.options != nil:
+			sum := new(big.Int)
+			for _, option := range node.options {
+				sum.Add(sum, countSpintax(option))
+			}
+			total.Mul(total, sum)
+		}
+	}
+	return total
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"sort"
+	"testing"
+)
+
+// spinOutputs expands a text with many seeds and returns the distinct results
+func spinOutputs(t *testing.T, text string) []string {
+	t.Helper()
+	seen := map[string]bool{}
+	for seed := int64(0); seed < 200; seed++ {
+		out, err := Spin(text, seed)
+		if err != nil {
+			t.Fatalf("Spin(%q): %v", text, err)
+		}
+		seen[out] = true
+	}
+	outputs := make([]string, 0, len(seen))
+	for out := range seen {
+		outputs = append(outputs, out)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+func TestSpin(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"no spintax", "Hello there", []string{"Hello there"}},
+		{"group", "{Hi|Hello} there", []string{"Hello there", "Hi there"}},
+		{"nested", "{a|{b|c}}", []string{"a", "b", "c"}},
+		{"empty option", "x{|y}", []string{"x", "xy"}},
+		{"personalization kept", "{Hi|Hey} {{first_name}}", []string{"Hey {{first_name}}", "Hi {{first_name}}"}},
+		{"tag inside group", "{Hi {{first_name}}|Hello}", []string{"Hello", "Hi {{first_name}}"}},
+		{"braces without options", "p {color: red} {a|b}", []string{"p {color: red} a", "p {color: red} b"}},
+		{"unclosed group", "{a|b", []string{"{a|b"}},
+		{"pipe outside group", "a|b", []string{"a|b"}},
+		{"shuffle", "[shuffle]A[|]B[/shuffle]", []string{"AB", "BA"}},
+		{"group in shuffle", "[shuffle]{a|b}[|]C[/shuffle]", []string{"Ca", "Cb", "aC", "bC"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spinOutputs(t, tt.text)
+			sort.Strings(tt.want)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Spin(%q) produced %q, want %q", tt.text, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Spin(%q) produced %q, want %q", tt.text, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSpinIsStablePerSeed(t *testing.T) {
+	text := "{Hi|Hello|Hey} {there|friend} [shuffle]A[|]B[|]C[/shuffle]"
+	seed := SpinSeed(1, "node", 42)
+	first, err := Spin(text, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if again, _ := Spin(text, seed); again != first {
+			t.Fatalf("Spin gave %q then %q for the same seed", first, again)
+		}
+	}
+	if SpinSeed(1, "node", 42) == SpinSeed(1, "node", 43) {
+		t.Error("SpinSeed does not depend on its parts")
+	}
+}
+
+func TestSpinUnclosedShuffle(t *testing.T) {
+	for _, text := range []string{"[shuffle]A[|]B", "[shuffle]A"} {
+		if _, err := Spin(text, 1); err == nil {
+			t.Errorf("Spin(%q) did not fail", text)
+		}
+	}
+}
+
+func TestSpinPermutations(t *testing.T) {
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{"plain", 1},
+		{"{a|b|c}", 3},
+		{"{a|b} {c|d}", 4},
+		{"{a|{b|c}}", 3},
+		{"[shuffle]A[|]B[|]C[/shuffle]", 6},
+		{"[shuffle]{a|b}[|]C[/shuffle]", 4},
+		{"{only}", 1},
+	}
+
+	for _, tt := range tests {
+		got, err := SpinPermutations(tt.text)
+		if err != nil {
+			t.Errorf("SpinPermutations(%q): %v", tt.text, err)
+			continue
+		}
+		if got.Int64() != tt.want {
+			t.Errorf("SpinPermutations(%q) = %s, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+
+	"mailnexy/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Suppression sources
+const (
+	SuppressionSourceManual      = "manual"
+	SuppressionSourceImport      = "import"
+	SuppressionSourceBounce      = "bounce"
+	SuppressionSourceUnsubscribe = "unsubscribe"
+	SuppressionSourceComplaint   = "complaint"
+)
+
+// ErrSuppressed is returned when a send is attempted to a suppressed recipient
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+// NormalizeSuppression classifies a value as an email address or a domain and
+// returns it lower-cased. Domains may be given as "example.com" or "@example.com".
+func NormalizeSuppression(value string) (string, string, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.Trim(value, "<>")
+
+	if strings.HasPrefix(value, "@") {
+		value = strings.TrimPrefix(value, "@")
+	} else if strings.Contains(value, "@") {
+		local, domain, ok := strings.Cut(value, "@")
+		if !ok || local == "" || domain == "" || strings.Contains(domain, "@") || !strings.Contains(domain, ".") {
+			return "", "", false
+		}
+		return "email", value, true
+	}
+
+	if value == "" || !strings.Contains(value, ".") || strings.ContainsAny(value, " /\\") {
+		return "", "", false
+	}
+	return "domain", value, true
+}
+
+// SuppressAddress adds an address or domain to an account's suppression list.
+// Existing entries are left untouched so the original source is kept.
+func SuppressAddress(db *gorm.DB, userID uint, value, source, reason string, campaignID *uint) error {
+	suppressionType, normalized, ok := NormalizeSuppression(value)
+	if !ok {
+		return errors.New("invalid email address or domain: " + value)
+	}
+
+	entry := models.Suppression{
+		UserID:     userID,
+		Type:       suppressionType,
+		Value:      normalized,
+		Source:     source,
+		Reason:     reason,
+		CampaignID: campaignID,
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "value"}},
+		DoNothing: true,
+	}).Create(&entry).Error
+}
+
+// IsSuppressed reports whether an address, or its domain, is suppressed for an account
+func IsSuppressed(db *gorm.DB, userID uint, email string) (bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	values := []string{email}
+	if domain := ExtractDomain(email); domain != "" {
+		values = append(values, domain)
+	}
+
+	var count int64
+	if err := db.Model(&models.Suppression{}).
+		Where("user_id = ? AND value IN ?", userID, values).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
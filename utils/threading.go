@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ThreadMessage is what threading needs to know about one message
+type ThreadMessage struct {
+	ID          uint   // UniboxEmail ID
+	MessageID   string // with angle brackets
+	References  []string
+	Subject     string
+	Participant string // the other party: the sender of incoming mail, the recipient of outgoing mail
+	Date        time.Time
+}
+
+// ThreadNode is a container in the thread tree. Message is nil for messages
+// that are referenced but not stored.
+type ThreadNode struct {
+	MessageID string
+	Message   *ThreadMessage
+	Parent    *ThreadNode
+	Children  []*ThreadNode
+}
+
+var (
+	messageIDRe     = regexp.MustCompile(`<[^<>\s]+>`)
+	replyPrefixRe   = regexp.MustCompile(`(?i)^\s*((re|fw|fwd|aw|wg|sv|vs|antw|rif|r|tr|enc|res|odp|pd)(\[\d+\])?\s*:|\[[^\]]*\])\s*`)
+	subjectSpacesRe = regexp.MustCompile(`\s+`)
+)
+
+// ParseReferences lists the Message-IDs a message points at, oldest first and
+// its direct parent last, from its References and In-Reply-To headers
+func ParseReferences(references, inReplyTo string) []string {
+	ids := messageIDRe.FindAllString(references, -1)
+	if parent := messageIDRe.FindString(inReplyTo); parent != "" && (len(ids) == 0 || ids[len(ids)-1] != parent) {
+		ids = append(ids, parent)
+	}
+
+	seen := make(map[string]bool, len(ids))
+	unique := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// ThreadSubject strips reply and forward prefixes so a conversation's
+// messages share one subject
+func ThreadSubject(subject string) string {
+	for {
+		stripped := replyPrefixRe.ReplaceAllString(subject, "")
+		if stripped == subject {
+			break
+		}
+		subject = stripped
+	}
+	return strings.ToLower(strings.TrimSpace(subjectSpacesRe.ReplaceAllString(subject, " ")))
+}
+
+func isReplySubject(subject string) bool {
+	return replyPrefixRe.MatchString(subject)
+}
+
+// ThreadKey turns the Message-ID at the root of a thread into a short,
+// URL-safe thread ID
+func ThreadKey(rootMessageID string) string {
+	sum := sha256.Sum256([]byte(rootMessageID))
+	return hex.EncodeToString(sum[:12])
+}
+
+// BuildThreads arranges messages into conversation trees with Jamie
+// Zawinski's threading algorithm and returns the roots, oldest first.
+// Messages that no header connects are grouped by subject only when they
+// share their participant, so replies from different leads to the same
+// campaign subject stay separate conversations.
+func BuildThreads(messages []ThreadMessage) []*ThreadNode {
+	table := make(map[string]*ThreadNode)
+	container := func(id string) *ThreadNode {
+		node, ok := table[id]
+		if !ok {
+			node = &ThreadNode{MessageID: id}
+			table[id] = node
+		}
+		return node
+	}
+
+	var order []*ThreadNode // containers in creation order, for stable output
+	for i := range messages {
+		message := &messages[i]
+		id := message.MessageID
+		if id == "" || (table[id] != nil && table[id].Message != nil) {
+			// Missing or duplicate Message-IDs get a container of their own
+			id = fmt.Sprintf("\x00%d", i)
+		}
+		node := container(id)
+		node.Message = message
+		order = append(order, node)
+
+		// Link the references to each other, oldest first, leaving existing
+		// links alone
+		var prev *ThreadNode
+		for _, ref := range message.References {
+			refNode := container(ref)
+			if prev != nil && refNode.Parent == nil && refNode != prev && !isAncestor(refNode, prev) {
+				link(prev, refNode)
+			}
+			prev = refNode
+		}
+
+		// The last reference is the parent, replacing any guess made from
+		// another message's references
+		if prev != nil && prev != node && !isAncestor(node, prev) {
+			link(prev, node)
+		} else if prev == nil && node.Parent != nil {
+			unlink(node)
+		}
+	}
+
+	var roots []*ThreadNode
+	seen := make(map[*ThreadNode]bool)
+	for _, node := range order {
+		root := node
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		if !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+
+	var pruned []*ThreadNode
+	for _, root := range roots {
+		pruned = append(pruned, prune(root, true)...)
+	}
+	roots = groupBySubject(pruned)
+
+	for _, root := range roots {
+		sortChildren(root)
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return nodeDate(roots[i]).Before(nodeDate(roots[j])) })
+	return roots
+}
+
+func isAncestor(ancestor, node *ThreadNode) bool {
+	for n := node.Parent; n != nil; n = n.Parent {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func link(parent, child *ThreadNode) {
+	if child.Parent == parent {
+		return
+	}
+	unlink(child)
+	child.Parent = parent
+	parent.Children = append(parent.Children, child)
+}
+
+func unlink(child *ThreadNode) {
+	if child.Parent == nil {
+		return
+	}
+	siblings := child.Parent.Children
+	for i, sibling := range siblings {
+		if sibling == child {
+			child.Parent.Children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	child.Parent = nil
+}
+
+// prune drops containers without a message. Their children move up a level,
+// except at the root where an empty container is kept to hold several
+// children together.
+func prune(node *ThreadNode, isRoot bool) []*ThreadNode {
+	var children []*ThreadNode
+	for _, child := range node.Children {
+		children = append(children, prune(child, false)...)
+	}
+	node.Children = nil
+	for _, child := range children {
+		child.Parent = nil
+	}
+
+	if node.Message == nil && (!isRoot || len(children) <= 1) {
+		return children
+	}
+	for _, child := range children {
+		link(node, child)
+	}
+	return []*ThreadNode{node}
+}
+
+// groupBySubject merges roots that have the same subject and participant
+func groupBySubject(roots []*ThreadNode) []*ThreadNode {
+	bySubject := make(map[string]*ThreadNode)
+	var result []*ThreadNode
+
+	for _, root := range roots {
+		key := subjectKey(root)
+		if key == "" {
+			result = append(result, root)
+			continue
+		}
+		other, ok := bySubject[key]
+		if !ok {
+			bySubject[key] = root
+			result = append(result, root)
+			continue
+		}
+
+		merged := mergeRoots(other, root)
+		if merged != other {
+			bySubject[key] = merged
+			for i, r := range result {
+				if r == other {
+					result[i] = merged
+				}
+			}
+		}
+	}
+	return result
+}
+
+func mergeRoots(existing, root *ThreadNode) *ThreadNode {
+	switch {
+	case existing.Message == nil && root.Message == nil:
+		for _, child := range append([]*ThreadNode(nil), root.Children...) {
+			link(existing, child)
+		}
+		return existing
+	case existing.Message == nil:
+		link(existing, root)
+		return existing
+	case root.Message == nil:
+		link(root, existing)
+		return root
+	case isReplySubject(root.Message.Subject) && !isReplySubject(existing.Message.Subject):
+		link(existing, root)
+		return existing
+	case isReplySubject(existing.Message.Subject) && !isReplySubject(root.Message.Subject):
+		link(root, existing)
+		return root
+	default:
+		holder := &ThreadNode{}
+		link(holder, existing)
+		link(holder, root)
+		return holder
+	}
+}
+
+func subjectKey(root *ThreadNode) string {
+	message := firstMessage(root)
+	if message == nil {
+		return ""
+	}
+	subject := ThreadSubject(message.Subject)
+	if subject == "" {
+		return ""
+	}
+	return subject + "\x00" + strings.ToLower(message.Participant)
+}
+
+func firstMessage(node *ThreadNode) *ThreadMessage {
+	if node.Message != nil {
+		return node.Message
+	}
+	var first *ThreadMessage
+	for _, child := range node.Children {
+		if message := firstMessage(child); message != nil && (first == nil || message.Date.Before(first.Date)) {
+			first = message
+		}
+	}
+	return first
+}
+
+func nodeDate(node *ThreadNode) time.Time {
+	if message := firstMessage(node); message != nil {
+		return message.Date
+	}
+	return time.Time{}
+}
+
+func sortChildren(node *ThreadNode) {
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		return nodeDate(node.Children[i]).Before(nodeDate(node.Children[j]))
+	})
+	for _, child := range node.Children {
+		sortChildren(child)
+	}
+}
+
+// RootMessageID names a thread by the Message-ID at its root, or by its
+// oldest message when the root only groups messages by subject
+func (n *ThreadNode) RootMessageID() string {
+	if n.MessageID != "" && !strings.HasPrefix(n.MessageID, "\x00") {
+		return n.MessageID
+	}
+	if message := firstMessage(n); message != nil {
+		if message.MessageID != "" {
+			return message.MessageID
+		}
+		return "\x00" + message.Subject
+	}
+	return ""
+}
+
+// Walk visits the messages of a thread depth first, oldest first
+func (n *ThreadNode) Walk(visit func(message *ThreadMessage, depth int)) {
+	n.walk(visit, 0)
+}
+
+func (n *ThreadNode) walk(visit func(message *ThreadMessage, depth int), depth int) {
+	if n.Message != nil {
+		visit(n.Message, depth)
+		depth++
+	}
+	for _, child := range n.Children {
+		child.walk(visit, depth)
+	}
+}
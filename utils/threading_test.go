@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// renderThread writes a thread tree as "a(b(c) d)", with * for containers
+// that hold no message
+func renderThread(node *ThreadNode) string {
+	name := "*"
+	if node.Message != nil {
+		name = strings.Trim(node.Message.MessageID, "<>")
+	}
+	if len(node.Children) == 0 {
+		return name
+	}
+	children := make([]string, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = renderThread(child)
+	}
+	return name + "(" + strings.Join(children, " ") + ")"
+}
+
+// threadMessage builds a message to thread; dates follow the order of the
+// test's message list
+func threadMessage(id, subject, participant string, references ...string) ThreadMessage {
+	return ThreadMessage{
+		MessageID:   id,
+		References:  references,
+		Subject:     subject,
+		Participant: participant,
+	}
+}
+
+func TestBuildThreads(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []ThreadMessage
+		want     []string
+	}{
+		{
+			name: "chain",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Hello", "lead@example.org"),
+				threadMessage("<b>", "Re: Hello", "lead@example.org", "<a>"),
+				threadMessage("<c>", "Re: Hello", "lead@example.org", "<a>", "<b>"),
+			},
+			want: []string{"a(b(c))"},
+		},
+		{
+			name: "replies stored before their parents",
+			messages: []ThreadMessage{
+				threadMessage("<c>", "Re: Hello", "lead@example.org", "<a>", "<b>"),
+				threadMessage("<b>", "Re: Hello", "lead@example.org", "<a>"),
+				threadMessage("<a>", "Hello", "lead@example.org"),
+			},
+			want: []string{"a(b(c))"},
+		},
+		{
+			name: "siblings under a missing message",
+			messages: []ThreadMessage{
+				threadMessage("<b>", "Re: Hello", "lead@example.org", "<a>"),
+				threadMessage("<c>", "Re: Hello", "lead@example.org", "<a>"),
+			},
+			want: []string{"*(b c)"},
+		},
+		{
+			name: "single reply to a missing message",
+			messages: []ThreadMessage{
+				threadMessage("<b>", "Re: Hello", "lead@example.org", "<x>", "<a>"),
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "in-reply-to overrides a guessed parent",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Hello", "lead@example.org"),
+				threadMessage("<c>", "Re: Hello", "lead@example.org", "<b>", "<x>"),
+				threadMessage("<x>", "Re: Hello", "lead@example.org", "<a>"),
+			},
+			want: []string{"a(x(c))"},
+		},
+		{
+			name: "reply without headers joins by subject",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Hello", "lead@example.org"),
+				threadMessage("<b>", "RE: hello", "Lead@Example.org"),
+			},
+			want: []string{"a(b)"},
+		},
+		{
+			name: "same subject from other leads stays apart",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Re: Hello", "one@example.org"),
+				threadMessage("<b>", "Re: Hello", "two@example.org"),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "same subject without replies is grouped",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Hello", "lead@example.org"),
+				threadMessage("<b>", "Hello", "lead@example.org"),
+			},
+			want: []string{"*(a b)"},
+		},
+		{
+			name: "duplicate message ids",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "First", "lead@example.org"),
+				threadMessage("<a>", "Second", "lead@example.org"),
+			},
+			want: []string{"a", "a"},
+		},
+		{
+			name: "reference loop",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "One", "lead@example.org", "<b>"),
+				threadMessage("<b>", "Two", "lead@example.org", "<a>"),
+			},
+			want: []string{"b(a)"},
+		},
+		{
+			name: "self reference",
+			messages: []ThreadMessage{
+				threadMessage("<a>", "Hello", "lead@example.org", "<a>"),
+			},
+			want: []string{"a"},
+		},
+	}
+
+	base := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.messages {
+				tt.messages[i].ID = uint(i + 1)
+				tt.messages[i].Date = base.Add(time.Duration(i) * time.Minute)
+			}
+			var got []string
+			for _, root := range BuildThreads(tt.messages) {
+				got = append(got, renderThread(root))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildThreads = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildThreadsOrdersByDate(t *testing.T) {
+	base := time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC)
+	messages := []ThreadMessage{
+		threadMessage("<late>", "Later", "lead@example.org"),
+		threadMessage("<a>", "Hello", "lead@example.org"),
+		threadMessage("<c>", "Re: Hello", "lead@example.org", "<a>"),
+		threadMessage("<b>", "Re: Hello", "lead@example.org", "<a>"),
+	}
+	dates := []time.Duration{4, 1, 3, 2}
+	for i := range messages {
+		messages[i].Date = base.Add(dates[i] * time.Hour)
+	}
+
+	var got []string
+	for _, root := range BuildThreads(messages) {
+		got = append(got, renderThread(root))
+	}
+	if want := []string{"a(b c)", "late"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildThreads = %q, want %q", got, want)
+	}
+}
+
+func TestThreadNodeRootMessageID(t *testing.T) {
+	messages := []ThreadMessage{
+		threadMessage("<a>", "Hello", "lead@example.org"),
+		threadMessage("<b>", "Hello", "lead@example.org"),
+		threadMessage("<d>", "Re: Other", "lead@example.org", "<c>"),
+		threadMessage("<e>", "Re: Other", "lead@example.org", "<c>"),
+	}
+	for i := range messages {
+		messages[i].Date = time.Date(2026, time.March, 1, i, 0, 0, 0, time.UTC)
+	}
+
+	var got []string
+	for _, root := range BuildThreads(messages) {
+		got = append(got, root.RootMessageID())
+	}
+	// A subject group is named after its oldest message, a missing parent
+	// after its own Message-ID
+	if want := []string{"<a>", "<c>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RootMessageID = %q, want %q", got, want)
+	}
+
+	if ThreadKey("<a>") != ThreadKey("<a>") || ThreadKey("<a>") == ThreadKey("<b>") || len(ThreadKey("<a>")) != 24 {
+		t.Errorf("ThreadKey is not a stable 24 character key: %q", ThreadKey("<a>"))
+	}
+}
+
+func TestThreadSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Hello", "hello"},
+		{"Re: Hello", "hello"},
+		{"RE: Fwd: AW:  Hello   World ", "hello world"},
+		{"Re[2]: Hello", "hello"},
+		{"Re : Hello", "hello"},
+		{"[External] Re: Hello", "hello"},
+		{"SV: Tr: Odp: Hello", "hello"},
+		{"Réunion", "réunion"},
+		{"Reply needed", "reply needed"},
+		{"Forward planning", "forward planning"},
+		{"Re:", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ThreadSubject(tt.subject); got != tt.want {
+			t.Errorf("ThreadSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	tests := []struct {
+		references string
+		inReplyTo  string
+		want       []string
+	}{
+		{"<a> <b>", "<b>", []string{"<a>", "<b>"}},
+		{"<a>\r\n <b>", "<c>", []string{"<a>", "<b>", "<c>"}},
+		{"", "<c> (reply to Lead)", []string{"<c>"}},
+		{"<a> <a> <b>", "", []string{"<a>", "<b>"}},
+		{"<a> <b>", "<a>", []string{"<a>", "<b>"}},
+		{"not an id", "", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := ParseReferences(tt.references, tt.inReplyTo)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseReferences(%q, %q) = %q, want %q", tt.references, tt.inReplyTo, got, tt.want)
+		}
+	}
+}
@@ -1,70 +1,223 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net/url"
+	"regexp"
 	"strings"
 
-	"github.com/google/uuid"
+	"mailnexy/config"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
+// TrackingOptions controls which tracking features are injected into a message
+type TrackingOptions struct {
+	BaseURL     string
+	MessageID   string
+	TrackOpens  bool
+	TrackClicks bool
+}
+
+// textURLPattern matches bare http(s) links in plain-text bodies
+var textURLPattern = regexp.MustCompile(`https?://[^\s<>"'\]\)]+`)
+
+// htmlTagPattern detects whether a body is markup or plain text
+var htmlTagPattern = regexp.MustCompile(`(?i)<\s*(html|body|head|p|div|br|a|table|span|img|h[1-6]|ul|ol|li|strong|em|b|i|u|font|center)\b[^>]*>`)
+
 // GenerateTrackingPixelURL generates a tracking pixel URL for email opens
 func GenerateTrackingPixelURL(baseURL, messageID string) string {
-	token := generateUniqueToken(messageID)
+	token := SignTrackingToken(messageID, "")
 	return fmt.Sprintf("%s/track/open/%s/%s", baseURL, messageID, token)
 }
 
-// GenerateClickTrackURL generates a tracked URL for links
+// GenerateClickTrackURL generates a tracked URL for links. The token is bound
+// to the destination so the redirector only follows links that were sent.
 func GenerateClickTrackURL(baseURL, messageID, originalURL string) string {
-	token := generateUniqueToken(messageID)
+	token := SignTrackingToken(messageID, originalURL)
 	encodedURL := url.QueryEscape(originalURL)
 	return fmt.Sprintf("%s/track/click/%s/%s?url=%s", baseURL, messageID, token, encodedURL)
 }
 
-// InjectTracking injects tracking into email content
-func InjectTracking(htmlContent, baseURL, messageID string) string {
-	// Add open tracking pixel
-	pixelURL := GenerateTrackingPixelURL(baseURL, messageID)
-	trackingPixel := fmt.Sprintf(`<img src="%s" alt="" width="1" height="1" style="display:none">`, pixelURL)
-	
-	// Inject click tracking for all links
-	modifiedHTML := injectClickTracking(htmlContent, baseURL, messageID)
-	
-	return modifiedHTML + trackingPixel
+// TrackingBaseURL returns the base URL tracking links should point at. A sender's
+// custom tracking domain wins over the application base URL.
+func TrackingBaseURL(customDomain string) string {
+	domain := strings.TrimSpace(customDomain)
+	if domain == "" {
+		return strings.TrimRight(config.AppConfig.BaseURL, "/")
+	}
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+	return strings.TrimRight(domain, "/")
+}
+
+// IsHTML reports whether content contains HTML markup rather than plain text
+func IsHTML(content string) bool {
+	return htmlTagPattern.MatchString(content)
 }
 
-func injectClickTracking(html, baseURL, messageID string) string {
-	// This is a simplified version. Consider using an HTML parser for production
-	startTag := "<a href=\""
-	endTag := "\""
-	offset := 0
+// InjectTracking rewrites links for click tracking and places the open pixel
+// inside <body>. Links carrying an opt-out attribute (data-notrack,
+// data-no-track, notrack or clicktracking="off") are left untouched.
+func InjectTracking(htmlContent string, opts TrackingOptions) string {
+	var b strings.Builder
+	b.Grow(len(htmlContent) + 256)
+
+	pixelPlaced := !opts.TrackOpens
+	z := html.NewTokenizer(strings.NewReader(htmlContent))
 
 	for {
-		startIdx := strings.Index(html[offset:], startTag)
-		if startIdx == -1 {
+		tt := z.Next()
+		if tt == html.ErrorToken {
 			break
 		}
-		startIdx += offset + len(startTag)
-		
-		endIdx := strings.Index(html[startIdx:], endTag)
-		if endIdx == -1 {
-			break
+
+		// Token() lowercases tag names in the underlying buffer, so copy the
+		// raw bytes first to preserve the original markup
+		raw := string(z.Raw())
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.DataAtom == atom.A && rewriteLink(&tok, opts) {
+				b.WriteString(tok.String())
+				continue
+			}
+		case html.EndTagToken:
+			tok := z.Token()
+			if !pixelPlaced && (tok.DataAtom == atom.Body || tok.DataAtom == atom.Html) {
+				b.WriteString(trackingPixel(opts))
+				pixelPlaced = true
+			}
+		}
+
+		b.WriteString(raw)
+	}
+
+	// Fragments without a <body> get the pixel appended at the end
+	if !pixelPlaced {
+		b.WriteString(trackingPixel(opts))
+	}
+
+	return b.String()
+}
+
+// InjectTextTracking rewrites bare links in a plain-text alternative part.
+// Opens cannot be tracked in plain text, so no pixel is ever added.
+func InjectTextTracking(text string, opts TrackingOptions) string {
+	if !opts.TrackClicks {
+		return text
+	}
+
+	return textURLPattern.ReplaceAllStringFunc(text, func(match string) string {
+		// Sentence punctuation directly after a link is not part of it
+		link := strings.TrimRight(match, ".,;:!?")
+		if !isTrackableURL(link, opts.BaseURL) {
+			return match
+		}
+		return GenerateClickTrackURL(opts.BaseURL, opts.MessageID, link) + match[len(link):]
+	})
+}
+
+// rewriteLink strips opt-out attributes from an anchor and points its href at
+// the click tracker. It reports whether the token was modified.
+func rewriteLink(tok *html.Token, opts TrackingOptions) bool {
+	attrs := make([]html.Attribute, 0, len(tok.Attr))
+	hrefIdx := -1
+	optOut := false
+
+	for _, attr := range tok.Attr {
+		if isNoTrackAttribute(attr) {
+			optOut = true
+			continue
+		}
+		if attr.Key == "href" && hrefIdx == -1 {
+			hrefIdx = len(attrs)
+		}
+		attrs = append(attrs, attr)
+	}
+
+	changed := optOut
+	if hrefIdx >= 0 && opts.TrackClicks && !optOut {
+		href := strings.TrimSpace(attrs[hrefIdx].Val)
+		if isTrackableURL(href, opts.BaseURL) {
+			attrs[hrefIdx].Val = GenerateClickTrackURL(opts.BaseURL, opts.MessageID, href)
+			changed = true
+		}
+	}
+
+	tok.Attr = attrs
+	return changed
+}
+
+func isNoTrackAttribute(attr html.Attribute) bool {
+	switch attr.Key {
+	case "data-notrack", "data-no-track", "notrack":
+		return true
+	case "clicktracking":
+		return strings.EqualFold(strings.TrimSpace(attr.Val), "off")
+	}
+	return false
+}
+
+// isTrackableURL reports whether a link should be routed through the click
+// tracker. Only absolute http(s) links qualify; mailto:, tel:, in-page anchors
+// and links already pointing at the tracker or opt-out page are skipped.
+func isTrackableURL(link, baseURL string) bool {
+	lower := strings.ToLower(strings.TrimSpace(link))
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return false
+	}
+	if baseURL != "" {
+		base := strings.ToLower(baseURL)
+		if strings.HasPrefix(lower, base+"/track/") || strings.HasPrefix(lower, base+"/unsubscribe/") {
+			return false
 		}
-		endIdx += startIdx
+	}
+	return true
+}
 
-		originalURL := html[startIdx:endIdx]
-		trackedURL := GenerateClickTrackURL(baseURL, messageID, originalURL)
-		
-		html = html[:startIdx] + trackedURL + html[endIdx:]
-		offset = startIdx + len(trackedURL)
+func trackingPixel(opts TrackingOptions) string {
+	pixelURL := GenerateTrackingPixelURL(opts.BaseURL, opts.MessageID)
+	return fmt.Sprintf(`<img src="%s" alt="" width="1" height="1" border="0" style="display:none;width:1px;height:1px;border:0">`, html.EscapeString(pixelURL))
+}
+
+// SignTrackingToken returns an HMAC over the message ID and, for click links,
+// the destination URL. Open pixels sign an empty destination.
+func SignTrackingToken(messageID, destination string) string {
+	mac := hmac.New(sha256.New, trackingSecret())
+	mac.Write([]byte(messageID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(destination))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:24]
+}
+
+// VerifyTrackingToken checks a token produced by SignTrackingToken
+func VerifyTrackingToken(messageID, destination, token string) bool {
+	if messageID == "" || token == "" {
+		return false
 	}
-	
-	return html
+	expected := SignTrackingToken(messageID, destination)
+	return hmac.Equal([]byte(expected), []byte(token))
 }
 
-func generateUniqueToken(messageID string) string {
-	hash := sha256.Sum256([]byte(uuid.New().String() + messageID))
-	return base64.URLEncoding.EncodeToString(hash[:])[:20]
+// trackingSecret is TRACKING_SECRET or, when that is not set, a key derived
+// from the encryption key for tracking links only, so the key that also
+// encrypts credentials and signs sessions is never used for them directly
+func trackingSecret() []byte {
+	if config.AppConfig.TrackingSecret != "" {
+		return []byte(config.AppConfig.TrackingSecret)
+	}
+
+	key := make([]byte, sha256.Size)
+	kdf := hkdf.New(sha256.New, []byte(config.AppConfig.EncryptionKey), nil, []byte("mailnexy tracking links"))
+	io.ReadFull(kdf, key) // HKDF only fails past 255 hash lengths of output
+	return key
 }
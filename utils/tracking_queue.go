@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"mailnexy/config"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Tracking event types
+const (
+	TrackingEventOpen  = "open"
+	TrackingEventClick = "click"
+)
+
+// TrackingEvent is a single open or click hit waiting to be applied
+type TrackingEvent struct {
+	Type       string    `json:"t"`
+	MessageID  string    `json:"m"`
+	URL        string    `json:"u,omitempty"`
+	IPAddress  string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"ua,omitempty"`
+	OccurredAt time.Time `json:"at"`
+	Attempts   int       `json:"n,omitempty"` // failed attempts to apply the event
+}
+
+// TrackingQueue buffers tracking events between the HTTP handlers and the
+// consumer that writes them to the database
+type TrackingQueue interface {
+	// Enqueue must never block the request that produced the event
+	Enqueue(event TrackingEvent) error
+	// Dequeue waits up to wait for events and returns at most max of them
+	Dequeue(ctx context.Context, max int, wait time.Duration) ([]TrackingEvent, error)
+	// Requeue puts back events that could not be applied, to be dequeued
+	// again before newer ones
+	Requeue(events []TrackingEvent) error
+}
+
+// ErrTrackingQueueFull is returned when the in-memory buffer is saturated
+var ErrTrackingQueueFull = errors.New("tracking queue is full")
+
+const (
+	trackingQueueKey      = "mailnexy:tracking:events"
+	trackingQueueCapacity = 50000
+)
+
+var trackingQueue TrackingQueue = NewMemoryTrackingQueue(trackingQueueCapacity)
+
+// InitTrackingQueue selects the tracking buffer: Redis when it is enabled so
+// events survive restarts and can be shared between instances, otherwise an
+// in-process buffer
+func InitTrackingQueue(cfg config.RedisConfig) error {
+	if !cfg.Enabled {
+		trackingQueue = NewMemoryTrackingQueue(trackingQueueCapacity)
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Address,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return err
+	}
+
+	trackingQueue = NewRedisTrackingQueue(client)
+	return nil
+}
+
+// GetTrackingQueue returns the configured tracking buffer
+func GetTrackingQueue() TrackingQueue {
+	return trackingQueue
+}
+
+// EnqueueTrackingEvent hands a tracking hit to the configured buffer
+func EnqueueTrackingEvent(event TrackingEvent) error {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	return trackingQueue.Enqueue(event)
+}
+
+// MemoryTrackingQueue is a bounded in-process buffer. Events are dropped, not
+// blocked on, when the consumer falls behind.
+type MemoryTrackingQueue struct {
+	events chan TrackingEvent
+}
+
+func NewMemoryTrackingQueue(capacity int) *MemoryTrackingQueue {
+	return &MemoryTrackingQueue{events: make(chan TrackingEvent, capacity)}
+}
+
+func (q *MemoryTrackingQueue) Enqueue(event TrackingEvent) error {
+	select {
+	case q.events <- event:
+		return nil
+	default:
+		return ErrTrackingQueueFull
+	}
+}
+
+// Requeue adds the events back at the end of the buffer, which is as early
+// as a channel allows
+func (q *MemoryTrackingQueue) Requeue(events []TrackingEvent) error {
+	for _, event := range events {
+		if err := q.Enqueue(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *MemoryTrackingQueue) Dequeue(ctx context.Context, max int, wait time.Duration) ([]TrackingEvent, error) {
+	var batch []TrackingEvent
+	select {
+	case event := <-q.events:
+		batch = append(batch, event)
+	default:
+		if wait <= 0 {
+			return nil, nil
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case event := <-q.events:
+			batch = append(batch, event)
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for len(batch) < max {
+		select {
+		case event := <-q.events:
+			batch = append(batch, event)
+		default:
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+// RedisTrackingQueue stores events in a Redis list. If Redis is briefly
+// unreachable, events are kept in a local buffer and drained first.
+type RedisTrackingQueue struct {
+	client   *redis.Client
+	fallback *MemoryTrackingQueue
+}
+
+func NewRedisTrackingQueue(client *redis.Client) *RedisTrackingQueue {
+	return &RedisTrackingQueue{
+		client:   client,
+		fallback: NewMemoryTrackingQueue(trackingQueueCapacity / 10),
+	}
+}
+
+func (q *RedisTrackingQueue) Enqueue(event TrackingEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := q.client.LPush(ctx, trackingQueueKey, payload).Err(); err != nil {
+		return q.fallback.Enqueue(event)
+	}
+	return nil
+}
+
+// Requeue pushes the events back on the end the consumer reads from, oldest
+// last so they come out in their original order
+func (q *RedisTrackingQueue) Requeue(events []TrackingEvent) error {
+	payloads := make([]interface{}, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		payload, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, payload)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.client.RPush(ctx, trackingQueueKey, payloads...).Err(); err != nil {
+		return q.fallback.Requeue(events)
+	}
+	return nil
+}
+
+func (q *RedisTrackingQueue) Dequeue(ctx context.Context, max int, wait time.Duration) ([]TrackingEvent, error) {
+	batch, _ := q.fallback.Dequeue(ctx, max, 0)
+	if len(batch) >= max {
+		return batch, nil
+	}
+
+	// Block for the first event only when nothing was buffered locally
+	if len(batch) == 0 {
+		result, err := q.client.BRPop(ctx, wait, trackingQueueKey).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if event, ok := decodeTrackingEvent(result[1]); ok {
+			batch = append(batch, event)
+		}
+	}
+
+	for len(batch) < max {
+		payload, err := q.client.RPop(ctx, trackingQueueKey).Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return batch, err
+		}
+		if event, ok := decodeTrackingEvent(payload); ok {
+			batch = append(batch, event)
+		}
+	}
+	return batch, nil
+}
+
+func decodeTrackingEvent(payload string) (TrackingEvent, bool) {
+	var event TrackingEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil || event.MessageID == "" {
+		return event, false
+	}
+	return event, true
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+
+	"mailnexy/config"
+)
+
+func TestTrackingSecret(t *testing.T) {
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig = config.Config{EncryptionKey: "0123456789abcdef0123456789abcdef"}
+
+	derived := trackingSecret()
+	if bytes.Equal(derived, []byte(config.AppConfig.EncryptionKey)) || len(derived) != 32 {
+		t.Errorf("trackingSecret = %x, want a 32 byte key other than the encryption key", derived)
+	}
+	if !bytes.Equal(trackingSecret(), derived) {
+		t.Error("trackingSecret is not stable")
+	}
+
+	token := SignTrackingToken("message", "https://example.com")
+	if !VerifyTrackingToken("message", "https://example.com", token) {
+		t.Error("token not verified")
+	}
+	if VerifyTrackingToken("message", "https://example.org", token) {
+		t.Error("token verified for another destination")
+	}
+
+	config.AppConfig.TrackingSecret = "tracking"
+	if string(trackingSecret()) != "tracking" {
+		t.Error("TRACKING_SECRET not used")
+	}
+	if VerifyTrackingToken("message", "https://example.com", token) {
+		t.Error("token verified with another secret")
+	}
+}
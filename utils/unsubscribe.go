@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// UnsubscribePlaceholder lets a body position the opt-out link itself
+const UnsubscribePlaceholder = "{{unsubscribe_url}}"
+
+// GenerateUnsubscribeURL returns the hosted opt-out link for a message
+func GenerateUnsubscribeURL(baseURL, messageID string) string {
+	token := SignTrackingToken(messageID, "unsubscribe")
+	return fmt.Sprintf("%s/unsubscribe/%s/%s", baseURL, messageID, token)
+}
+
+// VerifyUnsubscribeToken checks a token produced by GenerateUnsubscribeURL
+func VerifyUnsubscribeToken(messageID, token string) bool {
+	return VerifyTrackingToken(messageID, "unsubscribe", token)
+}
+
+// UnsubscribeHeaders returns the RFC 2369 and RFC 8058 one-click headers
+func UnsubscribeHeaders(unsubscribeURL string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// AddUnsubscribeLink fills the {{unsubscribe_url}} placeholder, or appends a
+// footer when the body does not place the link itself and footer is true
+func AddUnsubscribeLink(body, unsubscribeURL string, isHTML, footer bool) string {
+	if strings.Contains(body, UnsubscribePlaceholder) {
+		link := unsubscribeURL
+		if isHTML {
+			link = html.EscapeString(unsubscribeURL)
+		}
+		return strings.ReplaceAll(body, UnsubscribePlaceholder, link)
+	}
+
+	if !footer {
+		return body
+	}
+
+	if !isHTML {
+		return strings.TrimRight(body, "\r\n") + "\n\n--\nIf you'd rather not hear from me again, unsubscribe here: " + unsubscribeURL + "\n"
+	}
+
+	snippet := fmt.Sprintf(`<p style="font-size:12px;color:#888888;margin-top:24px">If you'd rather not hear from me again, <a href="%s" data-notrack style="color:#888888">unsubscribe here</a>.</p>`, html.EscapeString(unsubscribeURL))
+	return insertBeforeBodyEnd(body, snippet)
+}
+
+// insertBeforeBodyEnd places a snippet just before </body> (or </html>),
+// falling back to appending it for fragments
+func insertBeforeBodyEnd(content, snippet string) string {
+	lower := strings.ToLower(content)
+	for _, tag := range []string{"</body", "</html"} {
+		if idx := strings.LastIndex(lower, tag); idx != -1 {
+			return content[:idx] + snippet + content[idx:]
+		}
+	}
+	return content + snippet
+}
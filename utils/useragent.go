@@ -0,0 +1,96 @@
+package utils
+
+import "strings"
+
+// UserAgentInfo describes the mail client and device behind a tracking hit
+type UserAgentInfo struct {
+	Client     string `json:"client"`
+	DeviceType string `json:"device_type"` // desktop, mobile, tablet, unknown
+	// Proxied is set when the hit came from a provider's image proxy, in which
+	// case the IP address belongs to the provider rather than the recipient
+	Proxied bool `json:"proxied"`
+}
+
+// userAgentClients maps user-agent fragments to email clients. Order matters:
+// the first match wins, so more specific fragments come first.
+var userAgentClients = []struct {
+	fragment string
+	client   string
+	proxied  bool
+}{
+	{"googleimageproxy", "Gmail", true},
+	{"ggpht.com", "Gmail", true},
+	{"yahoomailproxy", "Yahoo Mail", true},
+	{"microsoft outlook", "Outlook", false},
+	{"ms-office", "Outlook", false},
+	{"outlook-ios", "Outlook", false},
+	{"outlook-android", "Outlook", false},
+	{"outlook-iphone", "Outlook", false},
+	{"microsoft office", "Outlook", false},
+	{"windows-mail", "Windows Mail", false},
+	{"thunderbird", "Thunderbird", false},
+	{"airmail", "Airmail", false},
+	{"spark", "Spark", false},
+	{"superhuman", "Superhuman", false},
+	{"postbox", "Postbox", false},
+	{"yandex", "Yandex Mail", false},
+	{"gmail", "Gmail", false},
+}
+
+// ParseUserAgent classifies a tracking request's user agent into an email client
+// and device class without calling any external service
+func ParseUserAgent(userAgent string) UserAgentInfo {
+	ua := strings.ToLower(userAgent)
+	info := UserAgentInfo{Client: "Other", DeviceType: "unknown"}
+	if ua == "" {
+		return info
+	}
+
+	info.DeviceType = deviceClass(ua)
+
+	for _, candidate := range userAgentClients {
+		if strings.Contains(ua, candidate.fragment) {
+			info.Client = candidate.client
+			info.Proxied = candidate.proxied
+			if info.Proxied {
+				// Proxies fetch on behalf of every device type
+				info.DeviceType = "unknown"
+			}
+			return info
+		}
+	}
+
+	// Apple Mail loads images through WebKit without a Safari token
+	if strings.Contains(ua, "applewebkit") && !strings.Contains(ua, "safari") &&
+		(strings.Contains(ua, "macintosh") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad")) {
+		info.Client = "Apple Mail"
+		return info
+	}
+
+	switch {
+	case strings.Contains(ua, "edg/"):
+		info.Client = "Edge"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		info.Client = "Chrome"
+	case strings.Contains(ua, "firefox/"):
+		info.Client = "Firefox"
+	case strings.Contains(ua, "safari/"):
+		info.Client = "Safari"
+	}
+	return info
+}
+
+func deviceClass(ua string) string {
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return "tablet"
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") ||
+		strings.Contains(ua, "ipod") || strings.Contains(ua, "android"):
+		return "mobile"
+	case strings.Contains(ua, "windows") || strings.Contains(ua, "macintosh") ||
+		strings.Contains(ua, "x11") || strings.Contains(ua, "linux") || strings.Contains(ua, "cros"):
+		return "desktop"
+	}
+	return "unknown"
+}
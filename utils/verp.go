@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// verpPrefix starts the local part of every VERP return path
+const verpPrefix = "bounces+"
+
+// NewVERPToken returns a random token identifying one sent email
+func NewVERPToken() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// VERPAddress builds the return path bounces+<token>@<domain>. Receiving
+// servers send bounces for the email to it, so the token alone identifies
+// which email failed.
+func VERPAddress(token, domain string) string {
+	return verpPrefix + token + "@" + strings.ToLower(domain)
+}
+
+// ParseVERPAddress extracts the token from a VERP return path on the given
+// domain
+func ParseVERPAddress(address, domain string) (string, bool) {
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	local, host, ok := strings.Cut(address, "@")
+	if !ok || host != strings.ToLower(domain) || !strings.HasPrefix(local, verpPrefix) {
+		return "", false
+	}
+
+	token := strings.TrimPrefix(local, verpPrefix)
+	if _, err := hex.DecodeString(token); err != nil || len(token) != 24 {
+		return "", false
+	}
+	return token, true
+}
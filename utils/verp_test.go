@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVERPRoundTrip(t *testing.T) {
+	token, err := NewVERPToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := VERPAddress(token, "Bounces.Example.com")
+	if want := "bounces+" + token + "@bounces.example.com"; address != want {
+		t.Fatalf("VERPAddress = %q, want %q", address, want)
+	}
+
+	got, ok := ParseVERPAddress(address, "bounces.example.com")
+	if !ok || got != token {
+		t.Errorf("ParseVERPAddress(%q) = %q, %v, want %q, true", address, got, ok, token)
+	}
+}
+
+func TestParseVERPAddress(t *testing.T) {
+	token := strings.Repeat("0a", 12)
+
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		ok      bool
+	}{
+		{"plain", "bounces+" + token + "@bounces.example.com", token, true},
+		{"angle brackets", "<bounces+" + token + "@bounces.example.com>", token, true},
+		{"upper case", "BOUNCES+" + strings.ToUpper(token) + "@BOUNCES.EXAMPLE.COM", token, true},
+		{"surrounding space", "  bounces+" + token + "@bounces.example.com ", token, true},
+		{"other domain", "bounces+" + token + "@example.com", "", false},
+		{"subdomain", "bounces+" + token + "@mx.bounces.example.com", "", false},
+		{"no prefix", token + "@bounces.example.com", "", false},
+		{"other prefix", "replies+" + token + "@bounces.example.com", "", false},
+		{"short token", "bounces+" + token[:22] + "@bounces.example.com", "", false},
+		{"long token", "bounces+" + token + "00@bounces.example.com", "", false},
+		{"not hex", "bounces+" + strings.Repeat("zz", 12) + "@bounces.example.com", "", false},
+		{"no domain", "bounces+" + token, "", false},
+		{"empty", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseVERPAddress(tt.address, "bounces.example.com")
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseVERPAddress(%q) = %q, %v, want %q, %v", tt.address, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
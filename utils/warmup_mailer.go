@@ -3,6 +3,7 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
 	"math/rand"
@@ -11,6 +12,8 @@ import (
 	"time"
 
 	"mailnexy/models"
+
+	"github.com/google/uuid"
 	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
 )
@@ -76,7 +79,7 @@ func (wm *WarmupMailer) SendWarmupEmail(senderID uint, fromEmail, fromName strin
 			time.Sleep(backoff)
 		}
 
-		err := wm.trySend(dialer, fromEmail, fromName)
+		err := wm.trySend(dialer, &sender, fromEmail, fromName)
 		if err == nil {
 			return nil
 		}
@@ -90,7 +93,7 @@ func (wm *WarmupMailer) SendWarmupEmail(senderID uint, fromEmail, fromName strin
 	return fmt.Errorf("failed after %d attempts: %v", maxRetries, lastError)
 }
 
-func (wm *WarmupMailer) trySend(dialer *gomail.Dialer, fromEmail, fromName string) error {
+func (wm *WarmupMailer) trySend(dialer *gomail.Dialer, sender *models.Sender, fromEmail, fromName string) error {
 	subject, body := wm.generateWarmupContent(fromName)
 
 	// Use the configured warmup email instead of random generation
@@ -117,12 +120,26 @@ func (wm *WarmupMailer) trySend(dialer *gomail.Dialer, fromEmail, fromName strin
 	delay := time.Duration(3+rand.Intn(7)) * time.Second
 	time.Sleep(delay)
 
-	// Test connection first
-	if _, err := dialer.Dial(); err != nil {
+	m.SetHeader("Message-ID", GenerateMessageID(uuid.New().String(), fromEmail))
+
+	// Render and sign the message ourselves so warmup mail carries the same
+	// DKIM signature as campaign mail
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return fmt.Errorf("failed to build message: %v", err)
+	}
+	signed, err := SignDKIM(sender, raw.Bytes())
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialer.Dial()
+	if err != nil {
 		return fmt.Errorf("SMTP connection failed: %v", err)
 	}
+	defer conn.Close()
 
-	if err := dialer.DialAndSend(m); err != nil {
+	if err := conn.Send(fromEmail, []string{toEmail}, bytes.NewReader(signed)); err != nil {
 		return fmt.Errorf("send failed: %v", err)
 	}
 
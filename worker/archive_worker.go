@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	archivePurgeInterval = 6 * time.Hour
+	archivePurgeBatch    = 500
+)
+
+// ArchiveWorker deletes sent message archives older than the retention period
+type ArchiveWorker struct {
+	DB        *gorm.DB
+	Retention time.Duration
+	Logger    *log.Logger
+}
+
+func NewArchiveWorker(db *gorm.DB, retentionDays int, logger *log.Logger) *ArchiveWorker {
+	return &ArchiveWorker{
+		DB:        db,
+		Retention: time.Duration(retentionDays) * 24 * time.Hour,
+		Logger:    logger,
+	}
+}
+
+func (aw *ArchiveWorker) Start(ctx context.Context) {
+	if aw.Retention <= 0 {
+		aw.Logger.Println("Archive retention disabled, sent messages are kept forever")
+		return
+	}
+
+	aw.Logger.Printf("Archive worker started (retention %v)", aw.Retention)
+	ticker := time.NewTicker(archivePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		aw.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			aw.Logger.Println("Archive worker shutting down...")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (aw *ArchiveWorker) purge(ctx context.Context) {
+	cutoff := time.Now().Add(-aw.Retention)
+	purged := 0
+
+	for ctx.Err() == nil {
+		var activities []models.CampaignActivity
+		if err := aw.DB.Select("id", "archive_key").
+			Where("archive_key <> '' AND sent_at < ?", cutoff).
+			Limit(archivePurgeBatch).
+			Find(&activities).Error; err != nil {
+			aw.Logger.Printf("Failed to load expired archives: %v", err)
+			return
+		}
+		if len(activities) == 0 {
+			break
+		}
+
+		ids := make([]uint, 0, len(activities))
+		for _, activity := range activities {
+			if err := utils.GetBlobStore().Delete(activity.ArchiveKey); err != nil {
+				aw.Logger.Printf("Failed to delete archive %s: %v", activity.ArchiveKey, err)
+				continue
+			}
+			ids = append(ids, activity.ID)
+		}
+		if len(ids) == 0 {
+			// Every delete failed; try again on the next run
+			return
+		}
+
+		if err := aw.DB.Model(&models.CampaignActivity{}).
+			Where("id IN ?", ids).
+			Update("archive_key", "").Error; err != nil {
+			aw.Logger.Printf("Failed to clear archive keys: %v", err)
+			return
+		}
+		purged += len(ids)
+	}
+
+	if purged > 0 {
+		aw.Logger.Printf("Purged %d archived messages sent before %s", purged, cutoff.Format(time.RFC3339))
+	}
+}
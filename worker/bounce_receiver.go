@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/emersion/go-smtp"
+	"gorm.io/gorm"
+)
+
+const (
+	bounceMaxMessageBytes = 10 << 20
+	bounceMaxRecipients   = 50
+	bounceSMTPTimeout     = time.Minute
+)
+
+var errUnknownBounceRecipient = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+	Message:      "No such recipient",
+}
+
+// BounceReceiver is a small SMTP server accepting bounces sent to VERP
+// return paths on the bounce domain. Only recipients whose token belongs to
+// a sent campaign email are accepted, so it can not be used as a relay.
+// Point the bounce domain's MX record at it, or for a local test send a DSN
+// to bounces+<token>@<domain> on the listen address with any SMTP client.
+type BounceReceiver struct {
+	DB     *gorm.DB
+	Addr   string
+	Domain string
+	Logger *log.Logger
+}
+
+func NewBounceReceiver(db *gorm.DB, addr, domain string, logger *log.Logger) *BounceReceiver {
+	return &BounceReceiver{
+		DB:     db,
+		Addr:   addr,
+		Domain: domain,
+		Logger: logger,
+	}
+}
+
+func (br *BounceReceiver) Start(ctx context.Context) {
+	if br.Addr == "" || br.Domain == "" {
+		br.Logger.Println("Bounce receiver disabled, set VERP_DOMAIN and BOUNCE_SMTP_ADDR to enable it")
+		return
+	}
+
+	listener, err := net.Listen("tcp", br.Addr)
+	if err != nil {
+		br.Logger.Printf("Bounce receiver failed to listen on %s: %v", br.Addr, err)
+		return
+	}
+	br.Serve(ctx, listener)
+}
+
+// Serve accepts bounces on the listener until the context ends
+func (br *BounceReceiver) Serve(ctx context.Context, listener net.Listener) {
+	server := smtp.NewServer(br)
+	server.Domain = br.Domain
+	server.ReadTimeout = bounceSMTPTimeout
+	server.WriteTimeout = bounceSMTPTimeout
+	server.MaxMessageBytes = bounceMaxMessageBytes
+	server.MaxRecipients = bounceMaxRecipients
+
+	go func() {
+		<-ctx.Done()
+		br.Logger.Println("Bounce receiver shutting down...")
+		server.Close()
+	}()
+
+	br.Logger.Printf("Bounce receiver listening on %s for %s", listener.Addr(), br.Domain)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+		br.Logger.Printf("Bounce receiver stopped: %v", err)
+	}
+}
+
+// NewSession implements smtp.Backend
+func (br *BounceReceiver) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &bounceSession{receiver: br}, nil
+}
+
+// bounceSession collects the emails a message is a bounce for, one per
+// accepted VERP recipient
+type bounceSession struct {
+	receiver   *BounceReceiver
+	activities []models.CampaignActivity
+}
+
+func (s *bounceSession) Mail(from string, opts *smtp.MailOptions) error {
+	// Bounces come with a null reverse path, but any sender is accepted
+	return nil
+}
+
+func (s *bounceSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	token, ok := utils.ParseVERPAddress(to, s.receiver.Domain)
+	if !ok {
+		return errUnknownBounceRecipient
+	}
+
+	var activity models.CampaignActivity
+	if err := s.receiver.DB.Preload("Lead").Where("verp_token = ?", token).First(&activity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errUnknownBounceRecipient
+		}
+		s.receiver.Logger.Printf("Failed to look up VERP token %s: %v", token, err)
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Temporary lookup failure"}
+	}
+
+	s.activities = append(s.activities, activity)
+	return nil
+}
+
+func (s *bounceSession) Data(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	// Auto-replies are sometimes sent to the return path too; only delivery
+	// status notifications are recorded
+	report, ok := utils.ParseDSN(raw)
+	if !ok {
+		s.receiver.Logger.Printf("Discarding non-bounce message for %d VERP recipients", len(s.activities))
+		return nil
+	}
+
+	for i := range s.activities {
+		activity := &s.activities[i]
+		recipient, ok := bounceRecipient(report, activity.Lead.Email)
+		if !ok {
+			continue
+		}
+		bounceType := recipient.BounceType()
+		if bounceType == "" {
+			continue
+		}
+
+		// The token already says which email bounced, so the lead's address
+		// is used even when the report names a forwarding target
+		event := utils.BounceEvent{
+			Email:          activity.Lead.Email,
+			Type:           bounceType,
+			Code:           recipient.Status,
+			Message:        utils.DescribeBounceStatus(recipient.Status),
+			DiagnosticCode: recipient.DiagnosticCode,
+		}
+		if err := utils.RecordBounce(s.receiver.DB, activity.SenderID, activity, event); err != nil {
+			s.receiver.Logger.Printf("Failed to record bounce for activity %d: %v", activity.ID, err)
+			return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Temporary failure recording bounce"}
+		}
+		s.receiver.Logger.Printf("Recorded %s bounce for activity %d (%s)", bounceType, activity.ID, recipient.Status)
+	}
+	return nil
+}
+
+// bounceRecipient picks the report entry for the lead, or the only entry
+// when the report names a single recipient
+func bounceRecipient(report *utils.DSNReport, email string) (utils.DSNRecipient, bool) {
+	for _, recipient := range report.Recipients {
+		if strings.EqualFold(recipient.Email, email) {
+			return recipient, true
+		}
+	}
+	if len(report.Recipients) == 1 {
+		return report.Recipients[0], true
+	}
+	return utils.DSNRecipient{}, false
+}
+
+func (s *bounceSession) Reset() {
+	s.activities = nil
+}
+
+func (s *bounceSession) Logout() error {
+	return nil
+}
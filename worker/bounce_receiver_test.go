@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testBounceDomain = "bounces.example.com"
+
+const testDSN = "From: Mail Delivery System <mailer-daemon@mx.example.net>\r\n" +
+	"To: bounces@bounces.example.com\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.net\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; lead@example.org\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 <lead@example.org>: User unknown\r\n" +
+	"--BOUNDARY--\r\n"
+
+// startBounceReceiver serves a receiver backed by a scratch database on a
+// free localhost port
+func startBounceReceiver(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "bounces.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Lead{}, &models.Campaign{}, &models.CampaignActivity{},
+		&models.LeadActivity{}, &models.Bounce{}, &models.Suppression{}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	receiver := NewBounceReceiver(db, listener.Addr().String(), testBounceDomain, log.New(io.Discard, "", 0))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		receiver.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return db, listener.Addr().String()
+}
+
+func TestBounceReceiverRecordsVERPBounce(t *testing.T) {
+	db, addr := startBounceReceiver(t)
+
+	lead := models.Lead{UserID: 1, Email: "lead@example.org"}
+	if err := db.Create(&lead).Error; err != nil {
+		t.Fatal(err)
+	}
+	campaign := models.Campaign{UserID: 1, Name: "Test"}
+	if err := db.Create(&campaign).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, err := utils.NewVERPToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := models.CampaignActivity{
+		CampaignID: campaign.ID,
+		LeadID:     lead.ID,
+		UserID:     1,
+		SenderID:   1,
+		MessageID:  "test-message",
+		VERPToken:  token,
+		SentAt:     utils.Pointer(time.Now()),
+	}
+	if err := db.Create(&activity).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := smtp.SendMail(addr, nil, "", []string{utils.VERPAddress(token, testBounceDomain)}, []byte(testDSN)); err != nil {
+		t.Fatalf("sending bounce: %v", err)
+	}
+
+	if err := db.First(&activity, activity.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if activity.BouncedAt == nil {
+		t.Error("BouncedAt not set")
+	}
+	if activity.BounceType != utils.BounceHard {
+		t.Errorf("BounceType = %q, want %q", activity.BounceType, utils.BounceHard)
+	}
+
+	if err := db.First(&lead, lead.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !lead.IsBounced {
+		t.Error("lead not marked as bounced")
+	}
+	suppressed, err := utils.IsSuppressed(db, 1, lead.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suppressed {
+		t.Error("hard bounced address not suppressed")
+	}
+}
+
+func TestBounceReceiverRejectsUnknownRecipients(t *testing.T) {
+	_, addr := startBounceReceiver(t)
+
+	unknownToken := strings.Repeat("ab", 12)
+	for _, rcpt := range []string{
+		utils.VERPAddress(unknownToken, testBounceDomain),
+		"bounces+" + unknownToken + "@other.example.com",
+		"postmaster@" + testBounceDomain,
+	} {
+		err := smtp.SendMail(addr, nil, "", []string{rcpt}, []byte(testDSN))
+		if err == nil || !strings.Contains(err.Error(), "550") {
+			t.Errorf("%s: got %v, want a 550 rejection", rcpt, err)
+		}
+	}
+}
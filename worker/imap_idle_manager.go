@@ -0,0 +1,279 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	controller "mailnexy/controllers"
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"github.com/emersion/go-imap/client"
+	"gorm.io/gorm"
+)
+
+const (
+	idleRefreshInterval = time.Minute      // how often the list of mailboxes to watch is reloaded
+	idleResyncInterval  = 10 * time.Minute // safety sync in case the server missed a notification
+	idlePollInterval    = 30 * time.Second // NOOP polling for servers without IDLE
+	idleMinBackoff      = 10 * time.Second
+	idleMaxBackoff      = 10 * time.Minute
+	// idleHealthyAfter is how long a session has to last for the back-off to reset
+	idleHealthyAfter = 5 * time.Minute
+)
+
+// IMAPIdleManager keeps an IMAP session open per sender mailbox and fetches
+// new mail as soon as the server announces it with IDLE, so replies show up
+// in the Unibox within seconds. Servers without IDLE are polled with NOOP on
+// the same connection. Dropped sessions reconnect with exponential back-off.
+// At most MaxConnections sessions are kept; the remaining senders are left to
+// the UniboxWorker's periodic fetch.
+type IMAPIdleManager struct {
+	DB             *gorm.DB
+	MaxConnections int
+	Logger         *log.Logger
+
+	mu       sync.Mutex
+	sessions map[uint]*idleSession
+}
+
+type idleSession struct {
+	settings string      // the IMAP settings the session was opened with
+	live     atomic.Bool // connected and caught up, false while backing off
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// imapSettings identifies what a session depends on; other sender updates,
+// such as counters and rates, leave the session alone
+func imapSettings(sender *models.Sender) string {
+	return fmt.Sprintf("%s:%d/%s/%s/%s/%s", sender.IMAPHost, sender.IMAPPort, sender.IMAPEncryption,
+		sender.IMAPUsername, sender.IMAPPassword, controller.SenderMailbox(sender))
+}
+
+func NewIMAPIdleManager(db *gorm.DB, maxConnections int, logger *log.Logger) *IMAPIdleManager {
+	return &IMAPIdleManager{
+		DB:             db,
+		MaxConnections: maxConnections,
+		Logger:         logger,
+		sessions:       make(map[uint]*idleSession),
+	}
+}
+
+func (im *IMAPIdleManager) Start(ctx context.Context) {
+	if im.MaxConnections <= 0 {
+		im.Logger.Println("IMAP IDLE disabled, mailboxes are polled")
+		return
+	}
+
+	im.Logger.Printf("IMAP IDLE manager started (up to %d connections)", im.MaxConnections)
+	ticker := time.NewTicker(idleRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		im.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			im.Logger.Println("IMAP IDLE manager shutting down...")
+			im.stopAll()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watching reports whether a live session covers the sender's mailbox
+func (im *IMAPIdleManager) Watching(senderID uint) bool {
+	if im == nil {
+		return false
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	session, ok := im.sessions[senderID]
+	return ok && session.live.Load()
+}
+
+// refresh starts sessions for new IMAP senders, restarts those whose settings
+// changed and stops those that were removed
+func (im *IMAPIdleManager) refresh(ctx context.Context) {
+	var found []models.Sender
+	if err := im.DB.Where("imap_host IS NOT NULL AND imap_host != ''").
+		Order("id").
+		Find(&found).Error; err != nil {
+		im.Logger.Printf("Failed to load IMAP senders: %v", err)
+		return
+	}
+
+	// OAuth senders are read through their provider's API
+	var senders []models.Sender
+	for _, sender := range found {
+		if utils.OAuthMailProvider(&sender) == "" {
+			senders = append(senders, sender)
+		}
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	current := make(map[uint]bool, len(senders))
+	for _, sender := range senders {
+		current[sender.ID] = true
+		if session, ok := im.sessions[sender.ID]; ok && session.settings != imapSettings(&sender) {
+			im.stopLocked(sender.ID)
+		}
+	}
+	for senderID := range im.sessions {
+		if !current[senderID] {
+			im.stopLocked(senderID)
+		}
+	}
+
+	for i := range senders {
+		sender := senders[i]
+		if _, ok := im.sessions[sender.ID]; ok {
+			continue
+		}
+		if len(im.sessions) >= im.MaxConnections {
+			break
+		}
+
+		sessionCtx, cancel := context.WithCancel(ctx)
+		session := &idleSession{settings: imapSettings(&sender), cancel: cancel, done: make(chan struct{})}
+		im.sessions[sender.ID] = session
+		go func() {
+			defer close(session.done)
+			im.watch(sessionCtx, session, &sender)
+		}()
+	}
+}
+
+func (im *IMAPIdleManager) stopLocked(senderID uint) {
+	session := im.sessions[senderID]
+	session.cancel()
+	delete(im.sessions, senderID)
+}
+
+func (im *IMAPIdleManager) stopAll() {
+	im.mu.Lock()
+	sessions := im.sessions
+	im.sessions = make(map[uint]*idleSession)
+	im.mu.Unlock()
+
+	for _, session := range sessions {
+		session.cancel()
+	}
+	for _, session := range sessions {
+		<-session.done
+	}
+}
+
+// watch keeps a session open for the sender until the context ends,
+// reconnecting with back-off whenever it drops
+func (im *IMAPIdleManager) watch(ctx context.Context, session *idleSession, sender *models.Sender) {
+	uc := controller.NewUniboxController(im.DB, im.Logger)
+	backoff := idleMinBackoff
+
+	for {
+		started := time.Now()
+		err := im.session(ctx, session, uc, sender)
+		session.live.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) >= idleHealthyAfter {
+			backoff = idleMinBackoff
+		}
+		im.Logger.Printf("IMAP session for sender %d ended: %v, reconnecting in %s", sender.ID, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > idleMaxBackoff {
+			backoff = idleMaxBackoff
+		}
+	}
+}
+
+// session connects, catches up on the mailbox and then idles, syncing
+// whenever the server reports a change
+func (im *IMAPIdleManager) session(ctx context.Context, session *idleSession, uc *controller.UniboxController, sender *models.Sender) error {
+	imapClient, err := uc.DialIMAP(sender)
+	if err != nil {
+		return err
+	}
+	defer imapClient.Logout()
+
+	// The client blocks when updates are not read, so they are read until
+	// it logs out and collapsed into a single pending signal
+	updates := make(chan client.Update, 16)
+	changed := make(chan struct{}, 1)
+	imapClient.Updates = updates
+	go func() {
+		for {
+			select {
+			case update := <-updates:
+				if _, ok := update.(*client.MailboxUpdate); ok {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			case <-imapClient.LoggedOut():
+				return
+			}
+		}
+	}()
+
+	if err := uc.SyncIMAPMailbox(imapClient, sender); err != nil {
+		return err
+	}
+	if ok, _ := imapClient.Support("IDLE"); !ok {
+		im.Logger.Printf("IMAP server of sender %d has no IDLE, polling every %s", sender.ID, idlePollInterval)
+	}
+	session.live.Store(true)
+
+	resync := time.NewTicker(idleResyncInterval)
+	defer resync.Stop()
+
+	for {
+		stop := make(chan struct{})
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- imapClient.Idle(stop, &client.IdleOptions{PollInterval: idlePollInterval})
+		}()
+
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-idleDone
+			return ctx.Err()
+		case err := <-idleDone:
+			close(stop)
+			if err == nil {
+				err = errors.New("idle ended unexpectedly")
+			}
+			return err
+		case <-changed:
+		case <-resync.C:
+		}
+
+		close(stop)
+		if err := <-idleDone; err != nil {
+			return err
+		}
+		// Commands can only be sent once IDLE is over
+		if err := uc.SyncIMAPMailbox(imapClient, sender); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"mailnexy/config"
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"gorm.io/gorm"
+)
+
+const reputationCheckInterval = 15 * time.Minute
+
+// ReputationWorker is the circuit breaker for senders and campaigns. It keeps
+// the rolling bounce, complaint and deferral rates of every sender up to
+// date, pauses senders and running campaigns whose rates cross the limits and
+// lifts sender pauses once their cool-down is over.
+type ReputationWorker struct {
+	DB     *gorm.DB
+	Config config.ReputationConfig
+	Logger *log.Logger
+}
+
+func NewReputationWorker(db *gorm.DB, cfg config.ReputationConfig, logger *log.Logger) *ReputationWorker {
+	return &ReputationWorker{
+		DB:     db,
+		Config: cfg,
+		Logger: logger,
+	}
+}
+
+func (rw *ReputationWorker) Start(ctx context.Context) {
+	rw.Logger.Printf("Reputation worker started (window %dh, at least %d sends)", rw.Config.WindowHours, rw.Config.MinSends)
+	ticker := time.NewTicker(reputationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		rw.resumeCooledDown()
+		rw.checkSenders()
+		rw.checkCampaigns()
+
+		select {
+		case <-ctx.Done():
+			rw.Logger.Println("Reputation worker shutting down...")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rw *ReputationWorker) windowStart() time.Time {
+	return time.Now().Add(-time.Duration(rw.Config.WindowHours) * time.Hour)
+}
+
+// resumeCooledDown puts paused senders back into rotation once their
+// cool-down has passed
+func (rw *ReputationWorker) resumeCooledDown() {
+	var senders []models.Sender
+	if err := rw.DB.Where("paused_at IS NOT NULL AND paused_until IS NOT NULL AND paused_until <= ?", time.Now()).
+		Find(&senders).Error; err != nil {
+		rw.Logger.Printf("Failed to load paused senders: %v", err)
+		return
+	}
+
+	for _, sender := range senders {
+		if err := utils.ResumeSender(rw.DB, &sender); err != nil {
+			rw.Logger.Printf("Failed to resume sender %d: %v", sender.ID, err)
+			continue
+		}
+		rw.notify(models.Notification{
+			UserID:   sender.UserID,
+			Type:     "sender_resumed",
+			Title:    fmt.Sprintf("Sender %s is sending again", sender.FromEmail),
+			Message:  "The cool-down after the automatic pause is over and the sender is back in rotation.",
+			SenderID: &sender.ID,
+		})
+		rw.Logger.Printf("Sender %d resumed after cool-down", sender.ID)
+	}
+}
+
+// checkSenders refreshes the rates of every sender that attempted email in
+// the window and pauses those above the limits
+func (rw *ReputationWorker) checkSenders() {
+	windowStart := rw.windowStart()
+
+	var senders []models.Sender
+	if err := rw.DB.Where("id IN (?)", rw.DB.Model(&models.CampaignActivity{}).
+		Select("DISTINCT sender_id").
+		Where("created_at >= ?", windowStart)).
+		Find(&senders).Error; err != nil {
+		rw.Logger.Printf("Failed to load senders: %v", err)
+		return
+	}
+
+	for i := range senders {
+		sender := &senders[i]
+
+		// A resumed sender starts with a clean slate
+		since := windowStart
+		if sender.ResumedAt != nil && sender.ResumedAt.After(since) {
+			since = *sender.ResumedAt
+		}
+
+		rates, err := utils.SenderDeliveryRates(rw.DB, sender.ID, since)
+		if err != nil {
+			rw.Logger.Printf("Failed to measure sender %d: %v", sender.ID, err)
+			continue
+		}
+
+		if err := rw.DB.Model(sender).Updates(map[string]interface{}{
+			"bounce_rate":    rates.BounceRate(),
+			"complaint_rate": rates.ComplaintRate(),
+			"deferral_rate":  rates.DeferralRate(),
+		}).Error; err != nil {
+			rw.Logger.Printf("Failed to update rates of sender %d: %v", sender.ID, err)
+		}
+
+		if sender.PausedAt != nil || !sender.IsActive {
+			continue
+		}
+		if reason := utils.SenderErrorBreach(sender, rw.Config); reason != "" {
+			rw.pauseSender(sender, reason)
+		} else if reason := rates.Breach(rw.Config); reason != "" {
+			rw.pauseSender(sender, reason)
+		}
+	}
+}
+
+func (rw *ReputationWorker) pauseSender(sender *models.Sender, reason string) {
+	if err := utils.PauseSender(rw.DB, sender, reason, rw.Config); err != nil {
+		rw.Logger.Printf("Failed to pause sender %d: %v", sender.ID, err)
+		return
+	}
+	rw.Logger.Printf("Paused sender %d: %s", sender.ID, reason)
+}
+
+// checkCampaigns pauses running campaigns whose own rates cross the limits
+func (rw *ReputationWorker) checkCampaigns() {
+	windowStart := rw.windowStart()
+
+	var campaigns []models.Campaign
+	if err := rw.DB.Where("status = ?", "sending").Find(&campaigns).Error; err != nil {
+		rw.Logger.Printf("Failed to load running campaigns: %v", err)
+		return
+	}
+
+	for i := range campaigns {
+		campaign := &campaigns[i]
+
+		// Only count sends since the campaign was last started
+		since := windowStart
+		if campaign.StartedAt != nil && campaign.StartedAt.After(since) {
+			since = *campaign.StartedAt
+		}
+
+		rates, err := utils.CampaignDeliveryRates(rw.DB, campaign.ID, since)
+		if err != nil {
+			rw.Logger.Printf("Failed to measure campaign %d: %v", campaign.ID, err)
+			continue
+		}
+		if reason := rates.Breach(rw.Config); reason != "" {
+			rw.pauseCampaign(campaign, reason)
+		}
+	}
+}
+
+func (rw *ReputationWorker) pauseCampaign(campaign *models.Campaign, reason string) {
+	if err := utils.PauseCampaign(rw.DB, campaign, reason, "Review the lead list and content, then start the campaign again."); err != nil {
+		rw.Logger.Printf("Failed to pause campaign %d: %v", campaign.ID, err)
+		return
+	}
+	rw.Logger.Printf("Paused campaign %d: %s", campaign.ID, reason)
+}
+
+func (rw *ReputationWorker) notify(notification models.Notification) {
+	if err := rw.DB.Create(&notification).Error; err != nil {
+		rw.Logger.Printf("Failed to store notification for user %d: %v", notification.UserID, err)
+	}
+}
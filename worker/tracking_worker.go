@@ -0,0 +1,322 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"mailnexy/models"
+	"mailnexy/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	trackingBatchSize   = 500
+	trackingBatchWait   = time.Second
+	trackingDedupWindow = 10 * time.Second
+	// Batches that fail to apply are requeued and tried again with back-off,
+	// up to trackingMaxAttempts times per event
+	trackingMaxAttempts = 10
+	trackingMaxBackoff  = time.Minute
+)
+
+// TrackingWorker drains the tracking queue and applies opens and clicks to
+// the database in batches
+type TrackingWorker struct {
+	DB     *gorm.DB
+	Queue  utils.TrackingQueue
+	Logger *log.Logger
+
+	// seen remembers recent hits so client prefetches and double loads of
+	// the same pixel or link only count once
+	seen map[string]time.Time
+
+	failures int // batches in a row that failed to apply
+}
+
+func NewTrackingWorker(db *gorm.DB, queue utils.TrackingQueue, logger *log.Logger) *TrackingWorker {
+	return &TrackingWorker{
+		DB:     db,
+		Queue:  queue,
+		Logger: logger,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+func (tw *TrackingWorker) Start(ctx context.Context) {
+	tw.Logger.Println("Tracking worker started")
+
+	for {
+		events, err := tw.Queue.Dequeue(ctx, trackingBatchSize, trackingBatchWait)
+		if ctx.Err() != nil {
+			// Apply whatever is still buffered in-process before exiting
+			tw.flush()
+			tw.Logger.Println("Tracking worker shutting down...")
+			return
+		}
+		if err != nil {
+			tw.Logger.Printf("Failed to read tracking events: %v", err)
+			time.Sleep(trackingBatchWait)
+			continue
+		}
+		if len(events) > 0 && !tw.process(events) {
+			backoff := trackingBatchWait << min(tw.failures-1, 6)
+			if backoff > trackingMaxBackoff {
+				backoff = trackingMaxBackoff
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+func (tw *TrackingWorker) flush() {
+	for {
+		events, err := tw.Queue.Dequeue(context.Background(), trackingBatchSize, 0)
+		if err != nil || len(events) == 0 {
+			return
+		}
+		if !tw.process(events) {
+			// Requeued events are left for the next start
+			return
+		}
+	}
+}
+
+// process applies a batch and puts it back on the queue when that fails. It
+// reports whether the batch was applied.
+func (tw *TrackingWorker) process(events []utils.TrackingEvent) bool {
+	unique := tw.dedupe(events)
+	if len(unique) == 0 {
+		return true
+	}
+
+	err := tw.applyBatch(unique)
+	if err == nil {
+		tw.failures = 0
+		return true
+	}
+	tw.failures++
+
+	// The retry must not be mistaken for a repeated hit
+	for _, event := range unique {
+		delete(tw.seen, dedupeKey(event))
+	}
+
+	retry := make([]utils.TrackingEvent, 0, len(unique))
+	for _, event := range unique {
+		event.Attempts++
+		if event.Attempts < trackingMaxAttempts {
+			retry = append(retry, event)
+		}
+	}
+	if dropped := len(unique) - len(retry); dropped > 0 {
+		tw.Logger.Printf("Dropping %d tracking events after %d failed attempts", dropped, trackingMaxAttempts)
+	}
+
+	tw.Logger.Printf("Failed to apply batch of %d tracking events, requeueing: %v", len(unique), err)
+	if err := tw.Queue.Requeue(retry); err != nil {
+		tw.Logger.Printf("Failed to requeue %d tracking events, they are lost: %v", len(retry), err)
+	}
+	return false
+}
+
+// activityDelta accumulates every hit for one message in a batch
+type activityDelta struct {
+	opens      int
+	clicks     int
+	firstOpen  *time.Time
+	firstClick *time.Time
+	last       utils.TrackingEvent
+	links      map[string]*linkDelta
+}
+
+type linkDelta struct {
+	count int
+	first time.Time
+}
+
+type campaignDelta struct {
+	opens        int
+	uniqueOpens  int
+	clicks       int
+	uniqueClicks int
+}
+
+// applyBatch writes a batch of deduplicated events to the database in one
+// transaction
+func (tw *TrackingWorker) applyBatch(events []utils.TrackingEvent) error {
+	deltas := make(map[string]*activityDelta)
+	var messageIDs []string
+
+	for _, event := range events {
+		delta, ok := deltas[event.MessageID]
+		if !ok {
+			delta = &activityDelta{links: make(map[string]*linkDelta)}
+			deltas[event.MessageID] = delta
+			messageIDs = append(messageIDs, event.MessageID)
+		}
+
+		at := event.OccurredAt
+		switch event.Type {
+		case utils.TrackingEventOpen:
+			delta.opens++
+			if delta.firstOpen == nil || at.Before(*delta.firstOpen) {
+				delta.firstOpen = &at
+			}
+		case utils.TrackingEventClick:
+			delta.clicks++
+			if delta.firstClick == nil || at.Before(*delta.firstClick) {
+				delta.firstClick = &at
+			}
+			link, ok := delta.links[event.URL]
+			if !ok {
+				link = &linkDelta{first: at}
+				delta.links[event.URL] = link
+			}
+			link.count++
+		default:
+			continue
+		}
+
+		if !at.Before(delta.last.OccurredAt) {
+			delta.last = event
+		}
+	}
+
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	var activities []models.CampaignActivity
+	if err := tw.DB.Where("message_id IN ?", messageIDs).Find(&activities).Error; err != nil {
+		return fmt.Errorf("failed to load activities: %v", err)
+	}
+
+	campaigns := make(map[uint]*campaignDelta)
+	return tw.DB.Transaction(func(tx *gorm.DB) error {
+		for _, activity := range activities {
+			delta := deltas[activity.MessageID]
+			if delta == nil {
+				continue
+			}
+
+			if err := tw.applyActivityDelta(tx, &activity, delta); err != nil {
+				return err
+			}
+
+			totals, ok := campaigns[activity.CampaignID]
+			if !ok {
+				totals = &campaignDelta{}
+				campaigns[activity.CampaignID] = totals
+			}
+			totals.opens += delta.opens
+			totals.clicks += delta.clicks
+			if delta.opens > 0 && activity.OpenCount == 0 {
+				totals.uniqueOpens++
+			}
+			if delta.clicks > 0 && activity.ClickCount == 0 {
+				totals.uniqueClicks++
+			}
+		}
+
+		for campaignID, totals := range campaigns {
+			if err := tx.Model(&models.Campaign{}).
+				Where("id = ?", campaignID).
+				Updates(map[string]interface{}{
+					"open_count":         gorm.Expr("open_count + ?", totals.opens),
+					"unique_open_count":  gorm.Expr("unique_open_count + ?", totals.uniqueOpens),
+					"click_count":        gorm.Expr("click_count + ?", totals.clicks),
+					"unique_click_count": gorm.Expr("unique_click_count + ?", totals.uniqueClicks),
+				}).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&models.CampaignExecution{}).
+				Where("campaign_id = ?", campaignID).
+				Updates(map[string]interface{}{
+					"opens":  gorm.Expr("opens + ?", totals.opens),
+					"clicks": gorm.Expr("clicks + ?", totals.clicks),
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (tw *TrackingWorker) applyActivityDelta(tx *gorm.DB, activity *models.CampaignActivity, delta *activityDelta) error {
+	// Enrichment runs here rather than in the handler so GeoIP lookups never
+	// delay the pixel or redirect
+	updates := utils.EngagementEnrichment(delta.last.IPAddress, delta.last.UserAgent)
+	if delta.opens > 0 {
+		updates["open_count"] = gorm.Expr("open_count + ?", delta.opens)
+		updates["opened_at"] = gorm.Expr("COALESCE(opened_at, ?)", *delta.firstOpen)
+	}
+	if delta.clicks > 0 {
+		updates["click_count"] = gorm.Expr("click_count + ?", delta.clicks)
+		updates["clicked_at"] = gorm.Expr("COALESCE(clicked_at, ?)", *delta.firstClick)
+	}
+
+	if err := tx.Model(&models.CampaignActivity{}).
+		Where("id = ?", activity.ID).
+		Updates(updates).Error; err != nil {
+		return err
+	}
+
+	for link, hits := range delta.links {
+		result := tx.Model(&models.ClickEvent{}).
+			Where("activity_id = ? AND url = ?", activity.ID, link).
+			Update("count", gorm.Expr("count + ?", hits.count))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			continue
+		}
+
+		if err := tx.Create(&models.ClickEvent{
+			ActivityID: activity.ID,
+			URL:        link,
+			ClickedAt:  hits.first,
+			Count:      hits.count,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupe drops repeated hits from the same client on the same message and
+// link within the dedup window
+func (tw *TrackingWorker) dedupe(events []utils.TrackingEvent) []utils.TrackingEvent {
+	now := time.Now()
+	for key, at := range tw.seen {
+		if now.Sub(at) > trackingDedupWindow {
+			delete(tw.seen, key)
+		}
+	}
+
+	unique := events[:0]
+	for _, event := range events {
+		if event.MessageID == "" {
+			continue
+		}
+
+		key := dedupeKey(event)
+		if at, ok := tw.seen[key]; ok && event.OccurredAt.Sub(at) < trackingDedupWindow {
+			continue
+		}
+		tw.seen[key] = event.OccurredAt
+		unique = append(unique, event)
+	}
+	return unique
+}
+
+func dedupeKey(event utils.TrackingEvent) string {
+	return event.Type + "\x00" + event.MessageID + "\x00" + event.URL + "\x00" + event.IPAddress + "\x00" + event.UserAgent
+}
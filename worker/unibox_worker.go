@@ -8,19 +8,21 @@ import (
 	controller "mailnexy/controllers"
 	"mailnexy/models"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/valyala/fasthttp"
 	"gorm.io/gorm"
 )
 
+// UniboxWorker polls the mailboxes of every sender that is not covered by a
+// live IMAP IDLE session
 type UniboxWorker struct {
 	db     *gorm.DB
+	idle   *IMAPIdleManager
 	logger *log.Logger
 }
 
-func NewUniboxWorker(db *gorm.DB, logger *log.Logger) *UniboxWorker {
+func NewUniboxWorker(db *gorm.DB, idle *IMAPIdleManager, logger *log.Logger) *UniboxWorker {
 	return &UniboxWorker{
 		db:     db,
+		idle:   idle,
 		logger: logger,
 	}
 }
@@ -46,29 +48,22 @@ func (uw *UniboxWorker) fetchAllEmails() {
 
 	// Get all users with senders configured
 	var users []models.User
-	if err := uw.db.Preload("Senders", "imap_host IS NOT NULL AND imap_host != ''").Find(&users).Error; err != nil {
+	if err := uw.db.Preload("Senders", "(imap_host IS NOT NULL AND imap_host != '') OR (oauth_refresh_token IS NOT NULL AND oauth_refresh_token != '' AND needs_reconnect = ?)", false).Find(&users).Error; err != nil {
 		uw.logger.Printf("Failed to fetch users: %v", err)
 		return
 	}
 
 	uniboxController := controller.NewUniboxController(uw.db, uw.logger)
 
-	// Create a minimal Fiber app to get the proper context
-	app := fiber.New()
-
 	for _, user := range users {
-		if len(user.Senders) == 0 {
-			continue
-		}
-
-		// Create a proper Fiber v2 context
-		fctx := app.AcquireCtx(&fasthttp.RequestCtx{})
-		defer app.ReleaseCtx(fctx)
-		fctx.Locals("user", &user)
-
-		if err := uniboxController.FetchEmails(fctx); err != nil {
-			uw.logger.Printf("Failed to fetch emails for user %d: %v", user.ID, err)
-			continue
+		for i := range user.Senders {
+			sender := &user.Senders[i]
+			if sender.IMAPHost != "" && uw.idle.Watching(sender.ID) {
+				continue
+			}
+			if err := uniboxController.SyncSender(sender); err != nil {
+				uw.logger.Printf("Failed to fetch emails from sender %d: %v", sender.ID, err)
+			}
 		}
 	}
 }